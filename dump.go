@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ghetzel/shmtool/shm"
+)
+
+// The sizes of the values dump can group bytes into.  The byte order of anything larger than a byte
+// is given by dumpEndians.
+var dumpGroups = map[string]int{
+	`byte`: 1,
+	`u8`:   1,
+	`u16`:  2,
+	`u32`:  4,
+	`u64`:  8,
+}
+
+// The byte orders dump can decode grouped values in.
+var dumpEndians = map[string]shm.Endianness{
+	`host`:   shm.HostEndian,
+	`little`: shm.LittleEndian,
+	`big`:    shm.BigEndian,
+}
+
+// Formats memory like hexdump -C: an offset, the bytes (or larger values) in hex, and the printable
+// characters among them, with runs of identical lines collapsed into a single "*".
+type hexDumper struct {
+	// The number of bytes shown on each line.
+	Width int
+
+	// The number of bytes shown as each value.
+	Group int
+
+	// The byte order used to decode values of more than one byte.
+	Order binary.ByteOrder
+
+	// Whether to show every line, even ones identical to the line before.
+	Verbose bool
+}
+
+// Checks that the options make sense together.
+func (self *hexDumper) validate() error {
+	if self.Group <= 0 {
+		return fmt.Errorf("The group size must be positive")
+	} else if self.Width <= 0 || self.Width%self.Group != 0 {
+		return fmt.Errorf("The width must be a positive multiple of the group size (%d)", self.Group)
+	}
+
+	return nil
+}
+
+// Writes the dump of data to w, numbering lines from the given offset.
+func (self *hexDumper) Dump(w io.Writer, data []byte, base int64) error {
+	if err := self.validate(); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(w)
+	digits := len(fmt.Sprintf("%x", base+int64(len(data))))
+
+	if digits < 8 {
+		digits = 8
+	}
+
+	// each value is two characters per byte, separated by spaces, with another space halfway along the
+	// line if a value starts there
+	hexWidth := (self.Width/self.Group)*(2*self.Group+1) - 1
+
+	if half := self.Width / 2; half > 0 && half%self.Group == 0 {
+		hexWidth++
+	}
+
+	squeezing := false
+
+	for off := 0; off < len(data); off += self.Width {
+		end := off + self.Width
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		line := data[off:end]
+
+		if !self.Verbose && off > 0 && len(line) == self.Width && bytes.Equal(line, data[off-self.Width:off]) {
+			if !squeezing {
+				out.WriteString("*\n")
+				squeezing = true
+			}
+
+			continue
+		}
+
+		squeezing = false
+
+		fmt.Fprintf(out, "%0*x  %-*s  |%s|\n", digits, base+int64(off), hexWidth, self.hex(line), printable(line))
+	}
+
+	fmt.Fprintf(out, "%0*x\n", digits, base+int64(len(data)))
+
+	return out.Flush()
+}
+
+// formats a line of bytes as groups of hex values
+func (self *hexDumper) hex(line []byte) string {
+	var buf strings.Builder
+
+	for i := 0; i < len(line); i += self.Group {
+		if i > 0 {
+			buf.WriteByte(' ')
+
+			if i == self.Width/2 {
+				buf.WriteByte(' ')
+			}
+		}
+
+		// a trailing partial group is shown byte by byte, since it can't be decoded
+		if i+self.Group > len(line) {
+			fmt.Fprintf(&buf, "%x", line[i:])
+			continue
+		}
+
+		switch self.Group {
+		case 2:
+			fmt.Fprintf(&buf, "%04x", self.Order.Uint16(line[i:]))
+		case 4:
+			fmt.Fprintf(&buf, "%08x", self.Order.Uint32(line[i:]))
+		case 8:
+			fmt.Fprintf(&buf, "%016x", self.Order.Uint64(line[i:]))
+		default:
+			fmt.Fprintf(&buf, "%02x", line[i])
+		}
+	}
+
+	return buf.String()
+}
+
+// replaces the bytes that aren't printable ASCII with dots
+func printable(line []byte) string {
+	text := make([]byte, len(line))
+
+	for i, b := range line {
+		if b >= 0x20 && b < 0x7f {
+			text[i] = b
+		} else {
+			text[i] = '.'
+		}
+	}
+
+	return string(text)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestHexDumperValidate(t *testing.T) {
+	tests := []struct {
+		width int
+		group int
+		valid bool
+	}{
+		{16, 1, true},
+		{32, 8, true},
+		{8, 2, true},
+		{0, 1, false},
+		{16, 0, false},
+		{10, 4, false},
+		{12, 8, false},
+		{-16, 1, false},
+	}
+
+	for _, test := range tests {
+		dumper := &hexDumper{Width: test.width, Group: test.group, Order: binary.LittleEndian}
+
+		if err := dumper.validate(); (err == nil) != test.valid {
+			t.Errorf("width=%d group=%d: expected valid=%v, got: %v", test.width, test.group, test.valid, err)
+		}
+
+		// Dump refuses to write anything with options that don't make sense
+		if !test.valid {
+			var out bytes.Buffer
+
+			if err := dumper.Dump(&out, []byte(`data`), 0); err == nil || out.Len() != 0 {
+				t.Errorf("width=%d group=%d: expected Dump to fail without output; got %q, %v", test.width, test.group, out.String(), err)
+			}
+		}
+	}
+}
+
+func TestHexDumper(t *testing.T) {
+	zeros := make([]byte, 40)
+	sequence := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	tests := []struct {
+		name     string
+		dumper   hexDumper
+		data     []byte
+		base     int64
+		expected []string
+	}{
+		{
+			// the same output as hexdump -C, including the padding of the last, partial line
+			name:   `canonical`,
+			dumper: hexDumper{Width: 16, Group: dumpGroups[`byte`]},
+			data:   []byte(`0123456789abcdefXYZ`),
+			expected: []string{
+				`00000000  30 31 32 33 34 35 36 37  38 39 61 62 63 64 65 66  |0123456789abcdef|`,
+				`00000010  58 59 5a                                          |XYZ|`,
+				`00000013`,
+			},
+		}, {
+			name:   `u8 is the same as byte`,
+			dumper: hexDumper{Width: 16, Group: dumpGroups[`u8`]},
+			data:   []byte("Hello, world.\n"),
+			expected: []string{
+				`00000000  48 65 6c 6c 6f 2c 20 77  6f 72 6c 64 2e 0a        |Hello, world..|`,
+				`0000000e`,
+			},
+		}, {
+			name:   `squeezed`,
+			dumper: hexDumper{Width: 16, Group: 1},
+			data:   zeros,
+			expected: []string{
+				`00000000  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|`,
+				`*`,
+				`00000020  00 00 00 00 00 00 00 00                           |........|`,
+				`00000028`,
+			},
+		}, {
+			name:   `verbose`,
+			dumper: hexDumper{Width: 16, Group: 1, Verbose: true},
+			data:   zeros[:32],
+			expected: []string{
+				`00000000  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|`,
+				`00000010  00 00 00 00 00 00 00 00  00 00 00 00 00 00 00 00  |................|`,
+				`00000020`,
+			},
+		}, {
+			name:   `u16 little endian`,
+			dumper: hexDumper{Width: 8, Group: 2, Order: binary.LittleEndian},
+			data:   sequence,
+			expected: []string{
+				`00000000  0201 0403  0605 0807  |........|`,
+				`00000008`,
+			},
+		}, {
+			name:   `u16 big endian`,
+			dumper: hexDumper{Width: 8, Group: 2, Order: binary.BigEndian},
+			data:   sequence,
+			expected: []string{
+				`00000000  0102 0304  0506 0708  |........|`,
+				`00000008`,
+			},
+		}, {
+			// a trailing partial group can't be decoded, so it is shown byte by byte
+			name:   `u32 with a partial group`,
+			dumper: hexDumper{Width: 8, Group: 4, Order: binary.LittleEndian},
+			data:   sequence[:6],
+			expected: []string{
+				`00000000  04030201  0506      |......|`,
+				`00000006`,
+			},
+		}, {
+			name:   `u64 across lines`,
+			dumper: hexDumper{Width: 8, Group: 8, Order: binary.BigEndian},
+			data:   append(sequence, sequence...),
+			expected: []string{
+				`00000000  0102030405060708  |........|`,
+				`*`,
+				`00000010`,
+			},
+		}, {
+			// the middle of the line falls inside a value, so there is no extra space
+			name:   `u64 with an odd number of values`,
+			dumper: hexDumper{Width: 24, Group: 8, Order: binary.LittleEndian},
+			data:   bytes.Repeat(sequence, 3),
+			expected: []string{
+				`00000000  0807060504030201 0807060504030201 0807060504030201  |........................|`,
+				`00000018`,
+			},
+		}, {
+			// offsets wider than 8 digits widen the offset column
+			name:   `large base`,
+			dumper: hexDumper{Width: 16, Group: 1},
+			data:   []byte(`AB`),
+			base:   0x100000000,
+			expected: []string{
+				`100000000  41 42                                             |AB|`,
+				`100000002`,
+			},
+		}, {
+			name:     `empty`,
+			dumper:   hexDumper{Width: 16, Group: 1},
+			base:     0x40,
+			expected: []string{`00000040`},
+		},
+	}
+
+	for _, test := range tests {
+		var out bytes.Buffer
+
+		if err := test.dumper.Dump(&out, test.data, test.base); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if expected := strings.Join(test.expected, "\n") + "\n"; out.String() != expected {
+			t.Errorf("%s: wrong output; expected:\n%s\ngot:\n%s", test.name, expected, out.String())
+		}
+	}
+}
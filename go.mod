@@ -1,9 +1,25 @@
 module github.com/ghetzel/shmtool
 
-go 1.12
+go 1.17
 
 require (
 	github.com/ghetzel/cli v0.0.0-20160426024742-4733699ce30f
 	github.com/ghetzel/go-stockutil v1.8.3
-	golang.org/x/sys v0.0.0-20190422165155-953cdadca894
+	golang.org/x/sys v0.13.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/fatih/structs v1.0.0 // indirect
+	github.com/ghetzel/uuid v0.0.0-20171129191014-dec09d789f3d // indirect
+	github.com/hashicorp/errwrap v1.0.0 // indirect
+	github.com/hashicorp/go-multierror v1.0.0 // indirect
+	github.com/jbenet/go-base58 v0.0.0-20150317085156-6237cf65f3a6 // indirect
+	github.com/jdkato/prose v1.1.0 // indirect
+	github.com/juliangruber/go-intersect v1.0.0 // indirect
+	github.com/mattn/go-colorable v0.1.0 // indirect
+	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/mgutz/ansi v0.0.0-20170206155736-9520e82c474b // indirect
+	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7 // indirect
+	gopkg.in/neurosnap/sentences.v1 v1.0.6 // indirect
 )
@@ -2,10 +2,18 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ghetzel/cli"
 	"github.com/ghetzel/go-stockutil/log"
@@ -38,65 +46,132 @@ func main() {
 		{
 			Name:      `open`,
 			Usage:     `Create or open a shared memory buffer and write the contents of standard input to it`,
-			ArgsUsage: `[ID]`,
+			ArgsUsage: `[ID | URI]`,
 			Flags: []cli.Flag{
 				cli.IntFlag{
 					Name:  `offset, o`,
-					Usage: `The number of bytes to skip before beginning the write operation`,
+					Usage: `The number of bytes to skip before beginning the write operation (relative to the payload, if the segment has a segment header)`,
 				},
 				cli.IntFlag{
 					Name:  `size, s`,
 					Usage: `The size (in bytes) of the shared memory segment (if creating)`,
 				},
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Create or open the segment identified by this IPC key (e.g.: 0x1234)`,
+				},
+				cli.StringFlag{
+					Name:  `path, p`,
+					Usage: `Create or open the segment whose IPC key is generated from this file path`,
+				},
+				cli.IntFlag{
+					Name:  `proj-id`,
+					Usage: `The project identifier used to generate a key from --path`,
+					Value: shm.DefaultProjectId,
+				},
+				cli.IntFlag{
+					Name:  `semaphores`,
+					Usage: `Also create or open a semaphore set with this many semaphores that shares the segment's key`,
+				},
+				cli.StringFlag{
+					Name:  `content-type, t`,
+					Usage: `Write a segment header describing the payload as having this content type (the offset is then relative to the payload)`,
+				},
+				cli.StringSliceFlag{
+					Name:  `meta, m`,
+					Usage: `Write a segment header with this KEY=VALUE metadata (may be repeated)`,
+				},
 			},
 			Action: func(c *cli.Context) {
-				var size int
+				var region shm.Region
+				var err error
 
-				if c.NArg() == 0 {
-					size = c.Int(`size`)
+				size := c.Int(`size`)
+				key, hasKey := keyFromFlags(c)
 
+				if name, ok := posixName(c.Args().First()); ok && size > 0 {
+					region, err = shm.OpenPosixSegment(name, int64(size), os.O_RDWR|os.O_CREATE, 0600)
+				} else if hasKey && size > 0 {
+					region, err = shm.OpenSegmentWithKey(key, size, shm.IpcCreate, 0600)
+				} else if c.NArg() == 0 && !hasKey {
 					if size == 0 {
 						log.Fatalf("Must specify a segment size")
 					}
+
+					region, err = shm.Create(size)
+				} else {
+					region = regionFromArgs(c)
 				}
 
-				var segment *shm.Segment
-				var err error
+				if err != nil {
+					fatalf(err, "Failed to open shared memory: %v", err)
+				}
 
-				if size > 0 {
-					segment, err = shm.Create(size)
-				} else {
-					if segmentId, err := strconv.ParseUint(c.Args().First(), 10, 64); err == nil {
-						if segment, err = shm.Open(int(segmentId)); err != nil {
-							log.Fatalf("Failed to open segment %d: %v", segmentId, err)
+				defer region.Close()
+
+				offset := int64(c.Int(`offset`))
+
+				if c.IsSet(`content-type`) || c.IsSet(`meta`) {
+					header := &shm.Header{
+						ContentType: c.String(`content-type`),
+						Metadata:    make(map[string]string),
+					}
+
+					for _, pair := range c.StringSlice(`meta`) {
+						if kv := strings.SplitN(pair, `=`, 2); len(kv) == 2 {
+							header.Metadata[kv[0]] = kv[1]
+						} else {
+							log.Fatalf("Metadata must be given as KEY=VALUE (got %q)", pair)
 						}
-					} else {
-						log.Fatalf("Failed to parse segment ID: %v", err)
-						return
 					}
-				}
 
-				if err == nil {
-					if offset := int64(c.Int(`offset`)); offset > 0 {
-						segment.Seek(offset, 0)
+					if err := shm.WriteHeader(region, header); err != nil {
+						fatalf(err, "Failed to write segment header: %v", err)
 					}
 
-					log.Debugf("Opened shared memory segment %d: size is %d, offset is %d", segment.Id, segment.Size, segment.Position())
+					offset += header.PayloadOffset
+				} else {
+					offset += payloadOffset(region)
+				}
+
+				if segment, ok := region.(*shm.Segment); ok {
+					log.Debugf("Opened shared memory segment %d (key %v): size is %d, offset is %d", segment.Id, segment.Key, segment.Size, offset)
 					fmt.Printf("%d\n", segment.Id)
 
-					if n, err := io.Copy(segment, os.Stdin); err == nil || err == io.EOF {
-						log.Infof("Wrote %d bytes to shared memory", n)
-					} else {
-						log.Errorf("Failed to copy input: %v", err)
+					if n := c.Int(`semaphores`); n > 0 {
+						if set, err := segment.Semaphores(n); err == nil {
+							log.Infof("Opened semaphore set %d (key %v) with %d semaphores", set.Id, set.Key, set.Len())
+						} else {
+							fatalf(err, "Failed to open semaphore set: %v", err)
+						}
 					}
+				} else if info, err := region.Info(); err == nil {
+					log.Debugf("Opened shared memory %s: size is %d, offset is %d", info.URI, region.Len(), offset)
+					fmt.Printf("%s\n", info.URI)
+				}
+
+				var n int64
+
+				// stream straight into the attached memory where possible
+				if memory, ok := region.(shm.SharedMemory); ok {
+					memory.Seek(offset, io.SeekStart)
+					n, err = memory.ReadFrom(os.Stdin)
+				} else {
+					n, err = io.Copy(&regionWriter{region: region, offset: offset}, os.Stdin)
+				}
+
+				if err == nil || err == io.EOF {
+					log.Infof("Wrote %d bytes to shared memory", n)
+				} else if err == io.ErrShortWrite {
+					log.Warningf("Wrote %d bytes, filling the shared memory; any remaining input was ignored", n)
 				} else {
-					log.Fatalf("Failed to open shared memory segment: %v", err)
+					log.Errorf("Failed to copy input: %v", err)
 				}
 			},
 		}, {
 			Name:      `read`,
 			Usage:     `Read the contents of a shared memory buffer to standard output`,
-			ArgsUsage: `ID`,
+			ArgsUsage: `ID | URI`,
 			Flags: []cli.Flag{
 				cli.IntFlag{
 					Name:  `offset, o`,
@@ -106,54 +181,1601 @@ func main() {
 					Name:  `size, s`,
 					Usage: `The number of bytes to read from the shared memory segment`,
 				},
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Read the segment identified by this IPC key instead of by ID`,
+				},
+				cli.BoolFlag{
+					Name:  `consistent, c`,
+					Usage: `Read a complete copy of the latest update to a seqlock snapshot, rather than raw bytes that may be part way through being written (offsets are relative to the snapshot payload); this is the default for segments whose header has the snapshot layout`,
+				},
+				cli.DurationFlag{
+					Name:  `timeout, t`,
+					Usage: `When reading a snapshot, give up if a complete copy cannot be read within this amount of time`,
+				},
+				cli.BoolFlag{
+					Name:  `raw, r`,
+					Usage: `Read the whole segment, including its segment header (if it has one), rather than just the payload, and without going through the sequence lock of a snapshot`,
+				},
 			},
 			Action: func(c *cli.Context) {
-				if id, err := strconv.ParseUint(c.Args().First(), 10, 64); err == nil {
-					segmentId := int(id)
+				region := regionFromArgs(c)
+				defer region.Close()
 
-					if segment, err := shm.Open(segmentId); err == nil {
-						readSize := int64(c.Int(`size`))
+				var base int64
+				size := region.Len()
+				header := headerFromRegion(region)
 
-						if readSize > segment.Size || readSize == 0 {
-							readSize = segment.Size
-						}
+				// snapshots are read through their sequence lock unless the raw bytes were asked for
+				if c.Bool(`consistent`) || (header != nil && header.Layout == shm.LayoutSnapshot && !c.Bool(`raw`)) {
+					readSnapshot(c, region, header)
+					return
+				}
 
-						if offset := int64(c.Int(`offset`)); offset > 0 {
-							segment.Seek(offset, 0)
-						}
+				if header != nil && !c.Bool(`raw`) {
+					base = header.PayloadOffset
+					size = header.PayloadLength
+				}
 
-						log.Debugf("Opened shared memory segment %d: size is %d, offset is %d", segment.Id, segment.Size, segment.Position())
-						log.Debugf("Reading %d bytes...", readSize)
+				readSize := int64(c.Int(`size`))
 
-						if n, err := io.CopyN(os.Stdout, segment, int64(readSize)); err == nil {
-							log.Infof("Read %d bytes from shared memory", n)
-						} else {
-							log.Fatalf("Failed to read from shared memory segment: %v", err)
-						}
+				if readSize > size || readSize == 0 {
+					readSize = size
+				}
+
+				offset := int64(c.Int(`offset`))
+
+				if offset < 0 || offset > size {
+					log.Fatalf("Offset %d is outside of the segment", offset)
+				}
+
+				if offset+readSize > size {
+					readSize = size - offset
+				}
+
+				offset += base
+
+				log.Debugf("Opened shared memory: size is %d, offset is %d", size, offset)
+				log.Debugf("Reading %d bytes...", readSize)
+
+				var n int64
+				var err error
+
+				// sections write straight from memory that is attached read-only, so that segments we
+				// can't write to can still be read, and so that we can't accidentally modify them
+				if memory, ok := region.(shm.SharedMemory); ok {
+					n, err = memory.Section(offset, readSize).WriteTo(os.Stdout)
+				} else {
+					n, err = io.Copy(os.Stdout, io.NewSectionReader(region, offset, readSize))
+				}
+
+				if err == nil {
+					log.Infof("Read %d bytes from shared memory", n)
+				} else {
+					fatalf(err, "Failed to read from shared memory segment: %v", err)
+				}
+			},
+		}, {
+			Name:      `dump`,
+			Usage:     `Show the contents of a shared memory buffer in hex, alongside any printable characters`,
+			ArgsUsage: `ID | URI`,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  `offset, o`,
+					Usage: `The number of bytes to skip before the dumped range`,
+				},
+				cli.IntFlag{
+					Name:  `length, n`,
+					Usage: `The number of bytes to dump (default: to the end of the shared memory)`,
+				},
+				cli.IntFlag{
+					Name:  `width, w`,
+					Usage: `The number of bytes shown on each line`,
+					Value: 16,
+				},
+				cli.StringFlag{
+					Name:  `group, g`,
+					Usage: `Show the bytes as values of this type: "byte", "u16", "u32", or "u64"`,
+					Value: `byte`,
+				},
+				cli.StringFlag{
+					Name:  `endian, e`,
+					Usage: `Decode grouped values in this byte order: "little", "big", or "host" (default: the payload's byte order from the segment header, if there is one, otherwise the host's)`,
+				},
+				cli.BoolFlag{
+					Name:  `no-squeeze, v`,
+					Usage: `Show every line, rather than replacing repeated lines with "*"`,
+				},
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Dump the segment identified by this IPC key instead of by ID`,
+				},
+				cli.BoolFlag{
+					Name:  `raw, r`,
+					Usage: `Dump the whole segment, including its segment header (if it has one), rather than just the payload`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				region := regionFromArgs(c)
+				defer region.Close()
+
+				var base int64
+				size := region.Len()
+				order := shm.HostEndian
+				header := headerFromRegion(region)
+
+				if header != nil {
+					if header.Endianness != shm.UnknownEndian {
+						order = header.Endianness
+					}
+
+					if !c.Bool(`raw`) {
+						base = header.PayloadOffset
+						size = header.PayloadLength
+					}
+				}
+
+				dumper := &hexDumper{
+					Width:   c.Int(`width`),
+					Verbose: c.Bool(`no-squeeze`),
+				}
+
+				if group, ok := dumpGroups[c.String(`group`)]; ok {
+					dumper.Group = group
+				} else {
+					log.Fatalf("Unknown group %q (must be one of: byte, u16, u32, u64)", c.String(`group`))
+				}
+
+				if name := c.String(`endian`); name != `` {
+					if endian, ok := dumpEndians[name]; ok {
+						order = endian
 					} else {
-						log.Fatalf("Failed to open shared memory segment %d: %v", segmentId, err)
+						log.Fatalf("Unknown byte order %q (must be one of: little, big, host)", name)
 					}
+				}
+
+				dumper.Order = order.ByteOrder()
+
+				if err := dumper.validate(); err != nil {
+					log.Fatal(err)
+				}
+
+				offset := int64(c.Int(`offset`))
+				length := int64(c.Int(`length`))
+
+				if offset < 0 || offset > size {
+					log.Fatalf("Offset %d is outside of the segment", offset)
+				}
+
+				if length <= 0 || offset+length > size {
+					length = size - offset
+				}
+
+				var data []byte
+				var err error
+
+				// only copy the requested window out of the shared memory
+				if memory, ok := region.(shm.SharedMemory); ok {
+					data, err = memory.ReadChunk(length, base+offset)
 				} else {
-					log.Fatalf("Must specify a valid segment ID: %v", err)
+					data = make([]byte, length)
+					_, err = region.ReadAt(data, base+offset)
+				}
+
+				if err != nil {
+					fatalf(err, "Failed to read from shared memory segment: %v", err)
+				}
+
+				if err := dumper.Dump(os.Stdout, data, offset); err != nil {
+					fatalf(err, "Failed to write output: %v", err)
 				}
 			},
 		}, {
-			Name:      `rm`,
-			Usage:     `Remove a shared memory segment`,
-			ArgsUsage: `ID`,
+			Name:  `ls`,
+			Usage: `List the shared memory segments present on this system`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `owner, u`,
+					Usage: `Only show segments owned by this user name or UID`,
+				},
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Only show the segment with this IPC key`,
+				},
+				cli.IntFlag{
+					Name:  `min-size, s`,
+					Usage: `Only show segments at least this many bytes in size`,
+				},
+				cli.IntFlag{
+					Name:  `min-attached, a`,
+					Usage: `Only show segments with at least this many attached processes`,
+				},
+				cli.StringFlag{
+					Name:  `sort`,
+					Usage: `Sort segments by this field (one of: ` + strings.Join(segmentSortFields, `, `) + `)`,
+					Value: `id`,
+				},
+				cli.BoolFlag{
+					Name:  `reverse, r`,
+					Usage: `Reverse the sort order`,
+				},
+				cli.StringFlag{
+					Name:  `format, f`,
+					Usage: `The output format (one of: table, json, csv)`,
+					Value: `table`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				segments, err := shm.List()
+
+				if err != nil {
+					fatalf(err, "%v", err)
+				}
+
+				filtered := make([]*shm.SegmentInfo, 0, len(segments))
+				key, hasKey := keyFromFlags(c)
+				owner := -1
+
+				if o := c.String(`owner`); o != `` {
+					if uid, err := lookupUid(o); err == nil {
+						owner = uid
+					} else {
+						log.Fatal(err)
+					}
+				}
+
+				for _, info := range segments {
+					if hasKey && info.Key != key {
+						continue
+					} else if owner >= 0 && info.OwnerUID != owner {
+						continue
+					} else if info.Size < int64(c.Int(`min-size`)) {
+						continue
+					} else if info.Attached < c.Int(`min-attached`) {
+						continue
+					}
+
+					filtered = append(filtered, info)
+				}
+
+				if err := sortSegments(filtered, c.String(`sort`), c.Bool(`reverse`)); err != nil {
+					log.Fatal(err)
+				}
+
+				switch format := c.String(`format`); format {
+				case `table`:
+					printSegmentTable(filtered)
+				case `json`:
+					printJSON(filtered)
+				case `csv`:
+					printSegmentCSV(filtered)
+				default:
+					log.Fatalf("Unsupported output format %q", format)
+				}
+			},
+		}, {
+			Name:      `info`,
+			Usage:     `Show the metadata of a shared memory segment`,
+			ArgsUsage: `ID | URI`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Show the segment identified by this IPC key instead of by ID`,
+				},
+				cli.BoolFlag{
+					Name:  `json, j`,
+					Usage: `Output the segment metadata as JSON`,
+				},
+			},
 			Action: func(c *cli.Context) {
-				if id, err := strconv.ParseUint(c.Args().First(), 10, 64); err == nil {
-					if err := shm.DestroySegment(int(id)); err == nil {
-						log.Infof("Destroyed segment %d", id)
+				region := regionFromArgs(c)
+
+				if info, err := region.Info(); err == nil {
+					var arena *shm.ArenaInfo
+
+					header := headerFromRegion(region)
+
+					if header != nil && header.Layout == shm.LayoutArena {
+						arena = arenaInfo(region, header)
+					}
+
+					if c.Bool(`json`) {
+						printJSON(struct {
+							*shm.SegmentInfo
+							Header *shm.Header    `json:"header,omitempty"`
+							Arena  *shm.ArenaInfo `json:"arena,omitempty"`
+						}{info, header, arena})
 					} else {
-						log.Fatalf("Failed to destroy segment %d: %v", id, err)
+						printSegmentInfo(info)
+
+						if header != nil {
+							fmt.Println()
+							printHeader(header)
+						}
+
+						if arena != nil {
+							fmt.Println()
+							printArenaInfo(arena)
+						}
 					}
 				} else {
-					log.Fatalf("Must specify a segment ID: %v", err)
+					fatalf(err, "Failed to retrieve segment metadata: %v", err)
 				}
 			},
-		},
-	}
+		}, {
+			Name:      `lock`,
+			Usage:     `Lock a shared memory segment into RAM, preventing it from being swapped out`,
+			ArgsUsage: `ID`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Lock the segment identified by this IPC key instead of by ID`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				segment := segmentFromArg(c, 0)
 
-	app.Run(os.Args)
+				if err := segment.Lock(); err == nil {
+					log.Infof("Locked segment %d", segment.Id)
+				} else {
+					fatalf(err, "Failed to lock segment %d: %v", segment.Id, err)
+				}
+			},
+		}, {
+			Name:      `unlock`,
+			Usage:     `Unlock a shared memory segment, allowing it to be swapped out`,
+			ArgsUsage: `ID`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Unlock the segment identified by this IPC key instead of by ID`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				segment := segmentFromArg(c, 0)
+
+				if err := segment.Unlock(); err == nil {
+					log.Infof("Unlocked segment %d", segment.Id)
+				} else {
+					fatalf(err, "Failed to unlock segment %d: %v", segment.Id, err)
+				}
+			},
+		}, {
+			Name:      `chmod`,
+			Usage:     `Change the permissions of a shared memory segment`,
+			ArgsUsage: `MODE ID`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Change the segment identified by this IPC key instead of by ID`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				mode, err := strconv.ParseUint(c.Args().First(), 8, 32)
+
+				if err != nil || mode > 0777 {
+					log.Fatalf("Must specify an octal permission mode (e.g.: 0640)")
+				}
+
+				segment := segmentFromArg(c, 1)
+
+				if err := segment.Chmod(os.FileMode(mode)); err == nil {
+					log.Infof("Changed permissions of segment %d to %04o", segment.Id, mode)
+				} else {
+					fatalf(err, "Failed to change permissions of segment %d: %v", segment.Id, err)
+				}
+			},
+		}, {
+			Name:      `chown`,
+			Usage:     `Change the owning user and/or group of a shared memory segment`,
+			ArgsUsage: `USER[:GROUP] ID`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Change the segment identified by this IPC key instead of by ID`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				var uid, gid = -1, -1
+				var err error
+
+				owner := c.Args().First()
+
+				if owner == `` {
+					log.Fatalf("Must specify an owner")
+				}
+
+				parts := strings.SplitN(owner, `:`, 2)
+
+				if parts[0] != `` {
+					if uid, err = lookupUid(parts[0]); err != nil {
+						log.Fatal(err)
+					}
+				}
+
+				if len(parts) > 1 && parts[1] != `` {
+					if gid, err = lookupGid(parts[1]); err != nil {
+						log.Fatal(err)
+					}
+				}
+
+				segment := segmentFromArg(c, 1)
+
+				if err := segment.Chown(uid, gid); err == nil {
+					log.Infof("Changed ownership of segment %d to %s", segment.Id, owner)
+				} else {
+					fatalf(err, "Failed to change ownership of segment %d: %v", segment.Id, err)
+				}
+			},
+		}, {
+			Name:      `lock-run`,
+			Usage:     `Run a command while holding a mutex stored at an offset of a shared memory segment (relative to the payload, if it has a segment header)`,
+			ArgsUsage: `ID@OFFSET -- COMMAND [ARGS...]`,
+			Flags: []cli.Flag{
+				cli.DurationFlag{
+					Name:  `timeout, t`,
+					Usage: `Give up if the mutex cannot be acquired within this amount of time`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				target := c.Args().First()
+				command := c.Args().Tail()
+
+				if len(command) > 0 && command[0] == `--` {
+					command = command[1:]
+				}
+
+				i := strings.LastIndex(target, `@`)
+
+				if i < 0 {
+					log.Fatalf("Must specify the mutex as ID@OFFSET (e.g.: 12345@0)")
+				} else if len(command) == 0 {
+					log.Fatalf("Must specify a command to run")
+				}
+
+				offset, err := strconv.ParseInt(target[i+1:], 0, 64)
+
+				if err != nil {
+					log.Fatalf("Invalid mutex offset %q", target[i+1:])
+				}
+
+				region := openRegion(target[:i])
+				defer region.Close()
+
+				mapping, err := region.Map(shm.AttachOptions{})
+
+				if err != nil {
+					fatalf(err, "%v", err)
+				}
+
+				defer mapping.Close()
+
+				mutex, err := shm.NewMutex(mapping, payloadOffset(region)+offset)
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				ctx := context.Background()
+
+				if timeout := c.Duration(`timeout`); timeout > 0 {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, timeout)
+					defer cancel()
+				}
+
+				switch err := mutex.LockContext(ctx); err {
+				case nil:
+					log.Debugf("Acquired mutex %s", target)
+				case shm.ErrOwnerDead:
+					log.Warningf("Recovered mutex %s from a process that died while holding it", target)
+				default:
+					fatalf(err, "Failed to acquire mutex %s: %v", target, err)
+				}
+
+				cmd := exec.Command(command[0], command[1:]...)
+				cmd.Stdin = os.Stdin
+				cmd.Stdout = os.Stdout
+				cmd.Stderr = os.Stderr
+
+				err = cmd.Run()
+
+				if uerr := mutex.Unlock(); uerr != nil {
+					log.Errorf("Failed to release mutex %s: %v", target, uerr)
+				}
+
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					os.Exit(exitErr.ExitCode())
+				} else if err != nil {
+					log.Fatalf("Failed to run %s: %v", command[0], err)
+				}
+			},
+		}, {
+			Name:      `pipe-in`,
+			Usage:     `Stream standard input into a ring buffer in shared memory, to be read with pipe-out`,
+			ArgsUsage: `[ID | URI]`,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  `size, s`,
+					Usage: `Create a new segment, with a segment header and a payload of this size (in bytes), to hold the ring buffer`,
+				},
+				cli.BoolFlag{
+					Name:  `drop, d`,
+					Usage: `Discard input that doesn't fit rather than waiting for the reader to catch up`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				var region shm.Region
+				var offset int64
+
+				if c.NArg() == 0 {
+					size := c.Int(`size`)
+
+					if size == 0 {
+						log.Fatalf("Must specify a segment ID or size")
+					}
+
+					header := &shm.Header{
+						Layout: shm.LayoutRing,
+					}
+
+					if segment, err := shm.CreateWithHeader(header, size); err == nil {
+						fmt.Printf("%d\n", segment.Id)
+						region = segment
+						offset = header.PayloadOffset
+					} else {
+						fatalf(err, "Failed to create shared memory: %v", err)
+					}
+				} else {
+					region = regionFromArgs(c)
+					offset = payloadOffset(region)
+				}
+
+				defer region.Close()
+
+				mapping, err := region.Map(shm.AttachOptions{})
+
+				if err != nil {
+					fatalf(err, "%v", err)
+				}
+
+				defer mapping.Close()
+
+				ring, err := shm.InitRing(mapping, offset)
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				if c.Bool(`drop`) {
+					ring.Policy = shm.RingDrop
+				}
+
+				n, err := io.Copy(ring, os.Stdin)
+				ring.CloseWrite()
+
+				if dropped := ring.Dropped(); dropped > 0 {
+					log.Warningf("Dropped %d bytes because the ring buffer was full", dropped)
+				}
+
+				if err == nil {
+					log.Infof("Wrote %d bytes to ring buffer", n)
+				} else {
+					log.Fatalf("Failed to write to ring buffer: %v", err)
+				}
+			},
+		}, {
+			Name:      `pipe-out`,
+			Usage:     `Stream the contents of a ring buffer written by pipe-in to standard output`,
+			ArgsUsage: `ID | URI`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Read from the segment identified by this IPC key instead of by ID`,
+				},
+				cli.DurationFlag{
+					Name:  `wait, w`,
+					Usage: `Wait up to this long for pipe-in to set up the ring buffer`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				region := regionFromArgs(c)
+				defer region.Close()
+
+				mapping, err := region.Map(shm.AttachOptions{})
+
+				if err != nil {
+					fatalf(err, "%v", err)
+				}
+
+				defer mapping.Close()
+
+				offset := payloadOffset(region)
+				ring, err := shm.OpenRing(mapping, offset)
+				deadline := time.Now().Add(c.Duration(`wait`))
+
+				for err == shm.ErrNotRing && time.Now().Before(deadline) {
+					time.Sleep(shm.MutexPollInterval)
+					ring, err = shm.OpenRing(mapping, offset)
+				}
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				n, err := io.Copy(os.Stdout, ring)
+
+				// let the writer know that nobody is reading any more
+				ring.CloseRead()
+
+				if err == nil {
+					log.Infof("Read %d bytes from ring buffer", n)
+				} else {
+					log.Fatalf("Failed to read from ring buffer: %v", err)
+				}
+			},
+		}, {
+			Name:      `notify`,
+			Usage:     `Tell processes watching a segment (with watch or Segment.Wait) that it has changed`,
+			ArgsUsage: `ID`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Notify the segment identified by this IPC key instead of by ID`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				segment := segmentFromArg(c, 0)
+
+				if err := segment.Notify(); err != nil {
+					fatalf(err, "%v", err)
+				}
+			},
+		}, {
+			Name:      `watch`,
+			Usage:     `Print a line (or the payload) each time a producer notifies a segment of a change, or poll any shared memory for changes with --interval`,
+			ArgsUsage: `ID | URI`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  `key, k`,
+					Usage: `Watch the segment identified by this IPC key instead of by ID`,
+				},
+				cli.DurationFlag{
+					Name:  `interval, i`,
+					Usage: `Rather than waiting for notifications, re-read the shared memory this often and report which byte ranges changed (for memory whose writers never call notify)`,
+				},
+				cli.IntFlag{
+					Name:  `block-size, b`,
+					Usage: `With --interval, compare samples in blocks of this many bytes`,
+					Value: 64,
+				},
+				cli.StringFlag{
+					Name:  `format, f`,
+					Usage: `With --interval, how to report changes: "summary" (one line each), "hex" (the old and new contents), or "json" (one object per line)`,
+					Value: `summary`,
+				},
+				cli.IntFlag{
+					Name:  `offset, o`,
+					Usage: `With --interval, the number of bytes to skip before the watched range`,
+				},
+				cli.IntFlag{
+					Name:  `length, l`,
+					Usage: `With --interval, the number of bytes to watch (default: to the end of the shared memory)`,
+				},
+				cli.BoolFlag{
+					Name:  `dump, d`,
+					Usage: `Write the segment's payload to standard output after each change, rather than a line describing it`,
+				},
+				cli.IntFlag{
+					Name:  `count, c`,
+					Usage: `Exit after this many changes (0 to keep watching until interrupted)`,
+				},
+				cli.DurationFlag{
+					Name:  `timeout, t`,
+					Usage: `Exit if no change happens within this amount of time`,
+				},
+			},
+			Action: func(c *cli.Context) {
+				if c.IsSet(`interval`) {
+					region := regionFromArgs(c)
+					defer region.Close()
+
+					if memory, ok := region.(shm.SharedMemory); ok {
+						pollChanges(c, memory)
+					} else {
+						log.Fatalf("Polling is not supported for this kind of shared memory")
+					}
+
+					return
+				}
+
+				segment := segmentFromArg(c, 0)
+
+				// stay attached, rather than attaching the segment for every notification
+				mapping, err := segment.Map(shm.AttachOptions{ReadOnly: true})
+
+				if err != nil {
+					fatalf(err, "%v", err)
+				}
+
+				defer mapping.Close()
+
+				generation, err := mapping.Generation()
+
+				if err != nil {
+					fatalf(err, "%v", err)
+				}
+
+				for i := 0; c.Int(`count`) <= 0 || i < c.Int(`count`); i++ {
+					ctx, cancel := timeoutContext(c.Duration(`timeout`))
+					generation, err = mapping.WaitSince(ctx, generation)
+					cancel()
+
+					if err == context.DeadlineExceeded {
+						log.Infof("No change within %v", c.Duration(`timeout`))
+						return
+					} else if err != nil {
+						fatalf(err, "%v", err)
+					}
+
+					if c.Bool(`dump`) {
+						header := headerFromRegion(segment)
+
+						if header == nil {
+							log.Fatalf("Segment %d no longer has a segment header", segment.Id)
+						}
+
+						if _, err := os.Stdout.Write(mapping.Bytes()[header.PayloadOffset : header.PayloadOffset+header.PayloadLength]); err != nil {
+							log.Fatalf("Failed to write output: %v", err)
+						}
+					} else {
+						fmt.Printf("%s\tgeneration %d\n", time.Now().Format(time.RFC3339Nano), generation)
+					}
+				}
+			},
+		}, {
+			Name:  `queue`,
+			Usage: `Send and receive messages through a message queue in shared memory`,
+			Subcommands: []cli.Command{
+				{
+					Name:      `init`,
+					Usage:     `Set up an empty message queue, discarding the contents of the segment`,
+					ArgsUsage: `[ID | URI]`,
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  `size, s`,
+							Usage: `Create a new segment, with a segment header and a payload of this size (in bytes), to hold the queue`,
+						},
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						var region shm.Region
+						var offset int64
+
+						if _, ok := keyFromFlags(c); c.NArg() == 0 && !ok {
+							size := c.Int(`size`)
+
+							if size == 0 {
+								log.Fatalf("Must specify a segment ID or size")
+							}
+
+							header := &shm.Header{
+								Layout: shm.LayoutQueue,
+							}
+
+							if segment, err := shm.CreateWithHeader(header, size); err == nil {
+								fmt.Printf("%d\n", segment.Id)
+								region = segment
+								offset = header.PayloadOffset
+							} else {
+								fatalf(err, "Failed to create shared memory: %v", err)
+							}
+						} else {
+							region = regionFromArgs(c)
+							offset = payloadOffset(region)
+						}
+
+						defer region.Close()
+
+						mapping, err := region.Map(shm.AttachOptions{})
+
+						if err != nil {
+							fatalf(err, "%v", err)
+						}
+
+						defer mapping.Close()
+
+						if queue, err := shm.InitQueue(mapping, offset); err == nil {
+							log.Infof("Initialized a message queue with room for %d bytes of messages", queue.Cap())
+						} else {
+							log.Fatal(err)
+						}
+					},
+				}, {
+					Name:      `stat`,
+					Usage:     `Show the number of messages in a queue and how full it is`,
+					ArgsUsage: `ID | URI`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+						cli.BoolFlag{
+							Name:  `json, j`,
+							Usage: `Output the queue statistics as JSON`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						queue := queueFromArgs(c)
+
+						if info, err := queue.Stat(); err == nil {
+							if c.Bool(`json`) {
+								printJSON(info)
+							} else {
+								printQueueInfo(info)
+							}
+						} else {
+							log.Fatalf("Failed to retrieve queue statistics: %v", err)
+						}
+					},
+				}, {
+					Name:      `send`,
+					Usage:     `Send each argument (or each line of standard input) as a message`,
+					ArgsUsage: `ID | URI [MESSAGE...]`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+						cli.DurationFlag{
+							Name:  `timeout, t`,
+							Usage: `Give up if there is no room in the queue for a message within this amount of time`,
+						},
+						cli.BoolFlag{
+							Name:  `nonblock, n`,
+							Usage: `Fail immediately if there is no room in the queue for a message`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						queue := queueFromArgs(c)
+						messages := argsAfterRegion(c)
+
+						send := func(message []byte) {
+							var seq uint64
+							var err error
+
+							if c.Bool(`nonblock`) {
+								seq, err = queue.TrySend(message)
+							} else {
+								ctx, cancel := timeoutContext(c.Duration(`timeout`))
+								seq, err = queue.SendContext(ctx, message)
+								cancel()
+							}
+
+							if err == nil {
+								log.Debugf("Sent message %d (%d bytes)", seq, len(message))
+							} else {
+								fatalf(err, "Failed to send message: %v", err)
+							}
+						}
+
+						if len(messages) > 0 {
+							for _, message := range messages {
+								send([]byte(message))
+							}
+						} else {
+							scanner := bufio.NewScanner(os.Stdin)
+							scanner.Buffer(nil, queue.MaxMessageSize()+1)
+
+							for scanner.Scan() {
+								send(scanner.Bytes())
+							}
+
+							if err := scanner.Err(); err != nil {
+								log.Fatalf("Failed to read standard input: %v", err)
+							}
+						}
+					},
+				}, {
+					Name:      `recv`,
+					Usage:     `Receive messages and write each one to standard output, followed by a newline`,
+					ArgsUsage: `ID | URI`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+						cli.IntFlag{
+							Name:  `count, c`,
+							Usage: `The number of messages to receive (0 to keep receiving until interrupted or timed out)`,
+							Value: 1,
+						},
+						cli.DurationFlag{
+							Name:  `timeout, t`,
+							Usage: `Give up if no message arrives within this amount of time`,
+						},
+						cli.BoolFlag{
+							Name:  `nonblock, n`,
+							Usage: `Fail immediately if the queue is empty`,
+						},
+						cli.BoolFlag{
+							Name:  `seq, q`,
+							Usage: `Prefix each message with its sequence number and a tab`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						queue := queueFromArgs(c)
+						count := c.Int(`count`)
+
+						for i := 0; count <= 0 || i < count; i++ {
+							var message *shm.Message
+							var err error
+
+							if c.Bool(`nonblock`) {
+								message, err = queue.TryReceive()
+							} else {
+								ctx, cancel := timeoutContext(c.Duration(`timeout`))
+								message, err = queue.ReceiveContext(ctx)
+								cancel()
+							}
+
+							if err == context.DeadlineExceeded && count <= 0 {
+								return
+							} else if err != nil {
+								fatalf(err, "Failed to receive message: %v", err)
+							}
+
+							if c.Bool(`seq`) {
+								fmt.Printf("%d\t", message.Seq)
+							}
+
+							os.Stdout.Write(message.Data)
+							fmt.Println()
+						}
+					},
+				},
+			},
+		}, {
+			Name:  `kv`,
+			Usage: `Read and modify a hash map stored in shared memory`,
+			Subcommands: []cli.Command{
+				{
+					Name:      `init`,
+					Usage:     `Set up an empty hash map, discarding the contents of the segment`,
+					ArgsUsage: `[ID | URI]`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+						cli.IntFlag{
+							Name:  `capacity, c`,
+							Usage: `The maximum number of entries`,
+							Value: 256,
+						},
+						cli.IntFlag{
+							Name:  `max-key`,
+							Usage: `The maximum size (in bytes) of a key`,
+							Value: 64,
+						},
+						cli.IntFlag{
+							Name:  `max-value`,
+							Usage: `The maximum size (in bytes) of a value`,
+							Value: 256,
+						},
+					},
+					Action: func(c *cli.Context) {
+						var region shm.Region
+						var err error
+
+						header := &shm.Header{
+							Layout: shm.LayoutMap,
+						}
+
+						capacity, maxKey, maxValue := c.Int(`capacity`), c.Int(`max-key`), c.Int(`max-value`)
+
+						if _, ok := keyFromFlags(c); c.NArg() == 0 && !ok {
+							if region, err = shm.CreateWithHeader(header, int(shm.MapSize(capacity, maxKey, maxValue))); err == nil {
+								fmt.Printf("%d\n", region.(*shm.Segment).Id)
+							} else {
+								fatalf(err, "Failed to create shared memory: %v", err)
+							}
+						} else {
+							region = regionFromArgs(c)
+
+							if err := shm.WriteHeader(region, header); err != nil {
+								fatalf(err, "Failed to write segment header: %v", err)
+							}
+						}
+
+						defer region.Close()
+
+						mapping, err := region.Map(shm.AttachOptions{})
+
+						if err != nil {
+							fatalf(err, "%v", err)
+						}
+
+						defer mapping.Close()
+
+						if m, err := shm.InitMap(mapping, header.PayloadOffset, capacity, maxKey, maxValue); err == nil {
+							log.Infof("Initialized a hash map with room for %d entries", m.Cap())
+						} else {
+							fatalf(err, "%v", err)
+						}
+					},
+				}, {
+					Name:      `get`,
+					Usage:     `Print the value stored for a key`,
+					ArgsUsage: `ID | URI KEY`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						m, args := hashMapFromArgs(c)
+
+						if len(args) != 1 {
+							log.Fatalf("Must specify a key")
+						}
+
+						if value, ok, err := m.Get([]byte(args[0])); err != nil {
+							fatalf(err, "%v", err)
+						} else if !ok {
+							fatalf(shm.ErrNotExist, "Key %q not found", args[0])
+						} else {
+							os.Stdout.Write(value)
+							fmt.Println()
+						}
+					},
+				}, {
+					Name:      `put`,
+					Usage:     `Store a value for a key (reading the value from standard input if not given)`,
+					ArgsUsage: `ID | URI KEY [VALUE]`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						var value []byte
+
+						m, args := hashMapFromArgs(c)
+
+						switch len(args) {
+						case 1:
+							if data, err := ioutil.ReadAll(io.LimitReader(os.Stdin, int64(m.MaxValueSize())+1)); err == nil {
+								value = data
+							} else {
+								log.Fatalf("Failed to read standard input: %v", err)
+							}
+						case 2:
+							value = []byte(args[1])
+						default:
+							log.Fatalf("Must specify a key and (optionally) a value")
+						}
+
+						if err := m.Put([]byte(args[0]), value); err != nil {
+							fatalf(err, "Failed to store %q: %v", args[0], err)
+						}
+					},
+				}, {
+					Name:      `del`,
+					Usage:     `Remove a key`,
+					ArgsUsage: `ID | URI KEY`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						m, args := hashMapFromArgs(c)
+
+						if len(args) != 1 {
+							log.Fatalf("Must specify a key")
+						}
+
+						if deleted, err := m.Delete([]byte(args[0])); err != nil {
+							fatalf(err, "%v", err)
+						} else if !deleted {
+							fatalf(shm.ErrNotExist, "Key %q not found", args[0])
+						}
+					},
+				}, {
+					Name:      `dump`,
+					Usage:     `Print every key and value, separated by a tab, sorted by key`,
+					ArgsUsage: `ID | URI`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Use the segment identified by this IPC key instead of by ID`,
+						},
+						cli.BoolFlag{
+							Name:  `json, j`,
+							Usage: `Output the entries as a JSON object`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						m, _ := hashMapFromArgs(c)
+						entries := make(map[string]string)
+
+						if err := m.Range(func(key []byte, value []byte) bool {
+							entries[string(key)] = string(value)
+							return true
+						}); err != nil {
+							fatalf(err, "%v", err)
+						}
+
+						if c.Bool(`json`) {
+							printJSON(entries)
+						} else {
+							keys := make([]string, 0, len(entries))
+
+							for key := range entries {
+								keys = append(keys, key)
+							}
+
+							sort.Strings(keys)
+
+							for _, key := range keys {
+								fmt.Printf("%s\t%s\n", key, entries[key])
+							}
+						}
+					},
+				},
+			},
+		}, {
+			Name:  `sem`,
+			Usage: `Inspect and modify SysV semaphore sets`,
+			Subcommands: []cli.Command{
+				{
+					Name:  `ls`,
+					Usage: `List the semaphore sets present on this system`,
+					Flags: []cli.Flag{
+						cli.BoolFlag{
+							Name:  `json, j`,
+							Usage: `Output the semaphore sets as JSON`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						if sets, err := shm.ListSemaphoreSets(); err == nil {
+							if c.Bool(`json`) {
+								printJSON(sets)
+							} else {
+								printSemaphoreSetTable(sets)
+							}
+						} else {
+							fatalf(err, "%v", err)
+						}
+					},
+				}, {
+					Name:      `info`,
+					Usage:     `Show the metadata and current values of a semaphore set`,
+					ArgsUsage: `ID`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Show the semaphore set identified by this IPC key instead of by ID`,
+						},
+						cli.BoolFlag{
+							Name:  `json, j`,
+							Usage: `Output the semaphore set metadata as JSON`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						if info, err := semaphoreSetFromArg(c, 0).Stat(); err == nil {
+							if c.Bool(`json`) {
+								printJSON(info)
+							} else {
+								printSemaphoreSetInfo(info)
+							}
+						} else {
+							fatalf(err, "Failed to retrieve semaphore set metadata: %v", err)
+						}
+					},
+				}, {
+					Name:      `set`,
+					Usage:     `Set the value of a semaphore`,
+					ArgsUsage: `NUM VALUE ID`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Modify the semaphore set identified by this IPC key instead of by ID`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						num, err := strconv.Atoi(c.Args().Get(0))
+
+						if err != nil || num < 0 {
+							log.Fatalf("Must specify a semaphore number")
+						}
+
+						value, err := strconv.Atoi(c.Args().Get(1))
+
+						if err != nil {
+							log.Fatalf("Must specify a semaphore value")
+						}
+
+						set := semaphoreSetFromArg(c, 2)
+
+						if err := set.SetValue(num, value); err == nil {
+							log.Infof("Set semaphore %d of set %d to %d", num, set.Id, value)
+						} else {
+							fatalf(err, "Failed to set semaphore %d of set %d: %v", num, set.Id, err)
+						}
+					},
+				}, {
+					Name:      `rm`,
+					Usage:     `Remove a semaphore set`,
+					ArgsUsage: `ID`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  `key, k`,
+							Usage: `Remove the semaphore set identified by this IPC key instead of by ID`,
+						},
+					},
+					Action: func(c *cli.Context) {
+						set := semaphoreSetFromArg(c, 0)
+
+						if err := set.Destroy(); err == nil {
+							log.Infof("Destroyed semaphore set %d", set.Id)
+						} else {
+							fatalf(err, "Failed to destroy semaphore set %d: %v", set.Id, err)
+						}
+					},
+				},
+			},
+		}, {
+			Name:      `rm`,
+			Usage:     `Remove a shared memory segment (or other shared memory region)`,
+			ArgsUsage: `ID | URI`,
+			Action: func(c *cli.Context) {
+				region := regionFromArgs(c)
+
+				if err := region.Destroy(); err == nil {
+					log.Infof("Destroyed %s", c.Args().First())
+				} else {
+					fatalf(err, "Failed to destroy %s: %v", c.Args().First(), err)
+				}
+			},
+		},
+	}
+
+	app.Run(os.Args)
+}
+
+// Exit codes for the failures that the shm package distinguishes, so that scripts can tell them apart
+// without parsing messages.  Any other failure exits with ExitFailure.
+const (
+	ExitFailure         = 1
+	ExitNotExist        = 2
+	ExitPermission      = 3
+	ExitExists          = 4
+	ExitLimitExceeded   = 5
+	ExitInvalidSize     = 6
+	ExitInvalidArgument = 7
+)
+
+// Returns the exit code that corresponds to the given error.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, shm.ErrNotExist), errors.Is(err, os.ErrNotExist):
+		return ExitNotExist
+	case errors.Is(err, shm.ErrPermission), errors.Is(err, os.ErrPermission):
+		return ExitPermission
+	case errors.Is(err, shm.ErrExists), errors.Is(err, os.ErrExist):
+		return ExitExists
+	case errors.Is(err, shm.ErrLimitExceeded):
+		return ExitLimitExceeded
+	case errors.Is(err, shm.ErrInvalidSize):
+		return ExitInvalidSize
+	case errors.Is(err, shm.ErrInvalidArgument):
+		return ExitInvalidArgument
+	default:
+		return ExitFailure
+	}
+}
+
+// Logs a fatal error and exits with the exit code that corresponds to err.
+func fatalf(err error, format string, args ...interface{}) {
+	log.Errorf(format, args...)
+	os.Exit(exitCode(err))
+}
+
+// The prefix that identifies a POSIX shared memory object in command arguments (e.g.: posix:/name).
+const posixPrefix = `posix:`
+
+// Returns the POSIX shared memory object name from an argument of the form posix:/name.
+func posixName(arg string) (string, bool) {
+	if strings.HasPrefix(arg, posixPrefix) {
+		return strings.TrimPrefix(arg, posixPrefix), true
+	}
+
+	return ``, false
+}
+
+// Opens the region specified by the --key flag or, failing that, the first argument.
+func regionFromArgs(c *cli.Context) shm.Region {
+	return regionFromArg(c, 0)
+}
+
+// Opens the region specified by the --key flag or, failing that, the nth argument, which is either a
+// SysV segment ID or any URI understood by shm.OpenURI (e.g.: posix:/name, sysv-key:0x42).
+func regionFromArg(c *cli.Context, n int) shm.Region {
+	uri := c.Args().Get(n)
+
+	if key, ok := keyFromFlags(c); ok {
+		uri = `sysv-key:` + key.String()
+	} else if uri == `` {
+		log.Fatalf("Must specify a segment ID or URI")
+	}
+
+	return openRegion(uri)
+}
+
+// Opens the region identified by a SysV segment ID or URI, exiting if it cannot be opened.
+func openRegion(uri string) shm.Region {
+	if region, err := shm.OpenURI(uri); err == nil {
+		return region
+	} else {
+		fatalf(err, "%v", err)
+		return nil
+	}
+}
+
+// Opens the SysV segment specified by the --key flag or, failing that, the nth argument.
+func segmentFromArg(c *cli.Context, n int) *shm.Segment {
+	region := regionFromArg(c, n)
+
+	if segment, ok := region.(*shm.Segment); ok {
+		return segment
+	}
+
+	region.Close()
+	log.Fatalf("This operation is only supported for SysV shared memory segments")
+	return nil
+}
+
+// Opens the semaphore set specified by the --key flag or, failing that, the ID in the nth argument.
+func semaphoreSetFromArg(c *cli.Context, n int) *shm.SemaphoreSet {
+	var set *shm.SemaphoreSet
+	var err error
+
+	if key, ok := keyFromFlags(c); ok {
+		set, err = shm.OpenSemaphoreSetByKey(key)
+	} else if id, perr := strconv.ParseUint(c.Args().Get(n), 10, 31); perr == nil {
+		set, err = shm.OpenSemaphoreSet(int(id))
+	} else {
+		log.Fatalf("Must specify a semaphore set ID")
+	}
+
+	if err != nil {
+		fatalf(err, "%v", err)
+	}
+
+	return set
+}
+
+// Writes a consistent copy of (the requested part of) the payload of the seqlock snapshot stored in
+// the given region to standard output.  The snapshot is expected at the start of the region's payload
+// if the region has a segment header marking it as a snapshot, or at the start of the region if it
+// has no header.
+func readSnapshot(c *cli.Context, region shm.Region, header *shm.Header) {
+	var at int64
+
+	if header != nil {
+		if header.Layout != shm.LayoutSnapshot {
+			log.Fatalf("Cannot read consistently: the segment header describes a %v payload, not a snapshot", header.Layout)
+		}
+
+		at = header.PayloadOffset
+	}
+
+	mapping, err := region.Map(shm.AttachOptions{ReadOnly: true})
+
+	if err != nil {
+		fatalf(err, "%v", err)
+	}
+
+	defer mapping.Close()
+
+	snapshot, err := shm.OpenSnapshot(mapping, at)
+
+	if err == shm.ErrNotSnapshot {
+		log.Fatalf("Cannot read consistently: %v", err)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+
+	size := int64(snapshot.Size())
+	offset := int64(c.Int(`offset`))
+	readSize := int64(c.Int(`size`))
+
+	if offset < 0 || offset > size {
+		log.Fatalf("Offset %d is outside of the snapshot", offset)
+	}
+
+	if readSize <= 0 || offset+readSize > size {
+		readSize = size - offset
+	}
+
+	ctx, cancel := timeoutContext(c.Duration(`timeout`))
+	defer cancel()
+
+	data := make([]byte, offset+readSize)
+
+	if seq, err := snapshot.LoadContext(ctx, data); err == nil {
+		log.Debugf("Read update %d of the snapshot", seq)
+	} else {
+		log.Fatalf("Failed to read a consistent snapshot: %v", err)
+	}
+
+	if n, err := os.Stdout.Write(data[offset:]); err == nil {
+		log.Infof("Read %d bytes from shared memory", n)
+	} else {
+		log.Fatalf("Failed to write output: %v", err)
+	}
+}
+
+// Returns the segment header at the start of the region, or nil if it doesn't have one.  A header
+// that is present but can't be parsed is reported, and treated as absent.
+func headerFromRegion(region shm.Region) *shm.Header {
+	header, err := shm.ReadHeader(region)
+
+	if err == shm.ErrNoHeader {
+		return nil
+	} else if err != nil {
+		log.Warningf("Ignoring segment header: %v", err)
+		return nil
+	}
+
+	return header
+}
+
+// Returns the offset at which the payload of the region starts: just after its segment header, or at
+// the start of the region if it doesn't have one.
+func payloadOffset(region shm.Region) int64 {
+	if header := headerFromRegion(region); header != nil {
+		return header.PayloadOffset
+	}
+
+	return 0
+}
+
+// Returns the usage of the arena in the payload of the given region, or nil (after logging why) if
+// it can't be inspected.
+func arenaInfo(region shm.Region, header *shm.Header) *shm.ArenaInfo {
+	// the arena's lock lives in the segment, so inspecting it requires write access
+	mapping, err := region.Map(shm.AttachOptions{})
+
+	if err != nil {
+		log.Warningf("Cannot inspect arena: %v", err)
+		return nil
+	}
+
+	defer mapping.Close()
+
+	if arena, err := shm.OpenArena(mapping, header.PayloadOffset); err == nil {
+		if info, err := arena.Stat(); err == nil {
+			return info
+		} else {
+			log.Warningf("Cannot inspect arena: %v", err)
+		}
+	} else {
+		log.Warningf("Cannot inspect arena: %v", err)
+	}
+
+	return nil
+}
+
+// Returns the arguments following the one naming the region (if a region was named by argument
+// rather than by the --key flag).
+func argsAfterRegion(c *cli.Context) []string {
+	if _, ok := keyFromFlags(c); ok {
+		return c.Args()
+	}
+
+	return c.Args().Tail()
+}
+
+// Opens the hash map in the segment specified by the --key flag or, failing that, the first argument,
+// and returns it along with the remaining arguments.  The map is expected at the start of the payload
+// of segments whose header has the map layout, or at the start of segments without a header.  The
+// segment stays attached until the process exits.
+func hashMapFromArgs(c *cli.Context) (*shm.Map, []string) {
+	var offset int64
+
+	region := regionFromArgs(c)
+
+	if header := headerFromRegion(region); header != nil {
+		if header.Layout != shm.LayoutMap {
+			log.Fatalf("The segment header describes a %v payload, not a hash map", header.Layout)
+		}
+
+		offset = header.PayloadOffset
+	}
+
+	mapping, err := region.Map(shm.AttachOptions{})
+
+	if err != nil {
+		fatalf(err, "%v", err)
+	}
+
+	m, err := shm.OpenMap(mapping, offset)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return m, argsAfterRegion(c)
+}
+
+// Opens the message queue in the segment specified by the --key flag or, failing that, the first
+// argument.  The queue is expected at the start of the payload of segments with a header, or at the
+// start of segments without one.  The segment stays attached until the process exits.
+func queueFromArgs(c *cli.Context) *shm.Queue {
+	region := regionFromArgs(c)
+	offset := payloadOffset(region)
+	mapping, err := region.Map(shm.AttachOptions{})
+
+	if err != nil {
+		fatalf(err, "%v", err)
+	}
+
+	queue, err := shm.OpenQueue(mapping, offset)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return queue
+}
+
+// Returns a context that expires after the given timeout, or never if it is zero.
+func timeoutContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+
+	return context.WithCancel(context.Background())
+}
+
+// Adapts a Region into an io.Writer that writes sequentially from a starting offset.
+type regionWriter struct {
+	region shm.Region
+	offset int64
+}
+
+func (self *regionWriter) Write(p []byte) (int, error) {
+	n, err := self.region.WriteAt(p, self.offset)
+	self.offset += int64(n)
+	return n, err
+}
+
+// Resolves the IPC key specified by the --key or --path flags, if either was given.
+func keyFromFlags(c *cli.Context) (shm.Key, bool) {
+	if k := c.String(`key`); k != `` {
+		if key, err := shm.ParseKey(k); err == nil {
+			return key, true
+		} else {
+			log.Fatal(err)
+		}
+	} else if c.IsSet(`path`) {
+		if path := c.String(`path`); path != `` {
+			if key, err := shm.KeyFromPath(path, c.Int(`proj-id`)); err == nil {
+				return key, true
+			} else {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	return shm.IpcPrivate, false
 }
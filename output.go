@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/shmtool/shm"
+)
+
+// Writes the given value to standard output as indented JSON.
+func printJSON(value interface{}) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent(``, `  `)
+
+	if err := enc.Encode(value); err != nil {
+		log.Fatalf("Failed to encode output: %v", err)
+	}
+}
+
+// Writes a human-readable description of a segment to standard output.
+func printSegmentInfo(info *shm.SegmentInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "URI:\t%s\n", info.URI)
+
+	// the remaining fields only apply to SysV segments
+	if info.Id < 0 {
+		fmt.Fprintf(tw, "Size:\t%d\n", info.Size)
+		fmt.Fprintf(tw, "Permissions:\t%v (%04o)\n", info.Mode, uint32(info.Mode))
+		fmt.Fprintf(tw, "Owner:\t%s:%s\n", username(info.OwnerUID), groupname(info.OwnerGID))
+		fmt.Fprintf(tw, "Last Changed:\t%s\n", timestamp(info.LastChanged))
+		tw.Flush()
+		return
+	}
+
+	fmt.Fprintf(tw, "ID:\t%d\n", info.Id)
+	fmt.Fprintf(tw, "Key:\t%v\n", info.Key)
+	fmt.Fprintf(tw, "Size:\t%d\n", info.Size)
+	fmt.Fprintf(tw, "Permissions:\t%v (%04o)\n", info.Mode, uint32(info.Mode))
+	fmt.Fprintf(tw, "Owner:\t%s:%s\n", username(info.OwnerUID), groupname(info.OwnerGID))
+	fmt.Fprintf(tw, "Creator:\t%s:%s\n", username(info.CreatorUID), groupname(info.CreatorGID))
+	fmt.Fprintf(tw, "Attached:\t%d\n", info.Attached)
+	fmt.Fprintf(tw, "Creator PID:\t%d\n", info.CreatorPID)
+	fmt.Fprintf(tw, "Last PID:\t%s\n", pid(info.LastPID))
+	fmt.Fprintf(tw, "Last Attached:\t%s\n", timestamp(info.LastAttached))
+	fmt.Fprintf(tw, "Last Detached:\t%s\n", timestamp(info.LastDetached))
+	fmt.Fprintf(tw, "Last Changed:\t%s\n", timestamp(info.LastChanged))
+
+	if status := info.Status(); status != `` {
+		fmt.Fprintf(tw, "Status:\t%s\n", status)
+	} else {
+		fmt.Fprintf(tw, "Status:\t-\n")
+	}
+
+	tw.Flush()
+}
+
+// Writes a human-readable description of a segment header to standard output.
+func printHeader(header *shm.Header) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Header Version:\t%d\n", header.Version)
+	fmt.Fprintf(tw, "Layout:\t%v\n", header.Layout)
+
+	if header.ContentType != `` {
+		fmt.Fprintf(tw, "Content Type:\t%s\n", header.ContentType)
+	} else {
+		fmt.Fprintf(tw, "Content Type:\t-\n")
+	}
+
+	fmt.Fprintf(tw, "Endianness:\t%v\n", header.Endianness)
+	fmt.Fprintf(tw, "Payload:\t%d bytes at offset %d\n", header.PayloadLength, header.PayloadOffset)
+	fmt.Fprintf(tw, "Created:\t%s\n", timestamp(header.Created))
+	fmt.Fprintf(tw, "Generation:\t%d\n", header.Generation)
+
+	keys := make([]string, 0, len(header.Metadata))
+
+	for key := range header.Metadata {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	if len(keys) > 0 {
+		fmt.Fprintf(tw, "Metadata:\t\n")
+	}
+
+	for _, key := range keys {
+		fmt.Fprintf(tw, "  %s:\t%s\n", key, header.Metadata[key])
+	}
+
+	tw.Flush()
+}
+
+// Writes a human-readable description of the usage of an arena to standard output.
+func printArenaInfo(info *shm.ArenaInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Arena Capacity:\t%d\n", info.Capacity)
+	fmt.Fprintf(tw, "Allocations:\t%d (%d bytes)\n", info.Allocations, info.Used)
+	fmt.Fprintf(tw, "Free:\t%d bytes in %d blocks (largest %d)\n", info.Free, info.FreeBlocks, info.LargestFree)
+	fmt.Fprintf(tw, "Fragmentation:\t%.1f%%\n", info.Fragmentation*100)
+	tw.Flush()
+}
+
+var segmentSortFields = []string{`id`, `key`, `size`, `owner`, `attached`, `changed`}
+
+// Sorts a list of segments in place by the named field.
+func sortSegments(segments []*shm.SegmentInfo, field string, reverse bool) error {
+	var less func(a, b *shm.SegmentInfo) bool
+
+	switch field {
+	case `id`, ``:
+		less = func(a, b *shm.SegmentInfo) bool { return a.Id < b.Id }
+	case `key`:
+		less = func(a, b *shm.SegmentInfo) bool { return uint32(a.Key) < uint32(b.Key) }
+	case `size`:
+		less = func(a, b *shm.SegmentInfo) bool { return a.Size < b.Size }
+	case `owner`:
+		less = func(a, b *shm.SegmentInfo) bool { return a.OwnerUID < b.OwnerUID }
+	case `attached`:
+		less = func(a, b *shm.SegmentInfo) bool { return a.Attached < b.Attached }
+	case `changed`:
+		less = func(a, b *shm.SegmentInfo) bool { return a.LastChanged.Before(b.LastChanged) }
+	default:
+		return fmt.Errorf("Cannot sort by %q; must be one of: %v", field, segmentSortFields)
+	}
+
+	sort.SliceStable(segments, func(i, j int) bool {
+		if reverse {
+			return less(segments[j], segments[i])
+		} else {
+			return less(segments[i], segments[j])
+		}
+	})
+
+	return nil
+}
+
+// Writes a list of segments to standard output as an aligned table.
+func printSegmentTable(segments []*shm.SegmentInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ID\tKEY\tOWNER\tPERMS\tSIZE\tATTACHED\tLAST PID\tSTATUS\n")
+
+	for _, info := range segments {
+		status := info.Status()
+
+		if status == `` {
+			status = `-`
+		}
+
+		fmt.Fprintf(
+			tw,
+			"%d\t%v\t%s\t%04o\t%d\t%d\t%s\t%s\n",
+			info.Id,
+			info.Key,
+			username(info.OwnerUID),
+			uint32(info.Mode),
+			info.Size,
+			info.Attached,
+			pid(info.LastPID),
+			status,
+		)
+	}
+
+	tw.Flush()
+}
+
+// Writes a list of segments to standard output as CSV, including a header row.
+func printSegmentCSV(segments []*shm.SegmentInfo) {
+	w := csv.NewWriter(os.Stdout)
+
+	w.Write([]string{
+		`id`, `key`, `size`, `mode`, `owner_uid`, `owner_gid`, `creator_uid`, `creator_gid`,
+		`attached`, `creator_pid`, `last_pid`, `last_attached`, `last_detached`, `last_changed`,
+		`destroy_pending`, `locked`,
+	})
+
+	for _, info := range segments {
+		w.Write([]string{
+			strconv.Itoa(info.Id),
+			info.Key.String(),
+			strconv.FormatInt(info.Size, 10),
+			fmt.Sprintf("%04o", uint32(info.Mode)),
+			strconv.Itoa(info.OwnerUID),
+			strconv.Itoa(info.OwnerGID),
+			strconv.Itoa(info.CreatorUID),
+			strconv.Itoa(info.CreatorGID),
+			strconv.Itoa(info.Attached),
+			strconv.Itoa(info.CreatorPID),
+			strconv.Itoa(info.LastPID),
+			csvTime(info.LastAttached),
+			csvTime(info.LastDetached),
+			csvTime(info.LastChanged),
+			strconv.FormatBool(info.DestroyPending),
+			strconv.FormatBool(info.Locked),
+		})
+	}
+
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		log.Fatalf("Failed to write CSV: %v", err)
+	}
+}
+
+// Writes a list of semaphore sets to standard output as an aligned table.
+func printSemaphoreSetTable(sets []*shm.SemaphoreSetInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ID\tKEY\tOWNER\tPERMS\tSEMAPHORES\tLAST OP\n")
+
+	for _, info := range sets {
+		fmt.Fprintf(
+			tw,
+			"%d\t%v\t%s\t%04o\t%d\t%s\n",
+			info.Id,
+			info.Key,
+			username(info.OwnerUID),
+			uint32(info.Mode),
+			info.Count,
+			timestamp(info.LastOp),
+		)
+	}
+
+	tw.Flush()
+}
+
+// Writes a human-readable description of a semaphore set, and the state of each of its semaphores,
+// to standard output.
+func printSemaphoreSetInfo(info *shm.SemaphoreSetInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "ID:\t%d\n", info.Id)
+	fmt.Fprintf(tw, "Key:\t%v\n", info.Key)
+	fmt.Fprintf(tw, "Semaphores:\t%d\n", info.Count)
+	fmt.Fprintf(tw, "Permissions:\t%v (%04o)\n", info.Mode, uint32(info.Mode))
+	fmt.Fprintf(tw, "Owner:\t%s:%s\n", username(info.OwnerUID), groupname(info.OwnerGID))
+	fmt.Fprintf(tw, "Creator:\t%s:%s\n", username(info.CreatorUID), groupname(info.CreatorGID))
+	fmt.Fprintf(tw, "Last Operation:\t%s\n", timestamp(info.LastOp))
+	fmt.Fprintf(tw, "Last Changed:\t%s\n", timestamp(info.LastChanged))
+	tw.Flush()
+
+	fmt.Println()
+
+	tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "NUM\tVALUE\tLAST PID\tWAITING\tWAITING FOR ZERO\n")
+
+	for i, sem := range info.Semaphores {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%d\n", i, sem.Value, pid(sem.LastPID), sem.Waiting, sem.WaitingForZero)
+	}
+
+	tw.Flush()
+}
+
+// Writes a human-readable description of the state of a message queue to standard output.
+func printQueueInfo(info *shm.QueueInfo) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Version:\t%d\n", info.Version)
+	fmt.Fprintf(tw, "Messages:\t%d\n", info.Count)
+	fmt.Fprintf(tw, "Used:\t%d of %d bytes\n", info.Used, info.Capacity)
+	fmt.Fprintf(tw, "Next Sequence:\t%d\n", info.NextSeq)
+	fmt.Fprintf(tw, "Waiting Senders:\t%d\n", info.Senders)
+	fmt.Fprintf(tw, "Waiting Receivers:\t%d\n", info.Receivers)
+	tw.Flush()
+}
+
+// Resolves a user name or numeric UID into a UID.
+func lookupUid(name string) (int, error) {
+	if uid, err := strconv.Atoi(name); err == nil {
+		return uid, nil
+	}
+
+	if u, err := user.Lookup(name); err == nil {
+		return strconv.Atoi(u.Uid)
+	} else {
+		return -1, err
+	}
+}
+
+// Resolves a group name or numeric GID into a GID.
+func lookupGid(name string) (int, error) {
+	if gid, err := strconv.Atoi(name); err == nil {
+		return gid, nil
+	}
+
+	if g, err := user.LookupGroup(name); err == nil {
+		return strconv.Atoi(g.Gid)
+	} else {
+		return -1, err
+	}
+}
+
+func username(uid int) string {
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		return u.Username
+	}
+
+	return strconv.Itoa(uid)
+}
+
+func groupname(gid int) string {
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		return g.Name
+	}
+
+	return strconv.Itoa(gid)
+}
+
+func pid(p int) string {
+	if p == 0 {
+		return `-`
+	}
+
+	return strconv.Itoa(p)
+}
+
+func csvTime(t time.Time) string {
+	if t.IsZero() {
+		return ``
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return `never`
+	}
+
+	return t.Format(time.RFC3339)
+}
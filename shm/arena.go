@@ -0,0 +1,477 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// Identifies shared memory that has been initialized as an Arena ("SHMA").
+	ArenaMagic = 0x414d4853
+
+	// The version of the Arena layout implemented by this package.
+	ArenaVersion = 1
+
+	// The number of bytes at the start of an Arena occupied by its header; the rest is the heap that
+	// allocations are made from.
+	ArenaHeaderSize = 64
+
+	// Every allocation is aligned to this many bytes, and its size is rounded up to a multiple of it.
+	ArenaAlignment = 16
+)
+
+// every block in the heap starts with a header; allocations return the offset following it
+const (
+	arenaBlockHeader = 16
+	arenaMinBlock    = 32
+	arenaBlockUsed   = 0xa110c8ed
+	arenaBlockFree   = 0xf4eeb10c
+)
+
+var (
+	// Returned by OpenArena when the memory does not contain an Arena.
+	ErrNotArena = errors.New(`shared memory does not contain an arena`)
+
+	// Returned by Alloc and Realloc when no free block is large enough for the allocation.
+	ErrArenaFull = errors.New(`not enough free space in the arena`)
+)
+
+// The header at the start of an Arena.  Everything other than the magic number, version and capacity
+// is protected by the mutex in Lock.  FreeHead is the offset (from the start of the mapping) of the
+// first free block, or zero if there are none.
+type arenaHeader struct {
+	Magic       uint32
+	Version     uint32
+	Capacity    uint64
+	Lock        uint32
+	_           uint32
+	FreeHead    uint64
+	Used        uint64
+	Allocations uint64
+	_           [16]byte
+}
+
+// The header of each block in the heap.  Next is only meaningful in free blocks, where it occupies the
+// first bytes of what would otherwise be the allocation, and links the free blocks in address order.
+type arenaBlock struct {
+	Size uint64
+	Tag  uint32
+	_    uint32
+	Next uint64
+}
+
+// Statistics describing the usage of an Arena.
+type ArenaInfo struct {
+	// The size of the heap, including the space taken by block headers.
+	Capacity int64 `json:"capacity"`
+
+	// The number of bytes in allocated blocks, including their headers.
+	Used int64 `json:"used"`
+
+	// The number of bytes in free blocks.
+	Free int64 `json:"free"`
+
+	// The number of live allocations.
+	Allocations int64 `json:"allocations"`
+
+	// The number of separate free blocks.
+	FreeBlocks int64 `json:"free_blocks"`
+
+	// The size of the largest free block, which bounds the largest possible allocation.
+	LargestFree int64 `json:"largest_free"`
+
+	// The fraction of free space that is not part of the largest free block, from 0 (all free space
+	// is contiguous) approaching 1 (free space is scattered in many small blocks).
+	Fragmentation float64 `json:"fragmentation"`
+}
+
+// A general-purpose allocator that divides part of a mapping into variable-sized allocations, so that
+// cooperating processes can share dynamically-sized data in one segment.  Allocations are identified
+// by their offset from the start of the mapping rather than by pointers, so a handle returned in one
+// process refers to the same allocation in every other process, wherever each has attached the
+// segment.  All of the Arena's bookkeeping (its free list and usage counters) is kept in the segment
+// itself, guarded by a Mutex in its header, so any process can allocate, free and inspect usage.
+//
+// A process that dies while holding the Arena's lock may leave its bookkeeping inconsistent.
+type Arena struct {
+	mapping *Mapping
+	header  *arenaHeader
+	mutex   *Mutex
+	start   uint64
+	end     uint64
+}
+
+// Initializes a new, empty Arena occupying the mapping from the given offset to its end, discarding
+// any allocations previously made there.  The offset must be aligned to a 16 byte boundary.  If the
+// mapping begins with a segment header whose payload starts at the offset, the header's layout is
+// set to LayoutArena.
+//
+func InitArena(mapping *Mapping, offset int64) (*Arena, error) {
+	header, err := arenaAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	start := uint64(offset + ArenaHeaderSize)
+	capacity := (uint64(mapping.Len()) - start) &^ (ArenaAlignment - 1)
+
+	*header = arenaHeader{
+		Version:  ArenaVersion,
+		Capacity: capacity,
+		FreeHead: start,
+	}
+
+	arena, err := newArena(mapping, offset, header)
+
+	if err != nil {
+		return nil, err
+	}
+
+	*arena.block(start) = arenaBlock{
+		Size: capacity,
+		Tag:  arenaBlockFree,
+	}
+
+	atomic.StoreUint32(&header.Magic, ArenaMagic)
+	setHeaderLayout(mapping, offset, LayoutArena)
+
+	return arena, nil
+}
+
+// Opens the Arena stored in the mapping at the given offset, which must have been initialized with
+// InitArena.  Returns ErrNotArena if no Arena is found there.
+//
+func OpenArena(mapping *Mapping, offset int64) (*Arena, error) {
+	header, err := arenaAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if atomic.LoadUint32(&header.Magic) != ArenaMagic {
+		return nil, ErrNotArena
+	} else if header.Version != ArenaVersion {
+		return nil, fmt.Errorf("Unsupported arena layout version %d", header.Version)
+	} else if header.Capacity > uint64(mapping.Len())-uint64(offset+ArenaHeaderSize) {
+		return nil, fmt.Errorf("Arena capacity %d exceeds the space available in the mapping", header.Capacity)
+	}
+
+	return newArena(mapping, offset, header)
+}
+
+func arenaAt(mapping *Mapping, offset int64) (*arenaHeader, error) {
+	data := mapping.Bytes()
+
+	if data == nil {
+		return nil, fmt.Errorf("Cannot use a closed mapping")
+	} else if mapping.ReadOnly() {
+		return nil, fmt.Errorf("Cannot use an arena in a read-only mapping")
+	} else if offset < 0 || offset%ArenaAlignment != 0 {
+		return nil, fmt.Errorf("Offset %d is not aligned to a %d byte boundary", offset, ArenaAlignment)
+	} else if int64(len(data))-offset < ArenaHeaderSize+arenaMinBlock {
+		return nil, fmt.Errorf("Not enough room for an arena at offset %d of a %d byte mapping", offset, len(data))
+	}
+
+	return (*arenaHeader)(unsafe.Pointer(&data[offset])), nil
+}
+
+func newArena(mapping *Mapping, offset int64, header *arenaHeader) (*Arena, error) {
+	mutex, err := NewMutex(mapping, offset+int64(unsafe.Offsetof(header.Lock)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	start := uint64(offset + ArenaHeaderSize)
+
+	return &Arena{
+		mapping: mapping,
+		header:  header,
+		mutex:   mutex,
+		start:   start,
+		end:     start + header.Capacity,
+	}, nil
+}
+
+// Allocates at least n bytes and returns the offset of the allocation from the start of the mapping.
+// Returns ErrArenaFull if there is no free block large enough.
+//
+func (self *Arena) Alloc(n int) (int64, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("Cannot allocate %d bytes: %w", n, ErrInvalidSize)
+	}
+
+	if err := self.lock(); err != nil {
+		return 0, err
+	}
+
+	defer self.mutex.Unlock()
+
+	return self.alloc(blockSize(n))
+}
+
+// Frees the allocation at the given offset, making its space available to future allocations.
+//
+func (self *Arena) Free(handle int64) error {
+	if err := self.lock(); err != nil {
+		return err
+	}
+
+	defer self.mutex.Unlock()
+
+	if at, err := self.used(handle); err == nil {
+		self.free(at)
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Resizes the allocation at the given offset to at least n bytes, preserving its contents (up to the
+// smaller of the old and new sizes), and returns its new offset.  The allocation is resized in place
+// where possible.  A handle of zero allocates a new block, and a size of zero frees the block and
+// returns zero.  If the allocation cannot be resized, it is left unchanged and ErrArenaFull returned.
+//
+func (self *Arena) Realloc(handle int64, n int) (int64, error) {
+	if handle == 0 {
+		return self.Alloc(n)
+	} else if n == 0 {
+		return 0, self.Free(handle)
+	} else if n < 0 {
+		return 0, fmt.Errorf("Cannot allocate %d bytes: %w", n, ErrInvalidSize)
+	}
+
+	if err := self.lock(); err != nil {
+		return 0, err
+	}
+
+	defer self.mutex.Unlock()
+
+	at, err := self.used(handle)
+
+	if err != nil {
+		return 0, err
+	}
+
+	block := self.block(at)
+	need := blockSize(n)
+
+	// absorb the following block if it's free and doing so makes enough room
+	if need > block.Size {
+		if next := at + block.Size; next < self.end && self.block(next).Tag == arenaBlockFree && block.Size+self.block(next).Size >= need {
+			absorbed := self.block(next)
+			self.unlink(next)
+			self.header.Used += absorbed.Size
+			block.Size += absorbed.Size
+			absorbed.Tag = 0
+		}
+	}
+
+	if need <= block.Size {
+		if block.Size-need >= arenaMinBlock {
+			rest := at + need
+			*self.block(rest) = arenaBlock{Size: block.Size - need, Tag: arenaBlockUsed}
+			block.Size = need
+			self.header.Allocations++
+			self.free(rest)
+		}
+
+		return handle, nil
+	}
+
+	moved, err := self.alloc(need)
+
+	if err != nil {
+		return 0, err
+	}
+
+	data := self.mapping.Bytes()
+	copy(data[moved:uint64(moved)+need-arenaBlockHeader], data[handle:at+block.Size])
+	self.free(at)
+
+	return moved, nil
+}
+
+// Returns the memory of the allocation at the given offset, which may be larger than was requested.
+// The slice is only valid while the mapping is open and the allocation has not been freed or moved.
+//
+func (self *Arena) Bytes(handle int64) ([]byte, error) {
+	if at, err := self.used(handle); err == nil {
+		return self.mapping.Bytes()[handle : at+self.block(at).Size], nil
+	} else {
+		return nil, err
+	}
+}
+
+// Returns statistics describing the Arena's current usage.
+//
+func (self *Arena) Stat() (*ArenaInfo, error) {
+	if err := self.lock(); err != nil {
+		return nil, err
+	}
+
+	defer self.mutex.Unlock()
+
+	info := &ArenaInfo{
+		Capacity:    int64(self.header.Capacity),
+		Used:        int64(self.header.Used),
+		Allocations: int64(self.header.Allocations),
+	}
+
+	for at := self.header.FreeHead; at != 0; at = self.block(at).Next {
+		if !self.inHeap(at) || info.FreeBlocks > int64(self.header.Capacity/arenaMinBlock) {
+			return nil, fmt.Errorf("Arena free list is corrupt")
+		}
+
+		size := int64(self.block(at).Size)
+		info.Free += size
+		info.FreeBlocks++
+
+		if size > info.LargestFree {
+			info.LargestFree = size
+		}
+	}
+
+	if info.Free > 0 {
+		info.Fragmentation = 1 - float64(info.LargestFree)/float64(info.Free)
+	}
+
+	return info, nil
+}
+
+// a process that died while holding the lock leaves the arena as it was at that point, which is the
+// best we can do
+func (self *Arena) lock() error {
+	if err := self.mutex.LockContext(context.Background()); err != nil && err != ErrOwnerDead {
+		return err
+	}
+
+	return nil
+}
+
+// the size of the block needed for an allocation of n bytes
+func blockSize(n int) uint64 {
+	size := (uint64(n) + arenaBlockHeader + ArenaAlignment - 1) &^ (ArenaAlignment - 1)
+
+	if size < arenaMinBlock {
+		return arenaMinBlock
+	}
+
+	return size
+}
+
+func (self *Arena) block(at uint64) *arenaBlock {
+	return (*arenaBlock)(unsafe.Pointer(&self.mapping.Bytes()[at]))
+}
+
+func (self *Arena) inHeap(at uint64) bool {
+	return at >= self.start && at+arenaMinBlock <= self.end && (at-self.start)%ArenaAlignment == 0
+}
+
+// returns the offset of the block for the given handle, if it is an allocated block
+func (self *Arena) used(handle int64) (uint64, error) {
+	at := uint64(handle) - arenaBlockHeader
+
+	if handle < arenaBlockHeader || !self.inHeap(at) || self.block(at).Tag != arenaBlockUsed {
+		return 0, fmt.Errorf("Offset %d is not an allocation in this arena", handle)
+	}
+
+	return at, nil
+}
+
+// first-fit allocation of a block of the given size (which must already be rounded)
+func (self *Arena) alloc(need uint64) (int64, error) {
+	for at := self.header.FreeHead; at != 0; at = self.block(at).Next {
+		block := self.block(at)
+
+		if block.Size < need {
+			continue
+		}
+
+		if block.Size-need >= arenaMinBlock {
+			// split, leaving the remainder in the free list where this block was
+			rest := at + need
+
+			*self.block(rest) = arenaBlock{
+				Size: block.Size - need,
+				Tag:  arenaBlockFree,
+				Next: block.Next,
+			}
+
+			self.replace(at, rest)
+			block.Size = need
+		} else {
+			self.unlink(at)
+		}
+
+		block.Tag = arenaBlockUsed
+		self.header.Used += block.Size
+		self.header.Allocations++
+
+		return int64(at + arenaBlockHeader), nil
+	}
+
+	return 0, ErrArenaFull
+}
+
+// returns an allocated block to the free list, merging it with adjacent free blocks
+func (self *Arena) free(at uint64) {
+	block := self.block(at)
+	prev := uint64(0)
+	next := self.header.FreeHead
+
+	for next != 0 && next < at {
+		prev, next = next, self.block(next).Next
+	}
+
+	self.header.Used -= block.Size
+	self.header.Allocations--
+
+	block.Tag = arenaBlockFree
+	block.Next = next
+
+	if prev == 0 {
+		self.header.FreeHead = at
+	} else {
+		self.block(prev).Next = at
+	}
+
+	if next != 0 && at+block.Size == next {
+		block.Size += self.block(next).Size
+		block.Next = self.block(next).Next
+		self.block(next).Tag = 0
+	}
+
+	if prev != 0 && prev+self.block(prev).Size == at {
+		self.block(prev).Size += block.Size
+		self.block(prev).Next = block.Next
+		block.Tag = 0
+	}
+}
+
+// removes a block from the free list
+func (self *Arena) unlink(at uint64) {
+	self.replace(at, self.block(at).Next)
+}
+
+// makes whatever pointed to the free block at the given offset point to another offset instead
+func (self *Arena) replace(at uint64, with uint64) {
+	if self.header.FreeHead == at {
+		self.header.FreeHead = with
+		return
+	}
+
+	for prev := self.header.FreeHead; prev != 0; prev = self.block(prev).Next {
+		if self.block(prev).Next == at {
+			self.block(prev).Next = with
+			return
+		}
+	}
+}
@@ -0,0 +1,277 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// creates a segment with an Arena in it, giving the callback two handles to it using separate
+// attachments
+func makeArena(t *testing.T, callback func(arena *Arena, other *Arena) error) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		amap, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer amap.Close()
+
+		omap, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer omap.Close()
+
+		if _, err := OpenArena(omap, 0); err != ErrNotArena {
+			return fmt.Errorf("Expected ErrNotArena opening an uninitialized arena; got: %v", err)
+		}
+
+		arena, err := InitArena(amap, 0)
+
+		if err != nil {
+			return err
+		}
+
+		other, err := OpenArena(omap, 0)
+
+		if err != nil {
+			return err
+		}
+
+		return callback(arena, other)
+	})
+}
+
+// checks the arena's counters against each other
+func checkArena(arena *Arena, allocations int) (*ArenaInfo, error) {
+	info, err := arena.Stat()
+
+	if err != nil {
+		return nil, err
+	} else if info.Used+info.Free != info.Capacity {
+		return nil, fmt.Errorf("Used (%d) and free (%d) space don't add up to the capacity (%d)", info.Used, info.Free, info.Capacity)
+	} else if info.Allocations != int64(allocations) {
+		return nil, fmt.Errorf("Wrong allocation count; expected: %d, got: %d", allocations, info.Allocations)
+	}
+
+	return info, nil
+}
+
+func TestArenaAllocFree(t *testing.T) {
+	makeArena(t, func(arena *Arena, other *Arena) error {
+		var handles []int64
+
+		for i := 0; i < 3; i++ {
+			handle, err := arena.Alloc(100)
+
+			if err != nil {
+				return err
+			} else if handle%ArenaAlignment != 0 {
+				return fmt.Errorf("Allocation %d is not aligned", handle)
+			}
+
+			data, err := arena.Bytes(handle)
+
+			if err != nil {
+				return err
+			} else if len(data) < 100 {
+				return fmt.Errorf("Allocation is too small: %d bytes", len(data))
+			}
+
+			copy(data, fmt.Sprintf("allocation %d", i))
+			handles = append(handles, handle)
+		}
+
+		// the same handle refers to the same memory through another attachment
+		if data, err := other.Bytes(handles[1]); err != nil {
+			return err
+		} else if !bytes.HasPrefix(data, []byte(`allocation 1`)) {
+			return fmt.Errorf("Wrong contents through the other attachment: %q", data[:12])
+		}
+
+		if err := other.Free(handles[1]); err != nil {
+			return err
+		}
+
+		if info, err := checkArena(arena, 2); err != nil {
+			return err
+		} else if info.FreeBlocks != 2 || info.Fragmentation == 0 {
+			return fmt.Errorf("Expected two free blocks and some fragmentation; got: %+v", info)
+		}
+
+		if err := arena.Free(handles[1]); err == nil {
+			return fmt.Errorf("Expected freeing an allocation twice to fail")
+		} else if err := arena.Free(handles[1] + 16); err == nil {
+			return fmt.Errorf("Expected freeing an invalid handle to fail")
+		}
+
+		if _, err := arena.Alloc(1024); err != ErrArenaFull {
+			return fmt.Errorf("Expected ErrArenaFull; got: %v", err)
+		} else if _, err := arena.Alloc(0); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize allocating nothing; got: %v", err)
+		}
+
+		for _, handle := range []int64{handles[0], handles[2]} {
+			if err := arena.Free(handle); err != nil {
+				return err
+			}
+		}
+
+		if info, err := checkArena(arena, 0); err != nil {
+			return err
+		} else if info.FreeBlocks != 1 || info.LargestFree != info.Capacity {
+			return fmt.Errorf("Expected the free blocks to be merged; got: %+v", info)
+		}
+
+		return nil
+	})
+}
+
+func TestArenaRealloc(t *testing.T) {
+	makeArena(t, func(arena *Arena, other *Arena) error {
+		first, err := arena.Alloc(32)
+
+		if err != nil {
+			return err
+		}
+
+		data, _ := arena.Bytes(first)
+		copy(data, `preserved`)
+
+		// the following space is free, so this grows in place
+		if grown, err := arena.Realloc(first, 200); err != nil {
+			return err
+		} else if grown != first {
+			return fmt.Errorf("Expected to grow in place; moved from %d to %d", first, grown)
+		}
+
+		blocker, err := arena.Alloc(16)
+
+		if err != nil {
+			return err
+		}
+
+		// shrinking in place frees the tail, which can't be merged with anything
+		if shrunk, err := arena.Realloc(first, 40); err != nil || shrunk != first {
+			return fmt.Errorf("Expected to shrink in place; got: %d, %v", shrunk, err)
+		} else if info, err := checkArena(arena, 2); err != nil {
+			return err
+		} else if info.FreeBlocks != 2 {
+			return fmt.Errorf("Expected two free blocks after shrinking; got: %d", info.FreeBlocks)
+		}
+
+		// there's no longer room to grow in place, so the allocation moves
+		moved, err := arena.Realloc(first, 300)
+
+		if err != nil {
+			return err
+		} else if moved == first {
+			return fmt.Errorf("Expected the allocation to move")
+		} else if data, err := other.Bytes(moved); err != nil {
+			return err
+		} else if !bytes.HasPrefix(data, []byte(`preserved`)) {
+			return fmt.Errorf("Contents were not preserved: %q", data[:9])
+		}
+
+		if _, err := arena.Realloc(moved, 10000); err != ErrArenaFull {
+			return fmt.Errorf("Expected ErrArenaFull; got: %v", err)
+		} else if _, err := arena.Bytes(moved); err != nil {
+			return fmt.Errorf("A failed resize should leave the allocation intact: %v", err)
+		}
+
+		if handle, err := arena.Realloc(moved, 0); err != nil || handle != 0 {
+			return fmt.Errorf("Expected resizing to zero to free; got: %d, %v", handle, err)
+		}
+
+		_, err = checkArena(arena, 1)
+		arena.Free(blocker)
+
+		return err
+	})
+}
+
+func TestArenaRandom(t *testing.T) {
+	makeArena(t, func(arena *Arena, other *Arena) error {
+		rng := rand.New(rand.NewSource(1))
+		live := make(map[int64]byte)
+
+		for i := 0; i < 2000; i++ {
+			var handle int64
+			var err error
+
+			switch op := rng.Intn(3); {
+			case op == 0 || len(live) == 0:
+				handle, err = arena.Alloc(1 + rng.Intn(100))
+			case op == 1:
+				for handle = range live {
+					break
+				}
+
+				delete(live, handle)
+				err = arena.Free(handle)
+				handle = 0
+			default:
+				for handle = range live {
+					break
+				}
+
+				old := handle
+				handle, err = other.Realloc(handle, 1+rng.Intn(150))
+
+				if err == nil {
+					delete(live, old)
+				}
+			}
+
+			if err == ErrArenaFull {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			if handle != 0 {
+				data, err := arena.Bytes(handle)
+
+				if err != nil {
+					return err
+				}
+
+				live[handle] = byte(i)
+
+				for j := range data {
+					data[j] = byte(i)
+				}
+			}
+
+			// every live allocation must still hold what was last written to it
+			for handle, value := range live {
+				data, err := other.Bytes(handle)
+
+				if err != nil {
+					return err
+				}
+
+				for j := range data {
+					if data[j] != value {
+						return fmt.Errorf("Allocation %d was overwritten after operation %d", handle, i)
+					}
+				}
+			}
+
+			if _, err := checkArena(arena, len(live)); err != nil {
+				return fmt.Errorf("After operation %d: %v", i, err)
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,187 @@
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+var (
+	// The segment (or semaphore set) does not exist, or has been destroyed.
+	ErrNotExist = errors.New(`does not exist`)
+
+	// The caller does not have permission to perform the operation.
+	ErrPermission = errors.New(`permission denied`)
+
+	// A segment (or semaphore set) already exists for the given key (when creating one exclusively).
+	ErrExists = errors.New(`already exists`)
+
+	// The operation would exceed a system-wide or per-process shared memory limit (e.g.: SHMMNI,
+	// SHMALL, or RLIMIT_MEMLOCK).
+	ErrLimitExceeded = errors.New(`shared memory limit exceeded`)
+
+	// The operation could not be completed without blocking, or did not complete before its timeout.
+	ErrWouldBlock = errors.New(`operation would block`)
+
+	// The requested size is outside of the range the system permits (SHMMIN to SHMMAX), is larger
+	// than an existing segment, or the requested range does not lie within the segment.
+	ErrInvalidSize = errors.New(`invalid size`)
+
+	// An argument was rejected by the system: for example, a misaligned attach address, a UID or GID
+	// that does not exist, or a semaphore number outside of the set.
+	ErrInvalidArgument = errors.New(`invalid argument`)
+)
+
+// An error returned by an operation on a SysV shared memory segment or semaphore set.  It records
+// which operation failed, the object it was performed on, and the underlying errno.  Use errors.Is to
+// test it against ErrNotExist, ErrPermission, ErrExists, ErrLimitExceeded, ErrWouldBlock,
+// ErrInvalidSize and ErrInvalidArgument (or against the equivalent os package errors, or the errno
+// itself) rather than inspecting the errno directly, since the kernel reports the same condition with
+// different errnos depending on the operation.
+type Error struct {
+	// The operation that failed (e.g.: open, stat, attach).
+	Op string
+
+	// The ID of the segment or semaphore set, or -1 if it was not known (e.g.: when opening by key).
+	Id int
+
+	// The key of the segment or semaphore set, if known.
+	Key Key
+
+	// The underlying error number.
+	Errno syscall.Errno
+
+	object string
+	kinds  []error
+}
+
+// wraps an error returned by one of the low-level segment operations in an *Error; errors that
+// did not come from a system call are returned unchanged
+func newError(op string, id int, key Key, err error) error {
+	return newObjectError(`segment`, op, id, key, err)
+}
+
+func newObjectError(object string, op string, id int, key Key, err error) error {
+	errno, ok := err.(syscall.Errno)
+
+	if !ok {
+		return err
+	}
+
+	return &Error{
+		Op:     op,
+		Id:     id,
+		Key:    key,
+		Errno:  errno,
+		object: object,
+		kinds:  classifyErrno(op, errno),
+	}
+}
+
+// the sentinel errors an errno corresponds to for the given operation, most specific first
+func classifyErrno(op string, errno syscall.Errno) []error {
+	switch errno {
+	case syscall.ENOENT, syscall.EIDRM:
+		return []error{ErrNotExist}
+	case syscall.EACCES:
+		return []error{ErrPermission}
+	case syscall.EPERM:
+		if op == `lock` || op == `unlock` {
+			return []error{ErrLockPermission, ErrPermission}
+		}
+
+		return []error{ErrPermission}
+	case syscall.EEXIST:
+		return []error{ErrExists}
+	case syscall.ENOSPC, syscall.EMFILE:
+		return []error{ErrLimitExceeded}
+	case syscall.ENOMEM:
+		if op == `lock` {
+			return []error{ErrLockLimit, ErrLimitExceeded}
+		}
+
+		return []error{ErrLimitExceeded}
+	case syscall.E2BIG:
+		return []error{ErrLimitExceeded}
+	case syscall.EAGAIN:
+		return []error{ErrWouldBlock}
+	case syscall.ERANGE, syscall.EFBIG:
+		return []error{ErrInvalidSize}
+	case syscall.EINVAL:
+		switch op {
+		case `open`, `create`:
+			// shmget and semget report sizes they won't accept this way
+			return []error{ErrInvalidSize}
+		case `stat`, `destroy`, `read`, `write`, `lock`, `unlock`, `getall`, `setall`, `semop`:
+			// for these, the only argument the kernel can reject is the ID, which means it doesn't
+			// exist (or was destroyed); read and write attach at an address of the system's choosing
+			return []error{ErrNotExist}
+		default:
+			// everything else (e.g.: attach, chmod, chown, getval, setval) takes other arguments that
+			// are reported the same way, so a missing ID can't be told apart from a bad argument
+			return []error{ErrInvalidArgument}
+		}
+	default:
+		return nil
+	}
+}
+
+func (self *Error) Error() string {
+	var target string
+
+	object := self.object
+
+	if object == `` {
+		object = `segment`
+	}
+
+	if self.Id >= 0 {
+		target = fmt.Sprintf("%s %d", object, self.Id)
+	} else {
+		target = fmt.Sprintf("%s with key %v", object, self.Key)
+	}
+
+	if len(self.kinds) > 0 {
+		return fmt.Sprintf("%s %s: %v (%v)", self.Op, target, self.kinds[0], self.Errno)
+	}
+
+	return fmt.Sprintf("%s %s: %v", self.Op, target, self.Errno)
+}
+
+// Returns the underlying errno.
+func (self *Error) Unwrap() error {
+	return self.Errno
+}
+
+// Reports whether this error corresponds to the given sentinel error.  In addition to this package's
+// errors, the equivalent os.ErrNotExist, os.ErrPermission, os.ErrExist and os.ErrInvalid are
+// recognized.
+func (self *Error) Is(target error) bool {
+	for _, kind := range self.kinds {
+		if target == kind {
+			return true
+		}
+
+		switch kind {
+		case ErrNotExist:
+			if target == os.ErrNotExist {
+				return true
+			}
+		case ErrPermission:
+			if target == os.ErrPermission {
+				return true
+			}
+		case ErrExists:
+			if target == os.ErrExist {
+				return true
+			}
+		case ErrInvalidArgument:
+			if target == os.ErrInvalid {
+				return true
+			}
+		}
+	}
+
+	return false
+}
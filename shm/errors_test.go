@@ -0,0 +1,152 @@
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestErrorNotExist(t *testing.T) {
+	segment, err := Create(1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := segment.Id
+
+	if err := segment.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open(id)
+
+	if !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist opening a destroyed segment; got: %v", err)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected ErrNotExist to also match os.ErrNotExist")
+	}
+
+	var serr *Error
+
+	if !errors.As(err, &serr) {
+		t.Fatalf("Expected a *shm.Error; got: %T", err)
+	} else if serr.Op != `stat` || serr.Id != id || serr.Errno != syscall.EINVAL {
+		t.Errorf("Wrong error details: op=%q id=%d errno=%v", serr.Op, serr.Id, serr.Errno)
+	}
+
+	if _, err := segment.ReadChunk(16, 0); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist reading a destroyed segment; got: %v", err)
+	}
+
+	if _, err := OpenByKey(Key(0x7ffffffe)); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist opening an unused key; got: %v", err)
+	} else if !errors.As(err, &serr) || serr.Id != -1 || serr.Key != Key(0x7ffffffe) {
+		t.Errorf("Wrong error details: %v", err)
+	}
+}
+
+func TestErrorExists(t *testing.T) {
+	key, err := KeyFromPath(`errors_test.go`, DefaultProjectId)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segment, err := CreateWithKey(key, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer segment.Destroy()
+
+	if _, err := CreateWithKey(key, 1024); !errors.Is(err, ErrExists) {
+		t.Errorf("Expected ErrExists creating an existing key; got: %v", err)
+	} else if !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("Expected the errno to be unwrapped; got: %v", err)
+	}
+
+	// asking for more than the existing segment holds is reported as an invalid size
+	if _, err := OpenSegmentWithKey(key, 1<<20, IpcCreate, 0600); !errors.Is(err, ErrInvalidSize) {
+		t.Errorf("Expected ErrInvalidSize opening an existing key with a larger size; got: %v", err)
+	}
+}
+
+func TestErrorInvalidSize(t *testing.T) {
+	if _, err := Create(-1); !errors.Is(err, ErrInvalidSize) {
+		t.Errorf("Expected ErrInvalidSize creating a negative size segment; got: %v", err)
+	}
+
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if _, err := segment.ReadChunk(16, 1020); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize reading past the end; got: %v", err)
+		} else if errors.Is(err, ErrNotExist) {
+			return fmt.Errorf("Range errors should not match ErrNotExist")
+		}
+
+		return nil
+	})
+}
+
+func TestErrorPermission(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("Permission checks do not apply to root")
+	}
+
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if err := segment.Chmod(0); err != nil {
+			return err
+		}
+
+		if _, err := segment.ReadChunk(16, 0); !errors.Is(err, ErrPermission) {
+			return fmt.Errorf("Expected ErrPermission reading a mode 0000 segment; got: %v", err)
+		} else if !errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("Expected ErrPermission to also match os.ErrPermission")
+		}
+
+		return nil
+	})
+}
+
+func TestErrorInvalidArgument(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		// a fixed attach address must be page-aligned
+		if _, err := segment.AttachWith(AttachOptions{Address: 0x7f000001}); !errors.Is(err, ErrInvalidArgument) {
+			return fmt.Errorf("Expected ErrInvalidArgument attaching at a misaligned address; got: %v", err)
+		} else if errors.Is(err, ErrNotExist) {
+			return fmt.Errorf("A bad attach address should not match ErrNotExist")
+		} else if !errors.Is(err, os.ErrInvalid) {
+			return fmt.Errorf("Expected ErrInvalidArgument to also match os.ErrInvalid")
+		}
+
+		// arguments checked before reaching the kernel are reported the same way
+		if _, err := segment.ReadAt(make([]byte, 4), -1); !errors.Is(err, ErrInvalidArgument) {
+			return fmt.Errorf("Expected ErrInvalidArgument reading from a negative offset; got: %v", err)
+		} else if _, err := segment.WriteAt(make([]byte, 4), -1); !errors.Is(err, ErrInvalidArgument) {
+			return fmt.Errorf("Expected ErrInvalidArgument writing to a negative offset; got: %v", err)
+		} else if _, err := segment.Seek(-1, 0); !errors.Is(err, ErrInvalidArgument) {
+			return fmt.Errorf("Expected ErrInvalidArgument seeking before the start; got: %v", err)
+		}
+
+		return nil
+	})
+
+	// IPC_SET only rejects a UID or GID that has no mapping in the caller's user namespace, which
+	// can't be arranged portably, so check how the errno is classified instead
+	for _, op := range []string{`attach`, `chmod`, `chown`, `setval`} {
+		err := newError(op, 1, IpcPrivate, syscall.EINVAL)
+
+		if !errors.Is(err, ErrInvalidArgument) || errors.Is(err, ErrNotExist) {
+			t.Errorf("Expected EINVAL from %s to be ErrInvalidArgument; got: %v", op, err)
+		}
+	}
+
+	for _, op := range []string{`stat`, `destroy`} {
+		if err := newError(op, 1, IpcPrivate, syscall.EINVAL); !errors.Is(err, ErrNotExist) {
+			t.Errorf("Expected EINVAL from %s to be ErrNotExist; got: %v", op, err)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package shm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Memory mapped by fileMemory.AttachWith().
+type fileMapping struct {
+	data []byte
+
+	// mapped at a fixed address by mmapAt() rather than by unix.Mmap()
+	fixed bool
+}
+
+// The implementation shared by the kinds of shared memory that are accessed through a file
+// descriptor (POSIX shared memory objects and memfds), where attaching is done with mmap(2).
+type fileMemory struct {
+	Size     int64
+	name     string
+	file     *os.File
+	readOnly bool
+	offset   int64
+	attached map[uintptr]fileMapping
+	attachMu sync.Mutex
+}
+
+func (self *fileMemory) init(name string, file *os.File, readOnly bool, size int64) error {
+	self.name = name
+	self.file = file
+	self.readOnly = readOnly
+	self.attached = make(map[uintptr]fileMapping)
+
+	if size > 0 {
+		return self.Resize(size)
+	} else if stat, err := file.Stat(); err == nil {
+		self.Size = stat.Size()
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Returns the size of the shared memory (in bytes), which is the same as its Size field.
+func (self *fileMemory) Len() int64 {
+	return self.Size
+}
+
+// Returns the file descriptor backing this shared memory.
+func (self *fileMemory) Fd() uintptr {
+	return self.file.Fd()
+}
+
+// Changes the size of the object, as with ftruncate(2).  Growing the object fills the new space with
+// zeros.  Existing attachments are not resized; to access the new size, attach the object again.
+// Objects that were opened read-only cannot be resized.
+//
+func (self *fileMemory) Resize(size int64) error {
+	if self.readOnly {
+		return fmt.Errorf("Cannot resize %s: object was opened read-only: %w", self.name, ErrPermission)
+	}
+
+	if err := self.file.Truncate(size); err == nil {
+		self.Size = size
+
+		if self.offset > size {
+			self.offset = size
+		}
+
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Implements the io.ReaderAt interface.  Reads do not use or modify the current position.
+//
+func (self *fileMemory) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Cannot read from negative offset %d: %w", off, ErrInvalidArgument)
+	} else if off >= self.Size {
+		return 0, io.EOF
+	}
+
+	if remaining := self.Size - off; int64(len(p)) > remaining {
+		n, err := self.file.ReadAt(p[:remaining], off)
+
+		if err == nil {
+			err = io.EOF
+		}
+
+		return n, err
+	}
+
+	return self.file.ReadAt(p, off)
+}
+
+// Implements the io.WriterAt interface.  Writes do not use or modify the current position, and do
+// not grow the object; writes that would extend beyond its end are truncated and return io.EOF.
+//
+func (self *fileMemory) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Cannot write to negative offset %d: %w", off, ErrInvalidArgument)
+	} else if off >= self.Size {
+		return 0, io.EOF
+	}
+
+	if remaining := self.Size - off; int64(len(p)) > remaining {
+		n, err := self.file.WriteAt(p[:remaining], off)
+
+		if err == nil {
+			err = io.EOF
+		}
+
+		return n, err
+	}
+
+	return self.file.WriteAt(p, off)
+}
+
+// Implements the io.WriterTo interface, writing everything from the current position to the end
+// directly from the attached memory and advancing the position accordingly.
+//
+func (self *fileMemory) WriteTo(w io.Writer) (int64, error) {
+	n, err := writeToAttached(self, self.offset, self.Size, w)
+	self.offset += n
+	return n, err
+}
+
+// Implements the io.ReaderFrom interface, reading from r directly into the attached memory starting
+// at the current position, and advancing the position accordingly.  As with Segment.ReadFrom(), once
+// the end is reached a single byte is read from r, and io.ErrShortWrite is returned if r had more input.
+//
+func (self *fileMemory) ReadFrom(r io.Reader) (int64, error) {
+	if self.readOnly {
+		return 0, fmt.Errorf("Cannot write to %s: object was opened read-only: %w", self.name, ErrPermission)
+	}
+
+	n, err := readFromAttached(self, self.offset, self.Size, r)
+	self.offset += n
+	return n, err
+}
+
+// Returns a Section that reads n bytes starting at offset off, independently of the current
+// position and of any other Section.  If n is negative or extends beyond the end, the Section ends
+// at the end.
+//
+func (self *fileMemory) Section(off int64, n int64) *Section {
+	return newSection(self, self.Size, self, off, n)
+}
+
+// Read some or all of the object and return a byte slice.  As with Segment.ReadChunk(), a negative
+// length reads everything from start to the end, and ranges that do not lie within the object fail
+// with ErrInvalidSize.
+//
+func (self *fileMemory) ReadChunk(length int64, start int64) ([]byte, error) {
+	if start < 0 || start > self.Size {
+		return nil, fmt.Errorf("Cannot read %s from offset %d: %w", self.name, start, ErrInvalidSize)
+	}
+
+	if length < 0 {
+		length = self.Size - start
+	} else if length > self.Size-start {
+		return nil, fmt.Errorf("Cannot read %d bytes from %s at offset %d: %w", length, self.name, start, ErrInvalidSize)
+	}
+
+	buffer := make([]byte, length)
+
+	if _, err := self.file.ReadAt(buffer, start); err != nil {
+		return nil, err
+	}
+
+	return buffer, nil
+}
+
+// Implements the io.Reader interface for file-backed shared memory
+//
+func (self *fileMemory) Read(p []byte) (int, error) {
+	// if the offset runs past the object size, we've reached the end
+	if self.offset >= self.Size {
+		return 0, io.EOF
+	}
+
+	length := int64(len(p))
+
+	// if length+offset would overrun, make length equal (size - offset), which is what remains
+	if (length + self.offset) > self.Size {
+		length = self.Size - self.offset
+	}
+
+	if length <= 0 {
+		return 0, io.EOF
+	}
+
+	n, err := self.file.ReadAt(p[:length], self.offset)
+	self.offset += int64(n)
+
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+
+	return n, err
+}
+
+// Implements the io.Writer interface for file-backed shared memory.  Writes do not grow the
+// object; use Resize() to make room for more data.
+//
+func (self *fileMemory) Write(p []byte) (int, error) {
+	// if the offset runs past the object size, we've reached the end
+	if self.offset >= self.Size {
+		return 0, io.EOF
+	}
+
+	length := int64(len(p))
+
+	// if length+offset would overrun, make length equal (size - offset), which is what remains
+	if (length + self.offset) > self.Size {
+		length = self.Size - self.offset
+	}
+
+	n, err := self.file.WriteAt(p[:length], self.offset)
+	self.offset += int64(n)
+
+	return n, err
+}
+
+// Resets the internal offset counter for this object, allowing subsequent calls
+// to Read() or Write() to start from the beginning.
+//
+func (self *fileMemory) Reset() {
+	self.offset = 0
+}
+
+// Implements the io.Seeker interface for file-backed shared memory, with the same semantics as
+// Segment.Seek().
+//
+func (self *fileMemory) Seek(offset int64, whence int) (int64, error) {
+	var computedOffset int64
+
+	switch whence {
+	case 1:
+		computedOffset = self.offset + offset
+	case 2:
+		computedOffset = self.Size - offset
+	default:
+		computedOffset = offset
+	}
+
+	if computedOffset < 0 {
+		return 0, fmt.Errorf("Cannot seek to position before start of object: %w", ErrInvalidArgument)
+	}
+
+	self.offset = computedOffset
+	return self.offset, nil
+}
+
+// Returns the current position of the Read/Write pointer.
+//
+func (self *fileMemory) Position() int64 {
+	return self.offset
+}
+
+// Maps the object into the current process's memory, returning its address.
+//
+func (self *fileMemory) Attach() (unsafe.Pointer, error) {
+	return self.AttachWith(AttachOptions{})
+}
+
+// Maps the object into the current process's memory using the given options.  The options have the
+// same meaning as they do for Segment.AttachWith(), implemented in terms of mmap(2).
+//
+func (self *fileMemory) AttachWith(opts AttachOptions) (unsafe.Pointer, error) {
+	prot := syscall.PROT_READ
+	flags := syscall.MAP_SHARED
+	addr := opts.Address
+
+	if self.Size == 0 {
+		return nil, fmt.Errorf("Cannot attach an empty object: %w", ErrInvalidSize)
+	} else if opts.Remap && addr == 0 {
+		return nil, fmt.Errorf("Cannot remap an object without specifying an address: %w", ErrInvalidArgument)
+	}
+
+	if !opts.ReadOnly {
+		if self.readOnly {
+			return nil, fmt.Errorf("Cannot attach %s read-write: object was opened read-only: %w", self.name, ErrPermission)
+		}
+
+		prot |= syscall.PROT_WRITE
+	}
+
+	if opts.Exec {
+		prot |= syscall.PROT_EXEC
+	}
+
+	var data []byte
+	var err error
+
+	if addr == 0 {
+		data, err = unix.Mmap(int(self.file.Fd()), 0, int(self.Size), prot, flags)
+	} else {
+		if opts.Round {
+			addr &^= uintptr(os.Getpagesize() - 1)
+		}
+
+		data, err = self.mmapAt(addr, prot, flags, opts.Remap)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ptr := unsafe.Pointer(&data[0])
+
+	self.attachMu.Lock()
+	self.attached[uintptr(ptr)] = fileMapping{data: data, fixed: addr != 0}
+	self.attachMu.Unlock()
+
+	return ptr, nil
+}
+
+// Unmaps memory previously attached with Attach() or AttachWith().
+//
+func (self *fileMemory) Detach(addr unsafe.Pointer) error {
+	self.attachMu.Lock()
+	defer self.attachMu.Unlock()
+
+	mapping, ok := self.attached[uintptr(addr)]
+
+	if !ok {
+		return fmt.Errorf("Address %p is not attached to %s: %w", addr, self.name, ErrInvalidArgument)
+	}
+
+	delete(self.attached, uintptr(addr))
+
+	if mapping.fixed {
+		return munmapAt(mapping.data)
+	}
+
+	return unix.Munmap(mapping.data)
+}
+
+func (self *fileMemory) info(uri string) (*SegmentInfo, error) {
+	stat, err := self.file.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := &SegmentInfo{
+		URI:         uri,
+		Id:          -1,
+		Size:        stat.Size(),
+		LastChanged: unixTime(stat.ModTime().Unix()),
+	}
+
+	// the layout of Stat_t differs between systems, but every Unix has these
+	if st, ok := stat.Sys().(*syscall.Stat_t); ok {
+		info.OwnerUID = int(st.Uid)
+		info.OwnerGID = int(st.Gid)
+	}
+
+	info.setMode(uint32(stat.Mode().Perm()))
+
+	return info, nil
+}
+
+// Closes the file descriptor for this object.  Existing attachments remain valid.
+//
+func (self *fileMemory) Close() error {
+	return self.file.Close()
+}
@@ -0,0 +1,49 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// prevents mmap(2) from replacing an existing mapping at a fixed address (Linux 4.17+)
+const mapFixedNoReplace = 0x100000
+
+// maps the object at a fixed address, which unix.Mmap has no way of requesting
+func (self *fileMemory) mmapAt(addr uintptr, prot int, flags int, remap bool) ([]byte, error) {
+	if remap {
+		flags |= syscall.MAP_FIXED
+	} else {
+		flags |= mapFixedNoReplace
+	}
+
+	ptr, _, errno := unix.Syscall6(sysMmap, addr, uintptr(self.Size), uintptr(prot), uintptr(flags), self.file.Fd(), 0)
+
+	if errno != 0 {
+		return nil, errno
+	}
+
+	// the mapping lies outside of the Go heap, at an address chosen by the caller
+	data := unsafe.Slice((*byte)(unsafe.Add(nil, ptr)), self.Size)
+
+	// kernels that predate MAP_FIXED_NOREPLACE treat the address as a hint
+	if ptr != addr {
+		munmapAt(data)
+		return nil, syscall.EEXIST
+	}
+
+	return data, nil
+}
+
+// unmaps memory mapped by mmapAt (unix.Munmap only accepts memory that unix.Mmap mapped)
+func munmapAt(data []byte) error {
+	if _, _, errno := unix.Syscall(unix.SYS_MUNMAP, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
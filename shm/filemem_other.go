@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package shm
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// Attaching at a fixed address relies on MAP_FIXED_NOREPLACE to avoid silently replacing whatever is
+// already mapped there, and only Linux provides it.
+func (self *fileMemory) mmapAt(addr uintptr, prot int, flags int, remap bool) ([]byte, error) {
+	return nil, fmt.Errorf("Cannot attach %s at a fixed address on this system: %w", self.name, ErrInvalidArgument)
+}
+
+// mmapAt never maps anything on these systems, so there is nothing for this to unmap
+func munmapAt(data []byte) error {
+	return syscall.EINVAL
+}
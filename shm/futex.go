@@ -0,0 +1,78 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Futex operations from <linux/futex.h>.  The private variants are deliberately not used, since the
+// whole point is to wait on words that other processes share.
+const (
+	futexWaitOp = 0
+	futexWakeOp = 1
+)
+
+// Blocks until the word at addr is woken by futexWake, the timeout elapses, or a signal arrives, but
+// only if it still holds val.  A timeout of zero or less waits indefinitely.  Returns EAGAIN if the
+// word did not hold val, and ETIMEDOUT if the timeout elapsed; callers should re-check the word in
+// every case, since wakeups can be spurious.
+func futexWait(addr *uint32, val uint32, timeout time.Duration) error {
+	var ts *syscall.Timespec
+
+	if timeout > 0 {
+		t := syscall.NsecToTimespec(int64(timeout))
+		ts = &t
+	}
+
+	if _, _, errno := syscall.Syscall6(
+		syscall.SYS_FUTEX,
+		uintptr(unsafe.Pointer(addr)),
+		futexWaitOp,
+		uintptr(val),
+		uintptr(unsafe.Pointer(ts)),
+		0,
+		0,
+	); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// Wakes up to n processes waiting on the word at addr, returning the number that were woken.
+func futexWake(addr *uint32, n int) (int, error) {
+	if woken, _, errno := syscall.Syscall6(
+		syscall.SYS_FUTEX,
+		uintptr(unsafe.Pointer(addr)),
+		futexWakeOp,
+		uintptr(n),
+		0,
+		0,
+		0,
+	); errno == 0 {
+		return int(woken), nil
+	} else {
+		return 0, errno
+	}
+}
+
+// returns a pointer to the 32-bit word at the given offset of the mapping, which must be 4-byte
+// aligned and lie within it
+func wordAt(mapping *Mapping, offset int64) (*uint32, error) {
+	data := mapping.Bytes()
+
+	if data == nil {
+		return nil, fmt.Errorf("Cannot use a closed mapping")
+	} else if offset < 0 || offset+4 > int64(len(data)) {
+		return nil, fmt.Errorf("Offset %d is outside of the %d byte mapping", offset, len(data))
+	} else if offset%4 != 0 {
+		return nil, fmt.Errorf("Offset %d is not aligned to a 4 byte boundary", offset)
+	}
+
+	return (*uint32)(unsafe.Pointer(&data[offset])), nil
+}
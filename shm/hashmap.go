@@ -0,0 +1,416 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// Identifies shared memory that has been initialized as a Map ("SHMM").
+	MapMagic = 0x4d4d4853
+
+	// The version of the Map layout implemented by this package.
+	MapVersion = 1
+
+	// The number of bytes at the start of a Map occupied by its header; the slots follow it.
+	MapHeaderSize = 64
+)
+
+// slot states, and the size of the fixed part of each slot (the key and value follow it)
+const (
+	mapSlotEmpty   = 0
+	mapSlotUsed    = 1
+	mapSlotDeleted = 2
+	mapSlotHeader  = 16
+)
+
+var (
+	// Returned by OpenMap when the memory does not contain a Map.
+	ErrNotMap = errors.New(`shared memory does not contain a hash map`)
+
+	// Returned by Put when the Map already holds as many entries as its capacity allows.
+	ErrMapFull = errors.New(`hash map is full`)
+)
+
+// The header at the start of a Map.  Count and Deleted are protected by the mutex in Lock; the rest
+// is fixed when the Map is initialized.
+type mapHeader struct {
+	Magic        uint32
+	Version      uint32
+	Slots        uint32
+	Capacity     uint32
+	MaxKeySize   uint32
+	MaxValueSize uint32
+	SlotSize     uint32
+	Lock         uint32
+	Count        uint32
+	Deleted      uint32
+	_            [24]byte
+}
+
+// The fixed part of each slot.
+type mapSlot struct {
+	State     uint32
+	KeySize   uint32
+	ValueSize uint32
+	_         uint32
+}
+
+// A hash table mapping byte string keys to byte string values, stored in shared memory so that any
+// number of processes can share a lookup table.  The number of entries and the maximum sizes of keys
+// and values are fixed when the Map is initialized, and each entry occupies a fixed-size slot.
+// Collisions are resolved by open addressing (linear probing), using a hash function that gives the
+// same result in every process.  Every operation holds a Mutex stored in the Map's header, so the Map
+// can be used from any number of processes at once.
+type Map struct {
+	mapping *Mapping
+	header  *mapHeader
+	mutex   *Mutex
+	slots   []byte
+}
+
+// Returns the number of bytes a Map with the given capacity and maximum key and value sizes occupies.
+//
+func MapSize(capacity int, maxKeySize int, maxValueSize int) int64 {
+	return MapHeaderSize + int64(mapSlotCount(capacity))*int64(mapSlotSize(maxKeySize, maxValueSize))
+}
+
+// enough slots that the table is at most 3/4 full, rounded up to a power of two
+func mapSlotCount(capacity int) uint32 {
+	slots := uint32(1)
+
+	for slots < uint32(capacity)+uint32(capacity)/3+1 {
+		slots <<= 1
+	}
+
+	return slots
+}
+
+func mapSlotSize(maxKeySize int, maxValueSize int) uint32 {
+	return (mapSlotHeader + uint32(maxKeySize) + uint32(maxValueSize) + 7) &^ 7
+}
+
+// Initializes a new, empty Map at the given offset of the mapping, discarding whatever was previously
+// stored there.  The Map holds up to capacity entries, whose keys and values may be up to the given
+// sizes; see MapSize for how much space it needs.  The offset must be aligned to an 8 byte boundary.
+// If the mapping begins with a segment header whose payload starts at the offset, the header's
+// layout is set to LayoutMap.
+//
+func InitMap(mapping *Mapping, offset int64, capacity int, maxKeySize int, maxValueSize int) (*Map, error) {
+	if capacity <= 0 || capacity > 1<<30 || maxKeySize <= 0 || maxKeySize > 1<<30 || maxValueSize < 0 || maxValueSize > 1<<30 {
+		return nil, fmt.Errorf("Invalid hash map dimensions: %w", ErrInvalidSize)
+	}
+
+	size := MapSize(capacity, maxKeySize, maxValueSize)
+	header, err := mapAt(mapping, offset, size)
+
+	if err != nil {
+		return nil, err
+	}
+
+	slots := mapping.Bytes()[offset+MapHeaderSize : offset+size]
+
+	for i := range slots {
+		slots[i] = 0
+	}
+
+	*header = mapHeader{
+		Version:      MapVersion,
+		Slots:        mapSlotCount(capacity),
+		Capacity:     uint32(capacity),
+		MaxKeySize:   uint32(maxKeySize),
+		MaxValueSize: uint32(maxValueSize),
+		SlotSize:     mapSlotSize(maxKeySize, maxValueSize),
+	}
+
+	atomic.StoreUint32(&header.Magic, MapMagic)
+
+	if m, err := newMap(mapping, offset, header, slots); err == nil {
+		setHeaderLayout(mapping, offset, LayoutMap)
+		return m, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Opens the Map stored in the mapping at the given offset, which must have been initialized with
+// InitMap.  Returns ErrNotMap if no Map is found there.
+//
+func OpenMap(mapping *Mapping, offset int64) (*Map, error) {
+	header, err := mapAt(mapping, offset, MapHeaderSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if atomic.LoadUint32(&header.Magic) != MapMagic {
+		return nil, ErrNotMap
+	} else if header.Version != MapVersion {
+		return nil, fmt.Errorf("Unsupported hash map layout version %d", header.Version)
+	}
+
+	size := MapSize(int(header.Capacity), int(header.MaxKeySize), int(header.MaxValueSize))
+
+	if header.Slots != mapSlotCount(int(header.Capacity)) || header.SlotSize != mapSlotSize(int(header.MaxKeySize), int(header.MaxValueSize)) {
+		return nil, fmt.Errorf("Hash map header is inconsistent")
+	} else if size > int64(mapping.Len())-offset {
+		return nil, fmt.Errorf("Hash map of %d bytes exceeds the space available in the mapping", size)
+	}
+
+	return newMap(mapping, offset, header, mapping.Bytes()[offset+MapHeaderSize:offset+size])
+}
+
+func mapAt(mapping *Mapping, offset int64, size int64) (*mapHeader, error) {
+	data := mapping.Bytes()
+
+	if data == nil {
+		return nil, fmt.Errorf("Cannot use a closed mapping")
+	} else if mapping.ReadOnly() {
+		return nil, fmt.Errorf("Cannot use a hash map in a read-only mapping")
+	} else if offset < 0 || offset%8 != 0 {
+		return nil, fmt.Errorf("Offset %d is not aligned to an 8 byte boundary", offset)
+	} else if int64(len(data))-offset < size {
+		return nil, fmt.Errorf("Not enough room for a %d byte hash map at offset %d of a %d byte mapping: %w", size, offset, len(data), ErrInvalidSize)
+	}
+
+	return (*mapHeader)(unsafe.Pointer(&data[offset])), nil
+}
+
+func newMap(mapping *Mapping, offset int64, header *mapHeader, slots []byte) (*Map, error) {
+	mutex, err := NewMutex(mapping, offset+int64(unsafe.Offsetof(header.Lock)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Map{
+		mapping: mapping,
+		header:  header,
+		mutex:   mutex,
+		slots:   slots,
+	}, nil
+}
+
+// Returns the maximum number of entries the Map can hold.
+func (self *Map) Cap() int {
+	return int(self.header.Capacity)
+}
+
+// Returns the number of entries in the Map.
+func (self *Map) Len() int {
+	return int(atomic.LoadUint32(&self.header.Count))
+}
+
+// Returns the maximum size of a key.
+func (self *Map) MaxKeySize() int {
+	return int(self.header.MaxKeySize)
+}
+
+// Returns the maximum size of a value.
+func (self *Map) MaxValueSize() int {
+	return int(self.header.MaxValueSize)
+}
+
+// Returns a copy of the value stored for the given key, and whether the key was found.
+//
+func (self *Map) Get(key []byte) ([]byte, bool, error) {
+	if err := self.lock(); err != nil {
+		return nil, false, err
+	}
+
+	defer self.mutex.Unlock()
+
+	if i, found := self.find(key); found {
+		slot, _, value := self.slot(i)
+		return append([]byte{}, value[:slot.ValueSize]...), true, nil
+	}
+
+	return nil, false, nil
+}
+
+// Stores a value for the given key, replacing any existing value.  Returns ErrMapFull if the key is
+// new and the Map is already at capacity.
+//
+func (self *Map) Put(key []byte, value []byte) error {
+	if len(key) == 0 || len(key) > self.MaxKeySize() {
+		return fmt.Errorf("Key of %d bytes must be between 1 and %d bytes: %w", len(key), self.MaxKeySize(), ErrInvalidSize)
+	} else if len(value) > self.MaxValueSize() {
+		return fmt.Errorf("Value of %d bytes exceeds the maximum of %d: %w", len(value), self.MaxValueSize(), ErrInvalidSize)
+	}
+
+	if err := self.lock(); err != nil {
+		return err
+	}
+
+	defer self.mutex.Unlock()
+
+	i, found := self.find(key)
+
+	if !found {
+		if self.header.Count >= self.header.Capacity {
+			return ErrMapFull
+		}
+
+		// too few empty slots left makes probing slow (and eventually endless), so clear out the
+		// deleted ones first
+		if slot, _, _ := self.slot(i); slot.State == mapSlotEmpty && self.header.Count+self.header.Deleted >= self.header.Slots-self.header.Slots/8 {
+			self.rebuild()
+			i, _ = self.find(key)
+		}
+
+		if slot, _, _ := self.slot(i); slot.State == mapSlotDeleted {
+			self.header.Deleted--
+		}
+
+		atomic.AddUint32(&self.header.Count, 1)
+	}
+
+	slot, k, v := self.slot(i)
+
+	copy(k, key)
+	copy(v, value)
+	slot.KeySize = uint32(len(key))
+	slot.ValueSize = uint32(len(value))
+	slot.State = mapSlotUsed
+
+	return nil
+}
+
+// Removes the given key from the Map, returning whether it was present.
+//
+func (self *Map) Delete(key []byte) (bool, error) {
+	if err := self.lock(); err != nil {
+		return false, err
+	}
+
+	defer self.mutex.Unlock()
+
+	if i, found := self.find(key); found {
+		slot, _, _ := self.slot(i)
+		slot.State = mapSlotDeleted
+		self.header.Deleted++
+		atomic.AddUint32(&self.header.Count, ^uint32(0))
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// Calls fn with each key and value in the Map, in no particular order, until it returns false.  The
+// entries are copied before fn is first called, so fn sees the Map as it was when Range was called
+// and may itself modify the Map.
+//
+func (self *Map) Range(fn func(key []byte, value []byte) bool) error {
+	var keys, values [][]byte
+
+	if err := self.lock(); err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < self.header.Slots; i++ {
+		if slot, key, value := self.slot(i); slot.State == mapSlotUsed {
+			keys = append(keys, append([]byte{}, key[:slot.KeySize]...))
+			values = append(values, append([]byte{}, value[:slot.ValueSize]...))
+		}
+	}
+
+	self.mutex.Unlock()
+
+	for i := range keys {
+		if !fn(keys[i], values[i]) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// a process that died while holding the lock can at worst have left one slot partially written
+func (self *Map) lock() error {
+	if err := self.mutex.LockContext(context.Background()); err != nil && err != ErrOwnerDead {
+		return err
+	}
+
+	return nil
+}
+
+// returns the slot header, and the key and value areas, of the ith slot
+func (self *Map) slot(i uint32) (*mapSlot, []byte, []byte) {
+	start := uint64(i) * uint64(self.header.SlotSize)
+	key := start + mapSlotHeader
+	value := key + uint64(self.header.MaxKeySize)
+
+	return (*mapSlot)(unsafe.Pointer(&self.slots[start])),
+		self.slots[key:value:value],
+		self.slots[value : value+uint64(self.header.MaxValueSize)]
+}
+
+// returns the index of the slot holding key and true, or the index of the slot key should be
+// inserted into and false
+func (self *Map) find(key []byte) (uint32, bool) {
+	h := fnv.New64a()
+	h.Write(key)
+
+	mask := self.header.Slots - 1
+	i := uint32(h.Sum64()) & mask
+	insert := int64(-1)
+
+	for n := uint32(0); n < self.header.Slots; n, i = n+1, (i+1)&mask {
+		slot, k, _ := self.slot(i)
+
+		switch slot.State {
+		case mapSlotEmpty:
+			if insert < 0 {
+				insert = int64(i)
+			}
+
+			return uint32(insert), false
+		case mapSlotDeleted:
+			if insert < 0 {
+				insert = int64(i)
+			}
+		default:
+			if int(slot.KeySize) == len(key) && bytes.Equal(k[:slot.KeySize], key) {
+				return i, true
+			}
+		}
+	}
+
+	return uint32(insert), false
+}
+
+// reinserts every entry into an otherwise empty table, discarding deleted slots
+func (self *Map) rebuild() {
+	entries := make([]byte, 0, int(self.header.Count)*int(self.header.SlotSize))
+
+	for i := uint32(0); i < self.header.Slots; i++ {
+		start := int(i) * int(self.header.SlotSize)
+
+		if slot, _, _ := self.slot(i); slot.State == mapSlotUsed {
+			entries = append(entries, self.slots[start:start+int(self.header.SlotSize)]...)
+		}
+	}
+
+	for i := range self.slots {
+		self.slots[i] = 0
+	}
+
+	self.header.Deleted = 0
+
+	for start := 0; start < len(entries); start += int(self.header.SlotSize) {
+		entry := entries[start : start+int(self.header.SlotSize)]
+		size := (*mapSlot)(unsafe.Pointer(&entry[0])).KeySize
+		i, _ := self.find(entry[mapSlotHeader : mapSlotHeader+size])
+
+		copy(self.slots[int(i)*int(self.header.SlotSize):], entry)
+	}
+}
@@ -0,0 +1,233 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// creates a segment with a Map (of up to 11 entries, with keys of up to 8 bytes and values of up to
+// 24) in it, giving the callback two handles to it using separate attachments
+func makeHashMap(t *testing.T, callback func(m *Map, other *Map) error) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		mmap, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer mmap.Close()
+
+		omap, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer omap.Close()
+
+		if _, err := OpenMap(omap, 0); err != ErrNotMap {
+			return fmt.Errorf("Expected ErrNotMap opening an uninitialized map; got: %v", err)
+		}
+
+		if _, err := InitMap(mmap, 0, 100, 8, 24); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize initializing a map that doesn't fit; got: %v", err)
+		}
+
+		m, err := InitMap(mmap, 0, 11, 8, 24)
+
+		if err != nil {
+			return err
+		}
+
+		other, err := OpenMap(omap, 0)
+
+		if err != nil {
+			return err
+		}
+
+		return callback(m, other)
+	})
+}
+
+func TestMapPutGetDelete(t *testing.T) {
+	makeHashMap(t, func(m *Map, other *Map) error {
+		if err := m.Put([]byte(`alpha`), []byte(`one`)); err != nil {
+			return err
+		} else if err := m.Put([]byte(`beta`), nil); err != nil {
+			return err
+		} else if err := other.Put([]byte(`alpha`), []byte(`uno`)); err != nil {
+			return err
+		}
+
+		if value, ok, err := m.Get([]byte(`alpha`)); err != nil {
+			return err
+		} else if !ok || string(value) != `uno` {
+			return fmt.Errorf("Wrong value for alpha: %q (found: %v)", value, ok)
+		}
+
+		if value, ok, err := other.Get([]byte(`beta`)); err != nil || !ok || len(value) != 0 {
+			return fmt.Errorf("Expected an empty value for beta; got: %q, %v, %v", value, ok, err)
+		}
+
+		if _, ok, err := m.Get([]byte(`gamma`)); err != nil || ok {
+			return fmt.Errorf("Expected gamma to be missing; got: %v, %v", ok, err)
+		}
+
+		if m.Len() != 2 {
+			return fmt.Errorf("Wrong length; expected: 2, got: %d", m.Len())
+		}
+
+		if err := m.Put([]byte(`too long a key`), nil); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize for a long key; got: %v", err)
+		} else if err := m.Put([]byte(`k`), make([]byte, 25)); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize for a long value; got: %v", err)
+		} else if err := m.Put(nil, nil); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize for an empty key; got: %v", err)
+		}
+
+		if deleted, err := other.Delete([]byte(`alpha`)); err != nil || !deleted {
+			return fmt.Errorf("Expected to delete alpha; got: %v, %v", deleted, err)
+		} else if deleted, err := other.Delete([]byte(`alpha`)); err != nil || deleted {
+			return fmt.Errorf("Expected alpha to be gone; got: %v, %v", deleted, err)
+		}
+
+		if _, ok, _ := m.Get([]byte(`alpha`)); ok || m.Len() != 1 {
+			return fmt.Errorf("Deleted key is still present")
+		}
+
+		return nil
+	})
+}
+
+func TestMapFullAndRange(t *testing.T) {
+	makeHashMap(t, func(m *Map, other *Map) error {
+		for i := 0; i < m.Cap(); i++ {
+			if err := m.Put([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+				return err
+			}
+		}
+
+		if err := m.Put([]byte(`extra`), nil); err != ErrMapFull {
+			return fmt.Errorf("Expected ErrMapFull; got: %v", err)
+		} else if err := m.Put([]byte(`key3`), []byte(`updated`)); err != nil {
+			return fmt.Errorf("Updating an existing key in a full map failed: %v", err)
+		}
+
+		var entries []string
+
+		if err := other.Range(func(key []byte, value []byte) bool {
+			entries = append(entries, string(key)+`=`+string(value))
+			return true
+		}); err != nil {
+			return err
+		}
+
+		sort.Strings(entries)
+
+		if len(entries) != m.Cap() || entries[0] != `key0=value0` || entries[4] != `key3=updated` {
+			return fmt.Errorf("Wrong entries: %v", entries)
+		}
+
+		count := 0
+
+		m.Range(func(key []byte, value []byte) bool {
+			count++
+			return false
+		})
+
+		if count != 1 {
+			return fmt.Errorf("Range did not stop when asked to")
+		}
+
+		return nil
+	})
+}
+
+func TestMapChurn(t *testing.T) {
+	makeHashMap(t, func(m *Map, other *Map) error {
+		rng := rand.New(rand.NewSource(1))
+		model := make(map[string]string)
+
+		// repeatedly inserting and deleting leaves many deleted slots, which must be reclaimed
+		for i := 0; i < 5000; i++ {
+			key := fmt.Sprintf("k%d", rng.Intn(40))
+
+			if _, ok := model[key]; ok && rng.Intn(2) == 0 {
+				delete(model, key)
+
+				if deleted, err := m.Delete([]byte(key)); err != nil || !deleted {
+					return fmt.Errorf("Failed to delete %s: %v", key, err)
+				}
+			} else if ok || len(model) < m.Cap() {
+				value := fmt.Sprintf("v%d", i)
+				model[key] = value
+
+				if err := other.Put([]byte(key), []byte(value)); err != nil {
+					return fmt.Errorf("Failed to put %s after %d operations: %v", key, i, err)
+				}
+			}
+
+			if m.Len() != len(model) {
+				return fmt.Errorf("Wrong length after %d operations; expected: %d, got: %d", i, len(model), m.Len())
+			}
+		}
+
+		for key, expected := range model {
+			if value, ok, err := m.Get([]byte(key)); err != nil || !ok || string(value) != expected {
+				return fmt.Errorf("Wrong value for %s; expected: %s, got: %q (%v, %v)", key, expected, value, ok, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func TestMapConcurrent(t *testing.T) {
+	makeHashMap(t, func(m *Map, other *Map) error {
+		var wg sync.WaitGroup
+
+		errs := make(chan error, 2)
+
+		for w, handle := range []*Map{m, other} {
+			wg.Add(1)
+
+			go func(w int, handle *Map) {
+				defer wg.Done()
+
+				for i := 0; i < 500; i++ {
+					key := []byte(fmt.Sprintf("w%d-%d", w, i%5))
+					value := []byte(fmt.Sprintf("%d", i))
+
+					if err := handle.Put(key, value); err != nil {
+						errs <- err
+						return
+					} else if got, ok, err := handle.Get(key); err != nil || !ok {
+						errs <- fmt.Errorf("Lost %s: %v", key, err)
+						return
+					} else if string(got) != string(value) {
+						errs <- fmt.Errorf("Wrong value for %s; expected: %s, got: %s", key, value, got)
+						return
+					}
+				}
+			}(w, handle)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		if err := <-errs; err != nil {
+			return err
+		} else if m.Len() != 10 {
+			return fmt.Errorf("Wrong length; expected: 10, got: %d", m.Len())
+		}
+
+		return nil
+	})
+}
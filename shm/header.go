@@ -0,0 +1,442 @@
+package shm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"unsafe"
+)
+
+const (
+	// Identifies shared memory that begins with a segment header ("SHMH").
+	HeaderMagic = 0x484d4853
+
+	// The version of the header format implemented by this package.  ReadHeader refuses to parse
+	// headers with any other format version.
+	HeaderVersion = 1
+
+	// The size of the fixed part of the header.  The content type and metadata follow it.
+	HeaderFixedSize = 64
+
+	// The payload offset of a header is always a multiple of this, so that structures stored at the
+	// start of the payload are suitably aligned.
+	HeaderAlignment = 64
+
+	// The offset within the header of the generation counter used by Segment.Notify and Segment.Wait.
+	HeaderGenerationOffset = 40
+)
+
+// Returned by ReadHeader when the region does not begin with a segment header.
+var ErrNoHeader = errors.New(`shared memory does not begin with a segment header`)
+
+// The byte order of the multi-byte values in a segment's payload.
+type Endianness uint8
+
+const (
+	UnknownEndian Endianness = iota
+	LittleEndian
+	BigEndian
+)
+
+// The byte order of the machine this process is running on.
+var HostEndian = func() Endianness {
+	x := uint16(1)
+
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return LittleEndian
+	}
+
+	return BigEndian
+}()
+
+// Returns the encoding/binary byte order corresponding to this endianness, or nil if it is unknown.
+func (self Endianness) ByteOrder() binary.ByteOrder {
+	switch self {
+	case LittleEndian:
+		return binary.LittleEndian
+	case BigEndian:
+		return binary.BigEndian
+	default:
+		return nil
+	}
+}
+
+func (self Endianness) String() string {
+	switch self {
+	case LittleEndian:
+		return `little`
+	case BigEndian:
+		return `big`
+	default:
+		return `unknown`
+	}
+}
+
+func (self Endianness) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+// Describes how the payload of a segment is structured, so that tools can read it appropriately.
+type Layout uint32
+
+const (
+	// The payload is opaque data.
+	LayoutRaw Layout = iota
+
+	// The payload is a Snapshot, which must be read with Snapshot.Load() to avoid torn reads.
+	LayoutSnapshot
+
+	// The payload is a Ring.
+	LayoutRing
+
+	// The payload is a Queue.
+	LayoutQueue
+
+	// The payload is an Arena.
+	LayoutArena
+
+	// The payload is a Map.
+	LayoutMap
+)
+
+var layoutNames = map[Layout]string{
+	LayoutRaw:      `raw`,
+	LayoutSnapshot: `snapshot`,
+	LayoutRing:     `ring`,
+	LayoutQueue:    `queue`,
+	LayoutArena:    `arena`,
+	LayoutMap:      `map`,
+}
+
+func (self Layout) String() string {
+	if name, ok := layoutNames[self]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("unknown(%d)", uint32(self))
+}
+
+func (self Layout) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+// An optional, self-describing header stored at the start of a segment, which tells readers what the
+// rest of the segment (the payload) contains and where it is.  The header is stored in a fixed byte
+// order (little endian) regardless of the payload's endianness, so that it can be read anywhere.
+//
+// The encoded header consists of a fixed 64 byte part:
+//
+//	offset  size  field
+//	0       4     magic number (0x484d4853, "SHMH")
+//	4       2     format version
+//	6       1     payload endianness (0 = unknown, 1 = little, 2 = big)
+//	7       1     reserved
+//	8       4     payload layout
+//	12      4     length of the variable part that follows the fixed part
+//	16      8     payload offset
+//	24      8     payload length
+//	32      8     creation time (nanoseconds since the Unix epoch)
+//	40      4     generation counter (see Segment.Notify)
+//	44      20    reserved
+//
+// followed by the content type and metadata, each string preceded by its length as a 16-bit integer
+// and the metadata by the number of entries as a 16-bit integer.  The payload starts at the next
+// multiple of 64 bytes.
+type Header struct {
+	// The header format version.
+	Version int `json:"version"`
+
+	// How the payload is structured.
+	Layout Layout `json:"layout"`
+
+	// A MIME type (or any other description) of the payload's contents.
+	ContentType string `json:"content_type,omitempty"`
+
+	// The byte order of multi-byte values in the payload.
+	Endianness Endianness `json:"endianness"`
+
+	// Where the payload starts, relative to the start of the segment.
+	PayloadOffset int64 `json:"payload_offset"`
+
+	// The length of the payload.
+	PayloadLength int64 `json:"payload_length"`
+
+	// When the header was written.
+	Created time.Time `json:"created"`
+
+	// Arbitrary key/value pairs describing the payload (e.g.: a schema name or version).
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	// The value of the generation counter when the header was read.  WriteHeader leaves the counter
+	// as it is.
+	Generation uint32 `json:"generation"`
+}
+
+// Returns the number of bytes needed to store the header itself, before alignment.
+func (self *Header) encodedSize() int64 {
+	size := int64(HeaderFixedSize + 2 + len(self.ContentType) + 2)
+
+	for key, value := range self.Metadata {
+		size += int64(2 + len(key) + 2 + len(value))
+	}
+
+	return size
+}
+
+// Returns the smallest payload offset at which the payload can follow this header.
+//
+func (self *Header) MinPayloadOffset() int64 {
+	return (self.encodedSize() + HeaderAlignment - 1) / HeaderAlignment * HeaderAlignment
+}
+
+func (self *Header) encode() ([]byte, error) {
+	if len(self.ContentType) > 0xffff {
+		return nil, fmt.Errorf("Content type is too long (%d bytes)", len(self.ContentType))
+	} else if len(self.Metadata) > 0xffff {
+		return nil, fmt.Errorf("Too many metadata entries (%d)", len(self.Metadata))
+	}
+
+	buf := make([]byte, self.encodedSize())
+	le := binary.LittleEndian
+
+	le.PutUint32(buf[0:], HeaderMagic)
+	le.PutUint16(buf[4:], uint16(self.Version))
+	buf[6] = byte(self.Endianness)
+	le.PutUint32(buf[8:], uint32(self.Layout))
+	le.PutUint32(buf[12:], uint32(len(buf)-HeaderFixedSize))
+	le.PutUint64(buf[16:], uint64(self.PayloadOffset))
+	le.PutUint64(buf[24:], uint64(self.PayloadLength))
+	le.PutUint64(buf[32:], uint64(self.Created.UnixNano()))
+
+	pos := HeaderFixedSize
+
+	putString := func(value string) {
+		le.PutUint16(buf[pos:], uint16(len(value)))
+		pos += 2 + copy(buf[pos+2:], value)
+	}
+
+	putString(self.ContentType)
+	le.PutUint16(buf[pos:], uint16(len(self.Metadata)))
+	pos += 2
+
+	keys := make([]string, 0, len(self.Metadata))
+
+	for key, value := range self.Metadata {
+		if len(key) > 0xffff || len(value) > 0xffff {
+			return nil, fmt.Errorf("Metadata entry %q is too long", key)
+		}
+
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		putString(key)
+		putString(self.Metadata[key])
+	}
+
+	return buf, nil
+}
+
+// Reads and parses the segment header at the start of the given region.  Returns ErrNoHeader if the
+// region does not begin with one.
+//
+func ReadHeader(region Region) (*Header, error) {
+	fixed := make([]byte, HeaderFixedSize)
+
+	if region.Len() < HeaderFixedSize {
+		return nil, ErrNoHeader
+	} else if _, err := region.ReadAt(fixed, 0); err != nil {
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+
+	if le.Uint32(fixed[0:]) != HeaderMagic {
+		return nil, ErrNoHeader
+	} else if version := le.Uint16(fixed[4:]); version != HeaderVersion {
+		return nil, fmt.Errorf("Unsupported segment header version %d", version)
+	}
+
+	header := &Header{
+		Version:       HeaderVersion,
+		Endianness:    Endianness(fixed[6]),
+		Layout:        Layout(le.Uint32(fixed[8:])),
+		PayloadOffset: int64(le.Uint64(fixed[16:])),
+		PayloadLength: int64(le.Uint64(fixed[24:])),
+		Created:       time.Unix(0, int64(le.Uint64(fixed[32:]))),
+		Generation:    le.Uint32(fixed[HeaderGenerationOffset:]),
+	}
+
+	extra := int64(le.Uint32(fixed[12:]))
+
+	if header.PayloadOffset < HeaderFixedSize+extra || header.PayloadOffset > region.Len() {
+		return nil, fmt.Errorf("Malformed segment header: payload offset %d is out of range", header.PayloadOffset)
+	} else if header.PayloadLength < 0 || header.PayloadLength > region.Len()-header.PayloadOffset {
+		return nil, fmt.Errorf("Malformed segment header: payload length %d is out of range", header.PayloadLength)
+	}
+
+	buf := make([]byte, extra)
+
+	if _, err := region.ReadAt(buf, HeaderFixedSize); err != nil {
+		return nil, err
+	}
+
+	pos := 0
+	truncated := false
+
+	getString := func() string {
+		if truncated || pos+2 > len(buf) {
+			truncated = true
+			return ``
+		}
+
+		length := int(le.Uint16(buf[pos:]))
+		pos += 2
+
+		if pos+length > len(buf) {
+			truncated = true
+			return ``
+		}
+
+		pos += length
+		return string(buf[pos-length : pos])
+	}
+
+	header.ContentType = getString()
+
+	if pos+2 <= len(buf) {
+		count := int(le.Uint16(buf[pos:]))
+		pos += 2
+
+		for i := 0; i < count && !truncated; i++ {
+			key := getString()
+			value := getString()
+
+			if header.Metadata == nil {
+				header.Metadata = make(map[string]string)
+			}
+
+			header.Metadata[key] = value
+		}
+	} else {
+		truncated = true
+	}
+
+	if truncated {
+		return nil, fmt.Errorf("Malformed segment header: content type or metadata is truncated")
+	}
+
+	return header, nil
+}
+
+// Writes the given header to the start of the region.  Fields left at their zero values are filled in
+// with defaults: the current format version, the host's endianness, the current time, the smallest
+// payload offset that fits the header, and a payload length extending to the end of the region.  The
+// generation counter starts at zero when the region did not already have a header, and is otherwise
+// left as it was.
+//
+func WriteHeader(region Region, header *Header) error {
+	if header.Version == 0 {
+		header.Version = HeaderVersion
+	} else if header.Version != HeaderVersion {
+		return fmt.Errorf("Unsupported segment header version %d", header.Version)
+	}
+
+	if header.Endianness == UnknownEndian {
+		header.Endianness = HostEndian
+	}
+
+	if header.Created.IsZero() {
+		header.Created = time.Now()
+	}
+
+	if header.PayloadOffset == 0 {
+		header.PayloadOffset = header.MinPayloadOffset()
+	} else if header.PayloadOffset < header.encodedSize() {
+		return fmt.Errorf("Payload offset %d would overlap the %d byte header", header.PayloadOffset, header.encodedSize())
+	}
+
+	if header.PayloadOffset > region.Len() {
+		return fmt.Errorf("A %d byte region is too small for the header: %w", region.Len(), ErrInvalidSize)
+	}
+
+	if header.PayloadLength == 0 {
+		header.PayloadLength = region.Len() - header.PayloadOffset
+	} else if header.PayloadLength < 0 || header.PayloadLength > region.Len()-header.PayloadOffset {
+		return fmt.Errorf("Payload length %d does not fit in the region: %w", header.PayloadLength, ErrInvalidSize)
+	}
+
+	buf, err := header.encode()
+
+	if err != nil {
+		return err
+	}
+
+	// when rewriting an existing header, skip over the generation counter, since processes may be
+	// waiting on it; otherwise whatever was stored there would become the initial generation, so it is
+	// zeroed along with the rest
+	magic := make([]byte, 4)
+
+	if _, err := region.ReadAt(magic, 0); err != nil {
+		return err
+	} else if binary.LittleEndian.Uint32(magic) != HeaderMagic {
+		_, err := region.WriteAt(buf, 0)
+		return err
+	}
+
+	if _, err := region.WriteAt(buf[:HeaderGenerationOffset], 0); err != nil {
+		return err
+	}
+
+	_, err = region.WriteAt(buf[HeaderGenerationOffset+4:], HeaderGenerationOffset+4)
+	return err
+}
+
+// Records the layout of a payload being initialized at the given offset of the mapping in the segment
+// header at the start of the mapping, so that tools reading the header know what the payload holds.
+// Nothing is written unless the mapping begins with a header describing a payload at that offset.
+func setHeaderLayout(mapping *Mapping, offset int64, layout Layout) {
+	data := mapping.Bytes()
+	le := binary.LittleEndian
+
+	if mapping.ReadOnly() || len(data) < HeaderFixedSize || offset < HeaderFixedSize {
+		return
+	} else if le.Uint32(data[0:]) != HeaderMagic || le.Uint16(data[4:]) != HeaderVersion {
+		return
+	} else if int64(le.Uint64(data[16:])) != offset {
+		return
+	}
+
+	le.PutUint32(data[8:], uint32(layout))
+}
+
+// Creates a new private segment large enough to hold the given header followed by a payload of the
+// given size, and writes the header to it.
+//
+func CreateWithHeader(header *Header, payloadSize int) (*Segment, error) {
+	offset := header.PayloadOffset
+
+	if offset == 0 {
+		offset = header.MinPayloadOffset()
+	}
+
+	segment, err := Create(int(offset) + payloadSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	header.PayloadOffset = offset
+	header.PayloadLength = int64(payloadSize)
+
+	if err := WriteHeader(segment, header); err != nil {
+		segment.Destroy()
+		return nil, err
+	}
+
+	return segment, nil
+}
@@ -0,0 +1,174 @@
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if _, err := ReadHeader(segment); err != ErrNoHeader {
+			return fmt.Errorf("Expected ErrNoHeader reading an empty segment; got: %v", err)
+		}
+
+		created := time.Unix(1500000000, 123)
+
+		header := &Header{
+			Layout:      LayoutSnapshot,
+			ContentType: `application/x-telemetry`,
+			Endianness:  BigEndian,
+			Created:     created,
+			Metadata: map[string]string{
+				`schema`:  `telemetry.v2`,
+				`empty`:   ``,
+				`unicode`: `✓`,
+			},
+		}
+
+		if err := WriteHeader(segment, header); err != nil {
+			return err
+		}
+
+		if header.PayloadOffset != 192 || header.PayloadLength != 1024-192 {
+			return fmt.Errorf("Wrong defaults: offset %d, length %d", header.PayloadOffset, header.PayloadLength)
+		}
+
+		parsed, err := ReadHeader(segment)
+
+		if err != nil {
+			return err
+		}
+
+		if parsed.Version != HeaderVersion || parsed.Layout != LayoutSnapshot || parsed.Endianness != BigEndian {
+			return fmt.Errorf("Wrong fields: %+v", parsed)
+		} else if parsed.ContentType != header.ContentType || !parsed.Created.Equal(created) {
+			return fmt.Errorf("Wrong content type or creation time: %+v", parsed)
+		} else if parsed.PayloadOffset != header.PayloadOffset || parsed.PayloadLength != header.PayloadLength {
+			return fmt.Errorf("Wrong payload: %d+%d", parsed.PayloadOffset, parsed.PayloadLength)
+		} else if fmt.Sprint(parsed.Metadata) != fmt.Sprint(header.Metadata) {
+			return fmt.Errorf("Wrong metadata: %v", parsed.Metadata)
+		}
+
+		return nil
+	})
+}
+
+func TestHeaderGeneration(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		garbage := make([]byte, 1024)
+
+		for i := range garbage {
+			garbage[i] = 0xa5
+		}
+
+		if _, err := segment.WriteAt(garbage, 0); err != nil {
+			return err
+		}
+
+		// a new header doesn't inherit whatever was stored where the generation goes
+		if err := WriteHeader(segment, &Header{}); err != nil {
+			return err
+		} else if header, err := ReadHeader(segment); err != nil {
+			return err
+		} else if header.Generation != 0 {
+			return fmt.Errorf("Wrong initial generation; expected: 0, got: %#x", header.Generation)
+		}
+
+		// but rewriting an existing header leaves it alone
+		if _, err := segment.WriteAt([]byte{5, 0, 0, 0}, HeaderGenerationOffset); err != nil {
+			return err
+		} else if err := WriteHeader(segment, &Header{ContentType: `text/plain`}); err != nil {
+			return err
+		} else if header, err := ReadHeader(segment); err != nil {
+			return err
+		} else if header.Generation != 5 {
+			return fmt.Errorf("Rewriting the header changed the generation to %d", header.Generation)
+		}
+
+		return nil
+	})
+}
+
+func TestHeaderInvalid(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if err := WriteHeader(segment, &Header{PayloadLength: 2048}); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize for a payload longer than the segment; got: %v", err)
+		}
+
+		if err := WriteHeader(segment, &Header{PayloadOffset: 16}); err == nil {
+			return fmt.Errorf("Expected a payload overlapping the header to be rejected")
+		}
+
+		if err := WriteHeader(segment, &Header{}); err != nil {
+			return err
+		}
+
+		// corrupt the payload offset
+		if _, err := segment.WriteAt([]byte{0xff, 0xff}, 16); err != nil {
+			return err
+		}
+
+		if _, err := ReadHeader(segment); err == nil || err == ErrNoHeader {
+			return fmt.Errorf("Expected a malformed header error; got: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func TestCreateWithHeader(t *testing.T) {
+	segment, err := CreateWithHeader(&Header{ContentType: `text/plain`}, 100)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer segment.Destroy()
+
+	if header, err := ReadHeader(segment); err != nil {
+		t.Fatal(err)
+	} else if header.PayloadOffset != 128 || header.PayloadLength != 100 {
+		t.Errorf("Wrong payload: %d+%d", header.PayloadOffset, header.PayloadLength)
+	} else if segment.Size != 128+100 {
+		t.Errorf("Wrong segment size: %d", segment.Size)
+	} else if header.Endianness != HostEndian || header.Created.IsZero() {
+		t.Errorf("Expected defaults to be filled in: %+v", header)
+	}
+}
+
+// creates a segment with a header and a 1024 byte payload, and maps it for the callback
+func makeHeaderSegment(t *testing.T, callback func(segment *Segment, mapping *Mapping, header *Header) error) {
+	header := &Header{}
+	segment, err := CreateWithHeader(header, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer segment.Destroy()
+
+	mapping, err := segment.Map(AttachOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mapping.Close()
+
+	if err := callback(segment, mapping, header); err != nil {
+		t.Error(err)
+	}
+}
+
+// checks that the segment's header survived initializing its payload, and describes the given layout
+func checkHeaderLayout(segment *Segment, layout Layout) error {
+	if header, err := ReadHeader(segment); err != nil {
+		return fmt.Errorf("Header was damaged by initializing the payload: %v", err)
+	} else if header.Layout != layout {
+		return fmt.Errorf("Wrong header layout; expected: %v, got: %v", layout, header.Layout)
+	}
+
+	return nil
+}
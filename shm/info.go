@@ -0,0 +1,67 @@
+package shm
+
+import (
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	modeDestroyPending = 01000
+	modeLocked         = 02000
+)
+
+// Metadata describing a shared memory segment, as reported by the kernel.  This is also used to
+// describe other kinds of Region, in which case Id is -1 and fields that do not apply (such as Key
+// and Attached) are left at their zero values.
+type SegmentInfo struct {
+	URI            string      `json:"uri"`
+	Id             int         `json:"id"`
+	Key            Key         `json:"key"`
+	Size           int64       `json:"size"`
+	Mode           os.FileMode `json:"mode"`
+	OwnerUID       int         `json:"owner_uid"`
+	OwnerGID       int         `json:"owner_gid"`
+	CreatorUID     int         `json:"creator_uid"`
+	CreatorGID     int         `json:"creator_gid"`
+	Attached       int         `json:"attached"`
+	CreatorPID     int         `json:"creator_pid"`
+	LastPID        int         `json:"last_pid"`
+	LastAttached   time.Time   `json:"last_attached"`
+	LastDetached   time.Time   `json:"last_detached"`
+	LastChanged    time.Time   `json:"last_changed"`
+	DestroyPending bool        `json:"destroy_pending"`
+	Locked         bool        `json:"locked"`
+}
+
+// Returns a short, comma-separated description of the segment's status flags, or an empty string
+// if no flags are set.
+func (self *SegmentInfo) Status() string {
+	var flags []string
+
+	if self.Locked {
+		flags = append(flags, `locked`)
+	}
+
+	if self.DestroyPending {
+		flags = append(flags, `destroy-pending`)
+	}
+
+	return strings.Join(flags, `,`)
+}
+
+// populate the permission and status fields from the raw ipc_perm mode
+func (self *SegmentInfo) setMode(mode uint32) {
+	self.Mode = os.FileMode(mode & 0777)
+	self.DestroyPending = (mode&modeDestroyPending != 0)
+	self.Locked = (mode&modeLocked != 0)
+}
+
+// convert a time_t into a time.Time, treating the epoch as "never"
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, 0)
+}
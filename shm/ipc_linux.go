@@ -0,0 +1,27 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package shm
+
+// Definitions shared by the SysV IPC mechanisms that are accessed with raw system calls.
+
+const (
+	ipcRmid = 0
+	ipcSet  = 1
+	ipcStat = 2
+)
+
+// The kernel's struct ipc64_perm, as laid out on 64-bit Linux.
+type ipcPerm struct {
+	Key  int32
+	Uid  uint32
+	Gid  uint32
+	Cuid uint32
+	Cgid uint32
+	Mode uint32
+	Seq  uint16
+	_    uint16
+	_    uint64
+	_    uint64
+}
@@ -0,0 +1,113 @@
+package shm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The procfs file listing every SysV shared memory segment on the system (Linux only).
+var ProcSysvShmPath = `/proc/sysvipc/shm`
+
+// Retrieve the metadata for every shared memory segment on the system, regardless of whether the
+// caller has permission to access them.  Segments are returned in the order the kernel lists them.
+//
+func List() ([]*SegmentInfo, error) {
+	if file, err := os.Open(ProcSysvShmPath); err == nil {
+		defer file.Close()
+		return parseProcSysvShm(file)
+	} else {
+		return nil, fmt.Errorf("Failed to list shared memory segments: %w", err)
+	}
+}
+
+// parse the contents of /proc/sysvipc/shm
+func parseProcSysvShm(r io.Reader) ([]*SegmentInfo, error) {
+	var segments []*SegmentInfo
+
+	err := parseProcSysvipc(r, func(field func(name string) int64) {
+		info := &SegmentInfo{
+			Id:           int(field(`shmid`)),
+			Key:          Key(field(`key`)),
+			Size:         field(`size`),
+			OwnerUID:     int(field(`uid`)),
+			OwnerGID:     int(field(`gid`)),
+			CreatorUID:   int(field(`cuid`)),
+			CreatorGID:   int(field(`cgid`)),
+			Attached:     int(field(`nattch`)),
+			CreatorPID:   int(field(`cpid`)),
+			LastPID:      int(field(`lpid`)),
+			LastAttached: unixTime(field(`atime`)),
+			LastDetached: unixTime(field(`dtime`)),
+			LastChanged:  unixTime(field(`ctime`)),
+		}
+
+		info.setMode(uint32(field(`perms`)))
+		info.URI = segmentURI(info.Id)
+
+		segments = append(segments, info)
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse segment list: %v", err)
+	}
+
+	return segments, nil
+}
+
+// parse one of the tables in /proc/sysvipc, locating each field by its column header, and calling
+// row once for every entry with a function that returns the named field of that entry
+func parseProcSysvipc(r io.Reader, row func(field func(name string) int64)) error {
+	var columns map[string]int
+
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if columns == nil {
+			columns = make(map[string]int)
+
+			for i, name := range fields {
+				columns[name] = i
+			}
+
+			continue
+		}
+
+		var perr error
+
+		row(func(name string) int64 {
+			if i, ok := columns[name]; ok && i < len(fields) {
+				base := 10
+
+				if name == `perms` {
+					base = 8
+				}
+
+				if v, err := strconv.ParseInt(fields[i], base, 64); err == nil {
+					return v
+				} else if perr == nil {
+					perr = fmt.Errorf("Invalid value %q for field %q: %v", fields[i], name, err)
+				}
+			} else if perr == nil {
+				perr = fmt.Errorf("Missing field %q", name)
+			}
+
+			return 0
+		})
+
+		if perr != nil {
+			return perr
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,61 @@
+package shm
+
+import (
+	"strings"
+	"testing"
+)
+
+const testProcSysvShm = `       key      shmid perms                  size  cpid  lpid nattch   uid   gid  cuid  cgid      atime      dtime      ctime                   rss                  swap
+         0          4  1600                  1024   100   101      2  1000  1000     0     0 1555000000 1555000001 1555000002                  4096                     0
+     20816          5  2644                 65536   200   200      0     0     0     0     0          0          0 1555000003                     0                     0
+`
+
+func TestParseProcSysvShm(t *testing.T) {
+	segments, err := parseProcSysvShm(strings.NewReader(testProcSysvShm))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(segments) != 2 {
+		t.Fatalf("Wrong number of segments; expected: 2, got: %d", len(segments))
+	}
+
+	a := segments[0]
+
+	if a.Id != 4 || a.Key != IpcPrivate || a.Size != 1024 {
+		t.Errorf("Wrong identity for first segment: %+v", a)
+	} else if a.Mode != 0600 || !a.DestroyPending || a.Locked {
+		t.Errorf("Wrong mode for first segment: %v (destroy-pending=%v locked=%v)", a.Mode, a.DestroyPending, a.Locked)
+	} else if a.OwnerUID != 1000 || a.CreatorUID != 0 || a.Attached != 2 || a.LastPID != 101 {
+		t.Errorf("Wrong ownership for first segment: %+v", a)
+	} else if a.LastChanged.Unix() != 1555000002 {
+		t.Errorf("Wrong change time for first segment: %v", a.LastChanged)
+	}
+
+	b := segments[1]
+
+	if b.Key != Key(0x5150) || b.Mode != 0644 || !b.Locked || b.DestroyPending {
+		t.Errorf("Wrong attributes for second segment: %+v", b)
+	} else if !b.LastAttached.IsZero() {
+		t.Errorf("Expected second segment to never have been attached")
+	}
+}
+
+func TestList(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if segments, err := List(); err == nil {
+			for _, info := range segments {
+				if info.Id == segment.Id {
+					return nil
+				}
+			}
+
+			t.Errorf("Segment %d not found in list of %d segments", segment.Id, len(segments))
+		} else {
+			t.Skipf("Cannot list segments on this system: %v", err)
+		}
+
+		return nil
+	})
+}
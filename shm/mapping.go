@@ -0,0 +1,129 @@
+package shm
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// A persistent attachment of shared memory into the current process's address space.  Unlike the
+// Read() and Write() methods of Segment (which attach and detach the segment on every call), a
+// Mapping remains attached until Close() is called and exposes the memory directly as a byte slice,
+// so no intermediate copies are made.
+//
+// A Mapping is not safe for concurrent use by multiple goroutines unless the caller coordinates
+// access to the underlying memory.
+type Mapping struct {
+	source   SharedMemory
+	addr     unsafe.Pointer
+	data     []byte
+	readOnly bool
+}
+
+// Attaches the segment with the given options and keeps it attached, returning a Mapping that
+// provides direct access to the segment's memory.  The Mapping must be closed to detach it.  If
+// opts.ReadOnly is set, writes made through WriteAt will fail, and writes made directly to the slice
+// returned by Bytes() will cause a segmentation fault.
+//
+func (self *Segment) Map(opts AttachOptions) (*Mapping, error) {
+	if addr, err := self.AttachWith(opts); err == nil {
+		return newMapping(self, addr, self.Size, opts), nil
+	} else {
+		return nil, fmt.Errorf("Failed to attach segment %d: %w", self.Id, err)
+	}
+}
+
+func newMapping(source SharedMemory, addr unsafe.Pointer, size int64, opts AttachOptions) *Mapping {
+	return &Mapping{
+		source:   source,
+		addr:     addr,
+		data:     bytesAt(addr, int(size)),
+		readOnly: opts.ReadOnly,
+	}
+}
+
+// Returns the shared memory this mapping is attached to.
+func (self *Mapping) Source() SharedMemory {
+	return self.source
+}
+
+// Returns the segment's memory as a byte slice.  The slice is only valid until Close() is called;
+// accessing it afterwards will cause a segmentation fault.
+func (self *Mapping) Bytes() []byte {
+	return self.data
+}
+
+// Returns the address the segment is attached at.
+func (self *Mapping) Pointer() unsafe.Pointer {
+	return self.addr
+}
+
+// Returns the size of the mapped memory (in bytes).
+func (self *Mapping) Len() int {
+	return len(self.data)
+}
+
+// Returns whether the segment was attached read-only.
+func (self *Mapping) ReadOnly() bool {
+	return self.readOnly
+}
+
+// Implements the io.ReaderAt interface, copying directly out of the attached memory.
+//
+func (self *Mapping) ReadAt(p []byte, off int64) (int, error) {
+	if self.data == nil {
+		return 0, fmt.Errorf("Cannot read from a closed mapping")
+	} else if off < 0 {
+		return 0, fmt.Errorf("Cannot read from a negative offset")
+	} else if off >= int64(len(self.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(p, self.data[off:])
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Implements the io.WriterAt interface, copying directly into the attached memory.  Writes that
+// would extend beyond the end of the segment are truncated and return io.EOF.
+//
+func (self *Mapping) WriteAt(p []byte, off int64) (int, error) {
+	if self.data == nil {
+		return 0, fmt.Errorf("Cannot write to a closed mapping")
+	} else if self.readOnly {
+		return 0, fmt.Errorf("Cannot write to a read-only mapping")
+	} else if off < 0 {
+		return 0, fmt.Errorf("Cannot write to a negative offset")
+	} else if off >= int64(len(self.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(self.data[off:], p)
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Detaches the memory from the current process.  Calling Close() more than once has no effect.
+//
+func (self *Mapping) Close() error {
+	if self.data == nil {
+		return nil
+	}
+
+	self.data = nil
+
+	return self.source.Detach(self.addr)
+}
+
+// wrap a region of memory not managed by the Go runtime in a byte slice
+func bytesAt(addr unsafe.Pointer, length int) []byte {
+	return unsafe.Slice((*byte)(addr), length)
+}
@@ -0,0 +1,117 @@
+package shm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestMappingReadWriteAt(t *testing.T) {
+	writeFullSegment(t, 1024, func(segment *Segment, input []byte) error {
+		mapping, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer mapping.Close()
+
+		if mapping.Len() != int(segment.Size) {
+			return fmt.Errorf("Wrong mapping length; expected: %d, got: %d", segment.Size, mapping.Len())
+		}
+
+		if !bytes.Equal(mapping.Bytes()[:len(input)], input) {
+			return fmt.Errorf("Mapped memory does not match the data written to the segment")
+		}
+
+		output := make([]byte, 16)
+
+		if n, err := mapping.ReadAt(output, 256); err != nil {
+			return err
+		} else if n != 16 || !bytes.Equal(output, input[256:272]) {
+			return fmt.Errorf("Wrong data read at offset 256: %v", output[:n])
+		}
+
+		if n, err := mapping.WriteAt([]byte{0xAA, 0xBB}, 10); err != nil || n != 2 {
+			return fmt.Errorf("Failed to write at offset 10: n=%d err=%v", n, err)
+		}
+
+		// verify the write is visible through the non-mapped interface
+		if chunk, err := segment.ReadChunk(2, 10); err != nil {
+			return err
+		} else if !bytes.Equal(chunk, []byte{0xAA, 0xBB}) {
+			return fmt.Errorf("Write through mapping not visible in segment: %v", chunk)
+		}
+
+		if n, err := mapping.ReadAt(output, segment.Size-4); err != io.EOF || n != 4 {
+			return fmt.Errorf("Expected short read at end of segment; got n=%d err=%v", n, err)
+		}
+
+		if n, err := mapping.WriteAt(output, segment.Size-4); err != io.EOF || n != 4 {
+			return fmt.Errorf("Expected short write at end of segment; got n=%d err=%v", n, err)
+		}
+
+		if _, err := mapping.ReadAt(output, segment.Size); err != io.EOF {
+			return fmt.Errorf("Expected EOF reading past end of segment; got: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func TestMappingReadOnly(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		mapping, err := segment.Map(AttachOptions{
+			ReadOnly: true,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		if _, err := mapping.WriteAt([]byte{1}, 0); err == nil {
+			return fmt.Errorf("Expected write to a read-only mapping to fail")
+		}
+
+		if err := mapping.Close(); err != nil {
+			return err
+		}
+
+		if err := mapping.Close(); err != nil {
+			return fmt.Errorf("Expected repeated Close() to succeed: %v", err)
+		}
+
+		if _, err := mapping.ReadAt(make([]byte, 1), 0); err == nil {
+			return fmt.Errorf("Expected read from a closed mapping to fail")
+		}
+
+		return nil
+	})
+}
+
+func TestMappingAttachCount(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		mapping, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		if info, err := segment.Stat(); err != nil {
+			return err
+		} else if info.Attached != 1 {
+			return fmt.Errorf("Wrong attach count while mapped; expected: 1, got: %d", info.Attached)
+		}
+
+		mapping.Close()
+
+		if info, err := segment.Stat(); err != nil {
+			return err
+		} else if info.Attached != 0 {
+			return fmt.Errorf("Wrong attach count after close; expected: 0, got: %d", info.Attached)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,236 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package shm
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	mfdCloexec       = 0x0001
+	mfdAllowSealing  = 0x0002
+	fcntlAddSeals    = 1033
+	fcntlGetSeals    = 1034
+	memfdLinkPrefix  = `/memfd:`
+	memfdLinkDeleted = ` (deleted)`
+)
+
+// A set of seals that restrict how a memfd may be modified, as with fcntl(F_ADD_SEALS).  Once
+// added, seals can never be removed.
+type Seals int
+
+const (
+	// Prevents any further seals from being added.
+	SealSeal Seals = 0x0001
+
+	// Prevents the memfd from being made smaller.
+	SealShrink Seals = 0x0002
+
+	// Prevents the memfd from being made larger.
+	SealGrow Seals = 0x0004
+
+	// Prevents the contents of the memfd from being modified.  This fails if the memfd is currently
+	// attached read-write.
+	SealWrite Seals = 0x0008
+)
+
+// An anonymous region of shared memory created with memfd_create(2).  Unlike SysV segments and POSIX
+// objects, a memfd has no global name or ID; it can only be shared by passing its file descriptor to
+// another process (see SendMemfd and ReceiveMemfd), so it is released as soon as every process
+// holding it has closed it.  A memfd can be sealed to guarantee to its recipients that it will not
+// change size or contents.
+type Memfd struct {
+	fileMemory
+	Name string
+}
+
+// Create a new memfd of the given size (in bytes).  The name is only used for debugging; it appears
+// in /proc/PID/fd and need not be unique.  If sealable is false, no seals can ever be added.
+//
+func CreateMemfd(name string, size int64, sealable bool) (*Memfd, error) {
+	flags := mfdCloexec
+
+	if size <= 0 {
+		return nil, fmt.Errorf("Must specify a memfd size: %w", ErrInvalidSize)
+	}
+
+	if sealable {
+		flags |= mfdAllowSealing
+	}
+
+	cname, err := syscall.BytePtrFromString(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fd, _, errno := syscall.Syscall(sysMemfdCreate, uintptr(unsafe.Pointer(cname)), uintptr(flags), 0)
+
+	if errno != 0 {
+		return nil, fmt.Errorf("Failed to create memfd: %w", errno)
+	}
+
+	return newMemfd(int(fd), name, size)
+}
+
+// Wrap an existing memfd file descriptor, such as one received from another process or inherited
+// from a parent process.  The Memfd takes ownership of the descriptor, which is closed if an error is
+// returned.
+//
+func MemfdFromFd(fd int) (*Memfd, error) {
+	name := ``
+
+	if link, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd)); err == nil {
+		if !strings.HasPrefix(link, memfdLinkPrefix) {
+			syscall.Close(fd)
+			return nil, fmt.Errorf("File descriptor %d is not a memfd: %s: %w", fd, link, ErrInvalidArgument)
+		}
+
+		name = strings.TrimSuffix(strings.TrimPrefix(link, memfdLinkPrefix), memfdLinkDeleted)
+	}
+
+	return newMemfd(fd, name, 0)
+}
+
+func newMemfd(fd int, name string, size int64) (*Memfd, error) {
+	var readOnly bool
+
+	if flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFL, 0); errno == 0 {
+		readOnly = (int(flags)&syscall.O_ACCMODE == syscall.O_RDONLY)
+	} else {
+		syscall.Close(fd)
+		return nil, errno
+	}
+
+	memfd := &Memfd{
+		Name: name,
+	}
+
+	if err := memfd.init(memfdLinkPrefix+name, os.NewFile(uintptr(fd), memfdLinkPrefix+name), readOnly, size); err != nil {
+		memfd.Close()
+		return nil, err
+	}
+
+	return memfd, nil
+}
+
+var _ SharedMemory = &Memfd{}
+
+func init() {
+	RegisterScheme(`memfd`, func(address string) (Region, error) {
+		if fd, err := strconv.Atoi(address); err == nil && fd >= 0 {
+			if dup, err := syscall.Dup(fd); err == nil {
+				return MemfdFromFd(dup)
+			} else {
+				return nil, err
+			}
+		} else {
+			return nil, fmt.Errorf("Invalid file descriptor %q: %w", address, ErrInvalidArgument)
+		}
+	})
+}
+
+// Returns metadata about the memfd.
+//
+func (self *Memfd) Info() (*SegmentInfo, error) {
+	return self.info(`memfd:` + strconv.Itoa(int(self.Fd())))
+}
+
+// Adds the given seals to the memfd.  The memfd must have been created as sealable.
+//
+func (self *Memfd) Seal(seals Seals) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, self.Fd(), fcntlAddSeals, uintptr(seals)); errno != 0 {
+		return fmt.Errorf("Failed to seal %s: %w", self.name, errno)
+	}
+
+	return nil
+}
+
+// Returns the seals currently applied to the memfd.
+//
+func (self *Memfd) Seals() (Seals, error) {
+	if seals, _, errno := syscall.Syscall(syscall.SYS_FCNTL, self.Fd(), fcntlGetSeals, 0); errno == 0 {
+		return Seals(seals), nil
+	} else {
+		return 0, errno
+	}
+}
+
+// Maps the memfd and returns a Mapping for direct access to its memory.
+//
+func (self *Memfd) Map(opts AttachOptions) (*Mapping, error) {
+	if addr, err := self.AttachWith(opts); err == nil {
+		return newMapping(self, addr, self.Size, opts), nil
+	} else {
+		return nil, fmt.Errorf("Failed to attach %s: %w", self.name, err)
+	}
+}
+
+// Closes this process's descriptor for the memfd.  The memory is freed once every process that has
+// received the memfd has closed and detached it.
+//
+func (self *Memfd) Destroy() error {
+	return self.Close()
+}
+
+// Sends the memfd's file descriptor to the process at the other end of a Unix domain socket, along
+// with its name.  The receiving process should call ReceiveMemfd.  The memfd remains open in this
+// process.
+//
+func SendMemfd(conn *net.UnixConn, memfd *Memfd) error {
+	rights := syscall.UnixRights(int(memfd.Fd()))
+
+	if _, _, err := conn.WriteMsgUnix([]byte(memfd.Name+"\x00"), rights, nil); err != nil {
+		return fmt.Errorf("Failed to send memfd: %v", err)
+	}
+
+	return nil
+}
+
+// Receives a memfd sent by another process with SendMemfd.
+//
+func ReceiveMemfd(conn *net.UnixConn) (*Memfd, error) {
+	buf := make([]byte, 256)
+	oob := make([]byte, syscall.CmsgSpace(4))
+
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to receive memfd: %v", err)
+	}
+
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to receive memfd: %v", err)
+	}
+
+	for _, msg := range messages {
+		if fds, err := syscall.ParseUnixRights(&msg); err == nil && len(fds) > 0 {
+			// we only expect one descriptor; don't leak any others
+			for _, extra := range fds[1:] {
+				syscall.Close(extra)
+			}
+
+			if memfd, err := MemfdFromFd(fds[0]); err == nil {
+				if name := strings.TrimRight(string(buf[:n]), "\x00"); name != `` {
+					memfd.Name = name
+				}
+
+				return memfd, nil
+			} else {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("Failed to receive memfd: no file descriptor in message")
+}
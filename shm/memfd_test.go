@@ -0,0 +1,192 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package shm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestMemfdReadWrite(t *testing.T) {
+	memfd, err := CreateMemfd(`shmtool-test`, 1024, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer memfd.Destroy()
+
+	if memfd.Size != 1024 {
+		t.Fatalf("Wrong size; expected: 1024, got: %d", memfd.Size)
+	}
+
+	if _, err := memfd.Write([]byte(`hello memfd`)); err != nil {
+		t.Fatal(err)
+	}
+
+	memfd.Reset()
+
+	if output, err := ioutil.ReadAll(memfd); err != nil {
+		t.Fatal(err)
+	} else if len(output) != 1024 || !bytes.HasPrefix(output, []byte(`hello memfd`)) {
+		t.Errorf("Wrong data read back: %q", output[:16])
+	}
+
+	if err := memfd.Seal(SealShrink); err == nil {
+		t.Errorf("Expected sealing a non-sealable memfd to fail")
+	}
+
+	if _, err := CreateMemfd(`shmtool-test`, 0, false); !errors.Is(err, ErrInvalidSize) {
+		t.Errorf("Expected creating an empty memfd to fail with ErrInvalidSize; got: %v", err)
+	}
+
+	if _, err := OpenURI(`memfd:x`); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("Expected an invalid memfd URI to fail with ErrInvalidArgument; got: %v", err)
+	}
+}
+
+func TestMemfdSeals(t *testing.T) {
+	memfd, err := CreateMemfd(`shmtool-sealed`, 4096, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer memfd.Destroy()
+
+	if err := memfd.Seal(SealShrink | SealGrow); err != nil {
+		t.Fatal(err)
+	}
+
+	if seals, err := memfd.Seals(); err != nil {
+		t.Fatal(err)
+	} else if seals != SealShrink|SealGrow {
+		t.Errorf("Wrong seals; expected: %x, got: %x", SealShrink|SealGrow, seals)
+	}
+
+	if err := memfd.Resize(8192); err == nil {
+		t.Errorf("Expected growing a grow-sealed memfd to fail")
+	}
+
+	mapping, err := memfd.Map(AttachOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	copy(mapping.Bytes(), []byte(`sealed`))
+
+	// a write seal cannot be added while there is a writable mapping
+	if err := memfd.Seal(SealWrite); err == nil {
+		t.Errorf("Expected write-sealing a memfd with a writable mapping to fail")
+	}
+
+	mapping.Close()
+
+	if err := memfd.Seal(SealWrite); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := memfd.Attach(); err == nil {
+		t.Errorf("Expected attaching a write-sealed memfd read-write to fail")
+	}
+
+	if _, err := memfd.Write([]byte(`x`)); err == nil {
+		t.Errorf("Expected writing to a write-sealed memfd to fail")
+	}
+
+	if chunk, err := memfd.ReadChunk(6, 0); err != nil {
+		t.Fatal(err)
+	} else if string(chunk) != `sealed` {
+		t.Errorf("Wrong data in sealed memfd: %q", chunk)
+	}
+}
+
+func TestMemfdSendReceive(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_DGRAM, 0)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conns := make([]*net.UnixConn, 2)
+
+	for i, fd := range fds {
+		file := osFile(fd)
+		defer file.Close()
+
+		// FileConn duplicates the descriptor, leaving fds usable below
+		if conn, err := net.FileConn(file); err == nil {
+			defer conn.Close()
+			conns[i] = conn.(*net.UnixConn)
+		} else {
+			t.Fatal(err)
+		}
+	}
+
+	memfd, err := CreateMemfd(`shmtool-sent`, 64, true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer memfd.Destroy()
+
+	memfd.Write([]byte(`passed along`))
+
+	if err := SendMemfd(conns[0], memfd); err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := ReceiveMemfd(conns[1])
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer received.Destroy()
+
+	if received.Name != `shmtool-sent` {
+		t.Errorf("Wrong name; expected: shmtool-sent, got: %q", received.Name)
+	} else if received.Size != 64 {
+		t.Errorf("Wrong size; expected: 64, got: %d", received.Size)
+	}
+
+	mapping, err := received.Map(AttachOptions{ReadOnly: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mapping.Close()
+
+	if !bytes.HasPrefix(mapping.Bytes(), []byte(`passed along`)) {
+		t.Errorf("Wrong data in received memfd: %q", mapping.Bytes()[:12])
+	}
+
+	// writes by the sender are visible to the receiver
+	memfd.Seek(0, 0)
+	memfd.Write([]byte(`PASSED`))
+
+	if !bytes.HasPrefix(mapping.Bytes(), []byte(`PASSED along`)) {
+		t.Errorf("Sender's write not visible to receiver: %q", mapping.Bytes()[:12])
+	}
+
+	if dup, err := syscall.Dup(fds[0]); err != nil {
+		t.Fatal(err)
+	} else if _, err := MemfdFromFd(dup); err == nil {
+		t.Errorf("Expected wrapping a socket as a memfd to fail")
+	}
+}
+
+func osFile(fd int) *os.File {
+	return os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd))
+}
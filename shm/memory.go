@@ -0,0 +1,41 @@
+package shm
+
+import (
+	"io"
+	"unsafe"
+)
+
+// The operations common to every kind of shared memory supported by this package: SysV segments
+// (Segment), POSIX shared memory objects (PosixSegment), and memfds (Memfd).  Code written against
+// this interface can work with any of them.  Unlike Region, it includes the stateful Read/Write/Seek
+// interface and direct control over attachments.
+type SharedMemory interface {
+	Region
+	io.ReadWriteSeeker
+	io.ReaderFrom
+	io.WriterTo
+
+	// Returns an independent, read-only view of part of the shared memory.
+	Section(off int64, n int64) *Section
+
+	// Read some or all of the shared memory and return a byte slice.
+	ReadChunk(length int64, start int64) ([]byte, error)
+
+	// Returns the current position of the Read/Write pointer.
+	Position() int64
+
+	// Resets the Read/Write pointer to the beginning.
+	Reset()
+
+	// Attaches the memory to the current process, returning its address.
+	Attach() (unsafe.Pointer, error)
+
+	// Attaches the memory to the current process using the given options.
+	AttachWith(opts AttachOptions) (unsafe.Pointer, error)
+
+	// Detaches memory previously attached with Attach() or AttachWith().
+	Detach(addr unsafe.Pointer) error
+}
+
+var _ SharedMemory = &Segment{}
+var _ SharedMemory = &PosixSegment{}
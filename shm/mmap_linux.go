@@ -0,0 +1,10 @@
+//go:build linux && !386 && !arm && !mips && !mipsle
+// +build linux,!386,!arm,!mips,!mipsle
+
+package shm
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+const sysMmap = unix.SYS_MMAP
@@ -0,0 +1,12 @@
+//go:build linux && (386 || arm || mips || mipsle)
+// +build linux
+// +build 386 arm mips mipsle
+
+package shm
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// 32-bit Linux only provides mmap(2) as mmap2, which takes its offset in pages rather than bytes
+const sysMmap = unix.SYS_MMAP2
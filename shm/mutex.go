@@ -0,0 +1,216 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// The number of bytes of shared memory occupied by a Mutex.
+const MutexSize = 4
+
+const (
+	// set in the lock word while other processes may be waiting for the mutex
+	mutexWaiters = 0x80000000
+
+	// the bits of the lock word holding the owner's PID
+	mutexOwnerMask = 0x3fffffff
+)
+
+// How often processes waiting for a Mutex check whether its owner has died, and whether their context
+// has been canceled.
+var MutexPollInterval = 100 * time.Millisecond
+
+var (
+	// Returned by Lock(), TryLock() and LockContext() when the mutex was recovered from a process that
+	// died while holding it.  The mutex is held by the caller when this is returned, but the data it
+	// protects may have been left in an inconsistent state.
+	ErrOwnerDead = errors.New(`previous owner of the mutex died while holding it`)
+
+	// Returned by Unlock() when the mutex is not held by this process.
+	ErrNotOwner = errors.New(`mutex is not held by this process`)
+)
+
+// A mutual exclusion lock stored in shared memory, which lets cooperating processes coordinate access
+// to a segment (or any other kind of shared memory).  The lock occupies MutexSize bytes at a fixed
+// offset of the memory, which must be zeroed before first use; every process that maps the memory
+// and creates a Mutex at the same offset shares the same lock.
+//
+// The lock is implemented with Linux futexes: uncontended locking and unlocking never enter the
+// kernel, and waiting processes sleep rather than spin.  The lock word records the PID of the owning
+// process, so that if it exits (or is killed) while holding the lock, the next process to try to
+// acquire it can take it over; that process receives ErrOwnerDead.  This relies on every process
+// sharing a PID namespace, and a dead owner may go unnoticed if its PID has already been reused.
+//
+// A Mutex is held by a process, not a goroutine.  Goroutines within one process may contend for it
+// as they would for a sync.Mutex, but locking it again from the process that holds it deadlocks.
+type Mutex struct {
+	mapping *Mapping
+	offset  int64
+	word    *uint32
+	pid     uint32
+}
+
+// Returns a Mutex stored at the given offset of a mapping, which must be attached read-write.  The
+// offset must be aligned to a 4 byte boundary.  The mapping must remain open for as long as the Mutex
+// is used.
+//
+func NewMutex(mapping *Mapping, offset int64) (*Mutex, error) {
+	if mapping.ReadOnly() {
+		return nil, fmt.Errorf("Cannot store a mutex in a read-only mapping")
+	}
+
+	word, err := wordAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pid := os.Getpid()
+
+	if pid > mutexOwnerMask {
+		return nil, fmt.Errorf("PID %d is too large to be stored in a mutex", pid)
+	}
+
+	return &Mutex{
+		mapping: mapping,
+		offset:  offset,
+		word:    word,
+		pid:     uint32(pid),
+	}, nil
+}
+
+// Returns the offset of the mutex in its mapping.
+func (self *Mutex) Offset() int64 {
+	return self.offset
+}
+
+// Returns the PID of the process holding the mutex, or zero if it is not held.
+func (self *Mutex) Owner() int {
+	return int(atomic.LoadUint32(self.word) & mutexOwnerMask)
+}
+
+// Acquires the mutex, blocking until it is available.
+//
+func (self *Mutex) Lock() error {
+	return self.LockContext(context.Background())
+}
+
+// Acquires the mutex if it is not held by a live process, without blocking.  Returns whether the
+// mutex was acquired.
+//
+func (self *Mutex) TryLock() (bool, error) {
+	if atomic.CompareAndSwapUint32(self.word, 0, self.pid) {
+		return true, nil
+	}
+
+	if current := atomic.LoadUint32(self.word); current != 0 && self.ownerDead(current) {
+		if atomic.CompareAndSwapUint32(self.word, current, self.pid|(current&mutexWaiters)) {
+			return true, ErrOwnerDead
+		}
+	}
+
+	return false, nil
+}
+
+// Acquires the mutex, blocking until it is available or the context is done, in which case the
+// context's error is returned.  Use context.WithTimeout to limit how long to wait.  Cancellation is
+// noticed within MutexPollInterval.
+//
+func (self *Mutex) LockContext(ctx context.Context) error {
+	if atomic.CompareAndSwapUint32(self.word, 0, self.pid) {
+		return nil
+	}
+
+	for {
+		current := atomic.LoadUint32(self.word)
+
+		// once we've had to wait, we can't know whether anyone else is still waiting, so the lock is
+		// taken with the waiters bit set to make sure the next Unlock() wakes them
+		if current == 0 {
+			if atomic.CompareAndSwapUint32(self.word, 0, self.pid|mutexWaiters) {
+				return nil
+			}
+
+			continue
+		}
+
+		if self.ownerDead(current) {
+			if atomic.CompareAndSwapUint32(self.word, current, self.pid|mutexWaiters) {
+				return ErrOwnerDead
+			}
+
+			continue
+		}
+
+		if current&mutexWaiters == 0 {
+			if !atomic.CompareAndSwapUint32(self.word, current, current|mutexWaiters) {
+				continue
+			}
+
+			current |= mutexWaiters
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		wait := MutexPollInterval
+
+		if deadline, ok := ctx.Deadline(); ok {
+			if remaining := time.Until(deadline); remaining <= 0 {
+				return context.DeadlineExceeded
+			} else if remaining < wait {
+				wait = remaining
+			}
+		}
+
+		switch err := futexWait(self.word, current, wait); err {
+		case nil, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT:
+		default:
+			return fmt.Errorf("Failed to wait for mutex: %v", err)
+		}
+	}
+}
+
+// Releases the mutex, waking a waiting process if there is one.  Returns ErrNotOwner if the mutex is
+// not held by this process.
+//
+func (self *Mutex) Unlock() error {
+	for {
+		current := atomic.LoadUint32(self.word)
+
+		if current&mutexOwnerMask != self.pid {
+			return ErrNotOwner
+		}
+
+		if atomic.CompareAndSwapUint32(self.word, current, 0) {
+			if current&mutexWaiters != 0 {
+				if _, err := futexWake(self.word, 1); err != nil {
+					return fmt.Errorf("Failed to wake mutex waiters: %v", err)
+				}
+			}
+
+			return nil
+		}
+	}
+}
+
+// whether the owner recorded in the given lock word is a process that no longer exists
+func (self *Mutex) ownerDead(word uint32) bool {
+	owner := int(word & mutexOwnerMask)
+
+	if owner == 0 || owner == int(self.pid) {
+		return false
+	}
+
+	// signal 0 only checks whether the process exists; EPERM means it does, but isn't ours
+	return syscall.Kill(owner, 0) == syscall.ESRCH
+}
@@ -0,0 +1,251 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// maps the segment and creates a Mutex at offset 0 of the new mapping
+func mapMutex(segment *Segment) (*Mutex, *Mapping, error) {
+	mapping, err := segment.Map(AttachOptions{})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mutex, err := NewMutex(mapping, 0); err == nil {
+		return mutex, mapping, nil
+	} else {
+		mapping.Close()
+		return nil, nil, err
+	}
+}
+
+func TestMutexLockUnlock(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		mutex, mapping, err := mapMutex(segment)
+
+		if err != nil {
+			return err
+		}
+
+		defer mapping.Close()
+
+		if err := mutex.Lock(); err != nil {
+			return err
+		} else if mutex.Owner() != os.Getpid() {
+			return fmt.Errorf("Wrong owner; expected: %d, got: %d", os.Getpid(), mutex.Owner())
+		}
+
+		if ok, err := mutex.TryLock(); ok || err != nil {
+			return fmt.Errorf("Expected TryLock of a held mutex to fail; got ok=%v err=%v", ok, err)
+		}
+
+		if err := mutex.Unlock(); err != nil {
+			return err
+		} else if mutex.Owner() != 0 {
+			return fmt.Errorf("Mutex should not have an owner after Unlock")
+		}
+
+		if err := mutex.Unlock(); err != ErrNotOwner {
+			return fmt.Errorf("Expected ErrNotOwner unlocking an unheld mutex; got: %v", err)
+		}
+
+		if ok, err := mutex.TryLock(); !ok || err != nil {
+			return fmt.Errorf("Expected TryLock of a free mutex to succeed; got ok=%v err=%v", ok, err)
+		}
+
+		return mutex.Unlock()
+	})
+}
+
+func TestMutexContention(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		var wg sync.WaitGroup
+		var failed int32
+
+		workers := 8
+		iterations := 500
+
+		for i := 0; i < workers; i++ {
+			// each worker has its own attachment, as a separate process would
+			mutex, mapping, err := mapMutex(segment)
+
+			if err != nil {
+				return err
+			}
+
+			defer mapping.Close()
+
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				counter := mapping.Bytes()[8:12]
+
+				for j := 0; j < iterations; j++ {
+					if err := mutex.Lock(); err != nil {
+						atomic.AddInt32(&failed, 1)
+						return
+					}
+
+					// a deliberately non-atomic read-modify-write
+					value := binary.LittleEndian.Uint32(counter)
+					time.Sleep(time.Microsecond)
+					binary.LittleEndian.PutUint32(counter, value+1)
+
+					mutex.Unlock()
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		if failed > 0 {
+			return fmt.Errorf("%d workers failed to lock the mutex", failed)
+		}
+
+		if chunk, err := segment.ReadChunk(4, 8); err != nil {
+			return err
+		} else if total := int(binary.LittleEndian.Uint32(chunk)); total != workers*iterations {
+			return fmt.Errorf("Lost updates; expected: %d, got: %d", workers*iterations, total)
+		}
+
+		return nil
+	})
+}
+
+func TestMutexLockContext(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		mutex, mapping, err := mapMutex(segment)
+
+		if err != nil {
+			return err
+		}
+
+		defer mapping.Close()
+
+		if err := mutex.Lock(); err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		if err := mutex.LockContext(ctx); err != context.DeadlineExceeded {
+			return fmt.Errorf("Expected a timeout locking a held mutex; got: %v", err)
+		}
+
+		acquired := make(chan error)
+
+		go func() {
+			acquired <- mutex.LockContext(context.Background())
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+
+		if err := mutex.Unlock(); err != nil {
+			return err
+		}
+
+		select {
+		case err := <-acquired:
+			if err != nil {
+				return err
+			}
+		case <-time.After(time.Second):
+			return fmt.Errorf("Waiter was not woken when the mutex was unlocked")
+		}
+
+		return mutex.Unlock()
+	})
+}
+
+func TestMutexOwnerDead(t *testing.T) {
+	// run a process to completion, so that we have the PID of one that is known to have exited
+	cmd := exec.Command(`true`)
+
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Cannot run a child process: %v", err)
+	}
+
+	dead := uint32(cmd.Process.Pid)
+
+	makeSegment(t, 1024, func(segment *Segment) error {
+		mutex, mapping, err := mapMutex(segment)
+
+		if err != nil {
+			return err
+		}
+
+		defer mapping.Close()
+
+		// simulate the child having died while holding the lock
+		atomic.StoreUint32(mutex.word, dead)
+
+		if err := mutex.Lock(); err != ErrOwnerDead {
+			return fmt.Errorf("Expected ErrOwnerDead; got: %v", err)
+		} else if mutex.Owner() != os.Getpid() {
+			return fmt.Errorf("Mutex was not recovered; owner is %d", mutex.Owner())
+		}
+
+		if err := mutex.Unlock(); err != nil {
+			return err
+		}
+
+		atomic.StoreUint32(mutex.word, dead|mutexWaiters)
+
+		if ok, err := mutex.TryLock(); !ok || err != ErrOwnerDead {
+			return fmt.Errorf("Expected TryLock to recover the mutex; got ok=%v err=%v", ok, err)
+		}
+
+		return mutex.Unlock()
+	})
+}
+
+func TestNewMutexInvalid(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		mapping, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer mapping.Close()
+
+		if _, err := NewMutex(mapping, 2); err == nil {
+			return fmt.Errorf("Expected a misaligned mutex to fail")
+		}
+
+		if _, err := NewMutex(mapping, int64(mapping.Len())); err == nil {
+			return fmt.Errorf("Expected a mutex beyond the end of the mapping to fail")
+		}
+
+		readOnly, err := segment.Map(AttachOptions{
+			ReadOnly: true,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		defer readOnly.Close()
+
+		if _, err := NewMutex(readOnly, 0); err == nil {
+			return fmt.Errorf("Expected a mutex in a read-only mapping to fail")
+		}
+
+		return nil
+	})
+}
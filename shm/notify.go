@@ -0,0 +1,185 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Returns the current value of the generation counter in the segment's header, which Notify
+// increments.  The segment must have a segment header (see WriteHeader).
+//
+func (self *Segment) Generation() (uint32, error) {
+	var generation uint32
+
+	err := self.withMapping(true, func(mapping *Mapping) error {
+		var err error
+		generation, err = mapping.Generation()
+		return err
+	})
+
+	return generation, err
+}
+
+// Increments the generation counter in the segment's header and wakes every process waiting for it to
+// change, to let them know that the segment has been updated.  The segment must have a segment header
+// (see WriteHeader).  This attaches the segment for the duration of the call; a producer that
+// notifies repeatedly should Map the segment once and use Mapping.Notify() instead.
+//
+func (self *Segment) Notify() error {
+	return self.withMapping(false, func(mapping *Mapping) error {
+		return mapping.Notify()
+	})
+}
+
+// Waits until the generation counter in the segment's header changes (i.e. until another process
+// calls Notify) or the context is done, and returns the new generation.  Notifications sent before
+// Wait is called are not seen; to wait without missing any, pass the last generation seen to
+// WaitSince instead.
+//
+func (self *Segment) Wait(ctx context.Context) (uint32, error) {
+	var generation uint32
+
+	err := self.withMapping(true, func(mapping *Mapping) error {
+		var err error
+		generation, err = mapping.Wait(ctx)
+		return err
+	})
+
+	return generation, err
+}
+
+// Waits until the generation counter in the segment's header differs from the given generation or
+// the context is done, and returns the new generation.  Returns immediately if it already differs.
+// This attaches the segment for the duration of the call; a consumer that waits repeatedly should Map
+// the segment once and use Mapping.WaitSince() instead.
+//
+func (self *Segment) WaitSince(ctx context.Context, since uint32) (uint32, error) {
+	var generation uint32
+
+	err := self.withMapping(true, func(mapping *Mapping) error {
+		var err error
+		generation, err = mapping.WaitSince(ctx, since)
+		return err
+	})
+
+	return generation, err
+}
+
+// attach the segment for the duration of fn
+func (self *Segment) withMapping(readOnly bool, fn func(mapping *Mapping) error) error {
+	mapping, err := self.Map(AttachOptions{ReadOnly: readOnly})
+
+	if err != nil {
+		return err
+	}
+
+	defer mapping.Close()
+
+	return fn(mapping)
+}
+
+// Returns the current value of the generation counter in the header at the start of the mapped
+// memory, which Notify increments.
+//
+func (self *Mapping) Generation() (uint32, error) {
+	if word, err := self.generation(); err == nil {
+		return atomic.LoadUint32(word), nil
+	} else {
+		return 0, err
+	}
+}
+
+// Increments the generation counter in the header at the start of the mapped memory and wakes every
+// process waiting for it to change.  The mapping must not be read-only.
+//
+func (self *Mapping) Notify() error {
+	word, err := self.generation()
+
+	if err != nil {
+		return err
+	} else if self.ReadOnly() {
+		return fmt.Errorf("Cannot notify through a read-only mapping")
+	}
+
+	atomic.AddUint32(word, 1)
+
+	if _, err := futexWake(word, math.MaxInt32); err != nil {
+		return fmt.Errorf("Failed to wake waiters: %v", err)
+	}
+
+	return nil
+}
+
+// Waits until the generation counter in the header at the start of the mapped memory changes or the
+// context is done, and returns the new generation.  As with Segment.Wait(), notifications sent before
+// Wait is called are not seen.
+//
+func (self *Mapping) Wait(ctx context.Context) (uint32, error) {
+	if word, err := self.generation(); err == nil {
+		return waitGeneration(ctx, word, atomic.LoadUint32(word))
+	} else {
+		return 0, err
+	}
+}
+
+// Waits until the generation counter in the header at the start of the mapped memory differs from
+// the given generation or the context is done, and returns the new generation.
+//
+func (self *Mapping) WaitSince(ctx context.Context, since uint32) (uint32, error) {
+	if word, err := self.generation(); err == nil {
+		return waitGeneration(ctx, word, since)
+	} else {
+		return since, err
+	}
+}
+
+// return the generation counter of the header at the start of the mapped memory
+func (self *Mapping) generation() (*uint32, error) {
+	data := self.Bytes()
+	le := binary.LittleEndian
+
+	if data == nil {
+		return nil, fmt.Errorf("Cannot use a closed mapping")
+	} else if len(data) < HeaderFixedSize || le.Uint32(data[0:]) != HeaderMagic {
+		return nil, fmt.Errorf("Shared memory has nothing to notify through: %w", ErrNoHeader)
+	} else if version := le.Uint16(data[4:]); version != HeaderVersion {
+		return nil, fmt.Errorf("Unsupported segment header version %d", version)
+	}
+
+	return wordAt(self, HeaderGenerationOffset)
+}
+
+func waitGeneration(ctx context.Context, word *uint32, since uint32) (uint32, error) {
+	for {
+		if generation := atomic.LoadUint32(word); generation != since {
+			return generation, nil
+		} else if err := ctx.Err(); err != nil {
+			return generation, err
+		}
+
+		// wake up periodically to notice the context being cancelled
+		wait := MutexPollInterval
+
+		if deadline, ok := ctx.Deadline(); ok {
+			if remaining := time.Until(deadline); remaining <= 0 {
+				return since, context.DeadlineExceeded
+			} else if remaining < wait {
+				wait = remaining
+			}
+		}
+
+		switch err := futexWait(word, since, wait); err {
+		case nil, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT:
+		default:
+			return since, fmt.Errorf("Failed to wait for a notification: %v", err)
+		}
+	}
+}
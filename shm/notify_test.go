@@ -0,0 +1,149 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSegmentNotifyWait(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if err := segment.Notify(); !errors.Is(err, ErrNoHeader) {
+			return fmt.Errorf("Expected ErrNoHeader notifying without a header; got: %v", err)
+		}
+
+		if err := WriteHeader(segment, &Header{}); err != nil {
+			return err
+		}
+
+		start, err := segment.Generation()
+
+		if err != nil {
+			return err
+		}
+
+		woken := make(chan error, 1)
+
+		go func() {
+			if generation, err := segment.Wait(context.Background()); err != nil {
+				woken <- err
+			} else if generation != start+1 {
+				woken <- fmt.Errorf("Wrong generation; expected: %d, got: %d", start+1, generation)
+			} else {
+				woken <- nil
+			}
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+
+		if err := segment.Notify(); err != nil {
+			return err
+		}
+
+		select {
+		case err := <-woken:
+			if err != nil {
+				return err
+			}
+		case <-time.After(time.Second):
+			return fmt.Errorf("Waiter was not woken by Notify")
+		}
+
+		// a notification sent before waiting is seen by WaitSince but not by Wait
+		if err := segment.Notify(); err != nil {
+			return err
+		}
+
+		if generation, err := segment.WaitSince(context.Background(), start+1); err != nil {
+			return err
+		} else if generation != start+2 {
+			return fmt.Errorf("Wrong generation; expected: %d, got: %d", start+2, generation)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+
+		if _, err := segment.Wait(ctx); err != context.DeadlineExceeded {
+			return fmt.Errorf("Expected a timeout waiting without a notification; got: %v", err)
+		}
+
+		// rewriting the header leaves the generation alone
+		if err := WriteHeader(segment, &Header{ContentType: `text/plain`}); err != nil {
+			return err
+		} else if header, err := ReadHeader(segment); err != nil {
+			return err
+		} else if header.Generation != start+2 {
+			return fmt.Errorf("Rewriting the header changed the generation to %d", header.Generation)
+		}
+
+		return nil
+	})
+}
+
+func TestMappingNotifyWait(t *testing.T) {
+	makeHeaderSegment(t, func(segment *Segment, producer *Mapping, header *Header) error {
+		consumer, err := segment.Map(AttachOptions{ReadOnly: true})
+
+		if err != nil {
+			return err
+		}
+
+		defer consumer.Close()
+
+		if err := consumer.Notify(); err == nil {
+			return fmt.Errorf("Expected notifying through a read-only mapping to fail")
+		}
+
+		generation, err := consumer.Generation()
+
+		if err != nil {
+			return err
+		}
+
+		// the same attachments are used for every notification
+		for i := 0; i < 3; i++ {
+			woken := make(chan error, 1)
+
+			go func(since uint32) {
+				if next, err := consumer.WaitSince(context.Background(), since); err != nil {
+					woken <- err
+				} else if next != since+1 {
+					woken <- fmt.Errorf("Wrong generation; expected: %d, got: %d", since+1, next)
+				} else {
+					woken <- nil
+				}
+			}(generation)
+
+			time.Sleep(10 * time.Millisecond)
+
+			if err := producer.Notify(); err != nil {
+				return err
+			}
+
+			select {
+			case err := <-woken:
+				if err != nil {
+					return err
+				}
+			case <-time.After(time.Second):
+				return fmt.Errorf("Waiter was not woken by notification %d", i)
+			}
+
+			generation++
+		}
+
+		// the segment's own methods see the notifications made through the mapping
+		if current, err := segment.Generation(); err != nil {
+			return err
+		} else if current != generation {
+			return fmt.Errorf("Wrong generation through the segment; expected: %d, got: %d", generation, current)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package shm
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// The directory where the system keeps POSIX shared memory objects.  On Linux, shm_open(3) is
+// implemented by opening files in this (tmpfs) directory, so objects created here are visible to
+// any other program using POSIX shared memory, including Python's multiprocessing.shared_memory.
+var PosixShmDir = `/dev/shm`
+
+// A POSIX shared memory object, identified by a name rather than a numeric ID.  It supports the
+// same Read/Write/Seek/Attach operations as Segment, and can additionally be resized.
+type PosixSegment struct {
+	fileMemory
+	Name string
+}
+
+// Create a new POSIX shared memory object with the given name and size (in bytes).  This will fail
+// if an object with this name already exists.
+//
+func CreatePosix(name string, size int64) (*PosixSegment, error) {
+	return OpenPosixSegment(name, size, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+}
+
+// Open an existing POSIX shared memory object by name.  If the object cannot be opened for writing,
+// it is opened read-only.
+//
+func OpenPosix(name string) (*PosixSegment, error) {
+	if segment, err := OpenPosixSegment(name, 0, os.O_RDWR, 0); err == nil {
+		return segment, nil
+	} else if os.IsPermission(err) {
+		return OpenPosixSegment(name, 0, os.O_RDONLY, 0)
+	} else {
+		return nil, err
+	}
+}
+
+// Opens a POSIX shared memory object with the given os.OpenFile flags and permissions, in the
+// manner of shm_open(3).  Flags must include one of os.O_RDONLY or os.O_RDWR.  If size is greater
+// than zero, the object is resized to that size after opening.
+//
+func OpenPosixSegment(name string, size int64, flags int, perms os.FileMode) (*PosixSegment, error) {
+	path, err := posixPath(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	readOnly := (flags&(os.O_WRONLY|os.O_RDWR) == 0)
+
+	if perms == 0 {
+		perms = 0600
+	}
+
+	file, err := os.OpenFile(path, flags|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, perms)
+
+	if err != nil {
+		return nil, err
+	}
+
+	segment := &PosixSegment{
+		Name: `/` + filepath.Base(path),
+	}
+
+	if err := segment.init(segment.Name, file, readOnly, size); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return segment, nil
+}
+
+// Removes the POSIX shared memory object with the given name, in the manner of shm_unlink(3).
+// Processes that have the object open or attached can continue to use it.
+//
+func UnlinkPosix(name string) error {
+	if path, err := posixPath(name); err == nil {
+		return os.Remove(path)
+	} else {
+		return err
+	}
+}
+
+// convert a shm_open(3)-style name into a path in PosixShmDir
+func posixPath(name string) (string, error) {
+	name = strings.TrimPrefix(name, `/`)
+
+	if name == `` || name == `.` || name == `..` || strings.Contains(name, `/`) {
+		return ``, fmt.Errorf("Invalid POSIX shared memory name %q: %w", name, ErrInvalidArgument)
+	}
+
+	return filepath.Join(PosixShmDir, name), nil
+}
+
+// Maps the object and returns a Mapping for direct access to its memory.
+//
+func (self *PosixSegment) Map(opts AttachOptions) (*Mapping, error) {
+	if addr, err := self.AttachWith(opts); err == nil {
+		return newMapping(self, addr, self.Size, opts), nil
+	} else {
+		return nil, fmt.Errorf("Failed to attach %s: %w", self.Name, err)
+	}
+}
+
+// Returns metadata about the object.
+//
+func (self *PosixSegment) Info() (*SegmentInfo, error) {
+	return self.info(`posix:` + self.Name)
+}
+
+// Removes this object's name from the system and closes it.  The memory is freed once every
+// process has closed and detached it.  The object is closed even if removing its name fails, in which
+// case that error is returned (along with any error from closing it).
+//
+func (self *PosixSegment) Destroy() error {
+	unlinkErr := UnlinkPosix(self.Name)
+	closeErr := self.Close()
+
+	if unlinkErr != nil && closeErr != nil {
+		return fmt.Errorf("%w (and failed to close: %v)", unlinkErr, closeErr)
+	} else if unlinkErr != nil {
+		return unlinkErr
+	}
+
+	return closeErr
+}
@@ -0,0 +1,279 @@
+package shm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func makePosixSegment(t *testing.T, size int64, callback func(segment *PosixSegment) error) {
+	name := fmt.Sprintf("/shmtool-test-%d", os.Getpid())
+	segment, err := CreatePosix(name, size)
+
+	if err != nil {
+		t.Fatalf("Failed to create POSIX object %s: %v", name, err)
+	}
+
+	defer segment.Destroy()
+
+	if err := callback(segment); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPosixWriteRead(t *testing.T) {
+	makePosixSegment(t, 1024, func(segment *PosixSegment) error {
+		input := make([]byte, 1024)
+
+		for i := 0; i < len(input); i++ {
+			input[i] = byte(i % 256)
+		}
+
+		if n, err := segment.Write(input); err != nil || n != len(input) {
+			return fmt.Errorf("Failed to write object data: n=%d err=%v", n, err)
+		}
+
+		if _, err := segment.Write([]byte{1}); err == nil {
+			return fmt.Errorf("Expected write past the end of the object to fail")
+		}
+
+		segment.Seek(512, 0)
+
+		if output, err := ioutil.ReadAll(segment); err != nil {
+			return err
+		} else if !bytes.Equal(output, input[512:]) {
+			return fmt.Errorf("Wrong data read back from offset 512")
+		}
+
+		// a separate handle should see the same data
+		other, err := OpenPosix(segment.Name)
+
+		if err != nil {
+			return err
+		}
+
+		defer other.Close()
+
+		if other.Size != segment.Size {
+			return fmt.Errorf("Wrong size when reopened; expected: %d, got: %d", segment.Size, other.Size)
+		} else if chunk, err := other.ReadChunk(4, 255); err != nil {
+			return err
+		} else if !bytes.Equal(chunk, input[255:259]) {
+			return fmt.Errorf("Wrong chunk read from reopened object: %v", chunk)
+		}
+
+		return nil
+	})
+}
+
+func TestPosixMapResize(t *testing.T) {
+	makePosixSegment(t, 4096, func(segment *PosixSegment) error {
+		mapping, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		copy(mapping.Bytes(), []byte(`hello`))
+
+		if err := mapping.Close(); err != nil {
+			return err
+		}
+
+		if err := segment.Resize(8192); err != nil {
+			return err
+		}
+
+		ro, err := segment.Map(AttachOptions{
+			ReadOnly: true,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		defer ro.Close()
+
+		if ro.Len() != 8192 {
+			return fmt.Errorf("Wrong mapping size after resize; expected: 8192, got: %d", ro.Len())
+		} else if !bytes.Equal(ro.Bytes()[:5], []byte(`hello`)) {
+			return fmt.Errorf("Data written through mapping was lost: %q", ro.Bytes()[:5])
+		} else if _, err := ro.WriteAt([]byte{1}, 0); err == nil {
+			return fmt.Errorf("Expected write to a read-only mapping to fail")
+		}
+
+		if err := segment.Detach(ro.Pointer()); err != nil {
+			return err
+		} else if err := segment.Detach(ro.Pointer()); err == nil {
+			return fmt.Errorf("Expected detaching an unknown address to fail")
+		}
+
+		return nil
+	})
+}
+
+func TestPosixResizeReadOnly(t *testing.T) {
+	makePosixSegment(t, 4096, func(segment *PosixSegment) error {
+		ro, err := OpenPosixSegment(segment.Name, 0, os.O_RDONLY, 0)
+
+		if err != nil {
+			return err
+		}
+
+		defer ro.Close()
+
+		if err := ro.Resize(0); !errors.Is(err, ErrPermission) {
+			return fmt.Errorf("Expected resizing a read-only object to fail with ErrPermission, got: %v", err)
+		} else if ro.Size != 4096 {
+			return fmt.Errorf("Wrong size after a failed resize; expected: 4096, got: %d", ro.Size)
+		}
+
+		// the object itself must be untouched, as seen through a separate handle
+		other, err := OpenPosix(segment.Name)
+
+		if err != nil {
+			return err
+		}
+
+		defer other.Close()
+
+		if other.Size != 4096 {
+			return fmt.Errorf("Read-only resize truncated the object to %d bytes", other.Size)
+		}
+
+		return nil
+	})
+}
+
+func TestPosixReadChunk(t *testing.T) {
+	makePosixSegment(t, 1024, func(segment *PosixSegment) error {
+		input := make([]byte, 1024)
+
+		for i := 0; i < len(input); i++ {
+			input[i] = byte(i % 251)
+		}
+
+		if _, err := segment.WriteAt(input, 0); err != nil {
+			return err
+		}
+
+		// a negative length reads from start to the end, not the full size from start
+		if chunk, err := segment.ReadChunk(-1, 1000); err != nil {
+			return err
+		} else if !bytes.Equal(chunk, input[1000:]) {
+			return fmt.Errorf("Wrong tail chunk; expected 24 bytes, got %d", len(chunk))
+		}
+
+		if chunk, err := segment.ReadChunk(-1, 1024); err != nil {
+			return err
+		} else if len(chunk) != 0 {
+			return fmt.Errorf("Expected an empty chunk at the end; got %d bytes", len(chunk))
+		}
+
+		if chunk, err := segment.ReadChunk(8, 1016); err != nil {
+			return err
+		} else if !bytes.Equal(chunk, input[1016:]) {
+			return fmt.Errorf("Wrong chunk ending at the end of the object: %v", chunk)
+		}
+
+		if _, err := segment.ReadAt(make([]byte, 4), -1); !errors.Is(err, ErrInvalidArgument) {
+			return fmt.Errorf("Expected ErrInvalidArgument reading from a negative offset; got: %v", err)
+		} else if _, err := segment.WriteAt(make([]byte, 4), -1); !errors.Is(err, ErrInvalidArgument) {
+			return fmt.Errorf("Expected ErrInvalidArgument writing to a negative offset; got: %v", err)
+		}
+
+		for _, r := range [][2]int64{{16, 1020}, {1, 1025}, {1, -1}, {-1, 2048}} {
+			if _, err := segment.ReadChunk(r[0], r[1]); !errors.Is(err, ErrInvalidSize) {
+				return fmt.Errorf("Expected ErrInvalidSize reading %d bytes at offset %d; got: %v", r[0], r[1], err)
+			}
+		}
+
+		return nil
+	})
+}
+
+func TestPosixDestroyUnlinked(t *testing.T) {
+	makePosixSegment(t, 16, func(segment *PosixSegment) error {
+		other, err := OpenPosix(segment.Name)
+
+		if err != nil {
+			return err
+		}
+
+		if err := UnlinkPosix(segment.Name); err != nil {
+			return err
+		}
+
+		// the name is already gone, but the object must still be closed
+		if err := other.Destroy(); !os.IsNotExist(err) {
+			return fmt.Errorf("Expected destroying an unlinked object to fail with ENOENT; got: %v", err)
+		} else if err := other.Close(); err == nil {
+			return fmt.Errorf("Expected the object to have been closed by a failed Destroy")
+		}
+
+		return nil
+	})
+}
+
+func TestPosixNames(t *testing.T) {
+	for _, name := range []string{``, `/`, `/a/b`, `..`} {
+		if _, err := OpenPosix(name); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("Expected invalid name %q to fail with ErrInvalidArgument; got: %v", name, err)
+		}
+	}
+
+	if _, err := OpenPosix(`/shmtool-test-does-not-exist`); !os.IsNotExist(err) {
+		t.Errorf("Expected nonexistent object to fail with ENOENT; got: %v", err)
+	}
+
+	makePosixSegment(t, 16, func(segment *PosixSegment) error {
+		if _, err := CreatePosix(segment.Name, 16); !os.IsExist(err) {
+			return fmt.Errorf("Expected exclusive creation of an existing object to fail; got: %v", err)
+		}
+
+		return nil
+	})
+}
+
+func TestPosixAttachWithAddress(t *testing.T) {
+	makePosixSegment(t, 1024, func(segment *PosixSegment) error {
+		addr, err := segment.Attach()
+
+		if err != nil {
+			return err
+		}
+
+		bytesAt(addr, 5)[0] = 'x'
+
+		if err := segment.Detach(addr); err != nil {
+			return err
+		}
+
+		// the previous address is now free, so we should be able to ask for it explicitly
+		again, err := segment.AttachWith(AttachOptions{Address: uintptr(addr)})
+
+		if err != nil {
+			return err
+		} else if again != addr {
+			return fmt.Errorf("Wrong attach address; expected: %p, got: %p", addr, again)
+		} else if bytesAt(again, 1)[0] != 'x' {
+			return fmt.Errorf("Wrong contents at the fixed address")
+		}
+
+		// asking for an address that is in use fails rather than replacing the mapping
+		if _, err := segment.AttachWith(AttachOptions{Address: uintptr(again)}); err == nil {
+			return fmt.Errorf("Expected attaching over an existing mapping to fail")
+		}
+
+		if err := segment.Detach(again); err != nil {
+			return err
+		} else if err := segment.Detach(again); err == nil {
+			return fmt.Errorf("Expected detaching twice to fail")
+		}
+
+		return nil
+	})
+}
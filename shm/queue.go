@@ -0,0 +1,453 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// Identifies shared memory that has been initialized as a Queue ("SHMQ").
+	QueueMagic = 0x514d4853
+
+	// The version of the Queue layout implemented by this package.  OpenQueue refuses to open queues
+	// with any other layout version.
+	QueueVersion = 1
+
+	// The number of bytes at the start of a Queue occupied by its header; the rest holds messages.
+	QueueHeaderSize = 128
+
+	// The number of bytes each message occupies in addition to its data: a 32-bit length followed by a
+	// 64-bit sequence number.
+	QueueRecordOverhead = 12
+)
+
+// Returned by OpenQueue when the memory does not contain a Queue.
+var ErrNotQueue = errors.New(`shared memory does not contain a message queue`)
+
+// The header at the start of a Queue.  Everything other than the magic number, version and capacity
+// is protected by the mutex in Lock, except for the futex words (which are only ever incremented)
+// and the waiter counts (which are only ever updated atomically).
+type queueHeader struct {
+	Magic          uint32
+	Version        uint32
+	Capacity       uint64
+	Lock           uint32
+	NotEmpty       uint32
+	NotFull        uint32
+	_              uint32
+	Head           uint64
+	Tail           uint64
+	NextSeq        uint64
+	Count          uint64
+	ReceiveWaiters uint32
+	SendWaiters    uint32
+	_              [56]byte
+}
+
+// A message received from a Queue.
+type Message struct {
+	// The sequence number assigned to the message when it was sent.  Sequence numbers start at 1
+	// and increase by one with every message sent to the Queue, so gaps seen by a single receiver
+	// indicate messages taken by other receivers.
+	Seq uint64 `json:"seq"`
+
+	// The contents of the message.
+	Data []byte `json:"data"`
+}
+
+// Statistics describing the state of a Queue.
+type QueueInfo struct {
+	Version   int    `json:"version"`
+	Capacity  int64  `json:"capacity"`
+	Used      int64  `json:"used"`
+	Count     int64  `json:"count"`
+	NextSeq   uint64 `json:"next_seq"`
+	Senders   int    `json:"waiting_senders"`
+	Receivers int    `json:"waiting_receivers"`
+}
+
+// A bounded, multi-producer, multi-consumer queue of variable-length messages stored in shared
+// memory.  Any number of processes may send and receive messages concurrently; each message is
+// received exactly once, in the order it was sent.  Access is serialized by a Mutex stored in the
+// Queue's header, and processes waiting for room or for messages sleep on futexes.  If a process dies
+// while holding the Mutex, a message it was part way through sending or receiving is discarded or
+// left in the Queue (respectively), and the next process to lock the Queue repairs its bookkeeping.
+//
+// Like Ring, a Queue is self-describing: its header records a magic number, layout version and
+// capacity, so processes only need to know where the Queue is to open it.
+type Queue struct {
+	mapping  *Mapping
+	header   *queueHeader
+	mutex    *Mutex
+	data     []byte
+	capacity uint64
+}
+
+// Initializes a new, empty Queue occupying the mapping from the given offset to its end, discarding
+// whatever was previously stored there.  The offset must be aligned to an 8 byte boundary.  The
+// mapping must remain open for as long as the Queue is used.  If the mapping begins with a segment
+// header whose payload starts at the offset, the header's layout is set to LayoutQueue.
+//
+func InitQueue(mapping *Mapping, offset int64) (*Queue, error) {
+	header, data, err := queueAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	*header = queueHeader{
+		Version:  QueueVersion,
+		Capacity: uint64(len(data)),
+		NextSeq:  1,
+	}
+
+	atomic.StoreUint32(&header.Magic, QueueMagic)
+
+	if queue, err := newQueue(mapping, offset, header, data); err == nil {
+		setHeaderLayout(mapping, offset, LayoutQueue)
+		return queue, nil
+	} else {
+		return nil, err
+	}
+}
+
+// Opens the Queue stored in the mapping at the given offset, which must have been initialized with
+// InitQueue.  Returns ErrNotQueue if no Queue is found there.
+//
+func OpenQueue(mapping *Mapping, offset int64) (*Queue, error) {
+	header, data, err := queueAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if atomic.LoadUint32(&header.Magic) != QueueMagic {
+		return nil, ErrNotQueue
+	} else if header.Version != QueueVersion {
+		return nil, fmt.Errorf("Unsupported message queue layout version %d (expected %d)", header.Version, QueueVersion)
+	} else if header.Capacity > uint64(len(data)) {
+		return nil, fmt.Errorf("Message queue capacity %d exceeds the %d bytes available", header.Capacity, len(data))
+	}
+
+	return newQueue(mapping, offset, header, data[:header.Capacity])
+}
+
+func queueAt(mapping *Mapping, offset int64) (*queueHeader, []byte, error) {
+	data := mapping.Bytes()
+
+	if data == nil {
+		return nil, nil, fmt.Errorf("Cannot use a closed mapping")
+	} else if mapping.ReadOnly() {
+		return nil, nil, fmt.Errorf("Cannot use a message queue in a read-only mapping")
+	} else if offset < 0 || offset%8 != 0 {
+		return nil, nil, fmt.Errorf("Offset %d is not aligned to an 8 byte boundary", offset)
+	} else if int64(len(data))-offset <= QueueHeaderSize+QueueRecordOverhead {
+		return nil, nil, fmt.Errorf("Not enough room for a message queue at offset %d of a %d byte mapping", offset, len(data))
+	}
+
+	return (*queueHeader)(unsafe.Pointer(&data[offset])), data[offset+QueueHeaderSize:], nil
+}
+
+func newQueue(mapping *Mapping, offset int64, header *queueHeader, data []byte) (*Queue, error) {
+	mutex, err := NewMutex(mapping, offset+int64(unsafe.Offsetof(header.Lock)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Queue{
+		mapping:  mapping,
+		header:   header,
+		mutex:    mutex,
+		data:     data,
+		capacity: uint64(len(data)),
+	}, nil
+}
+
+// Returns the number of bytes of messages (including their overhead) the Queue can hold.
+func (self *Queue) Cap() int {
+	return int(self.capacity)
+}
+
+// Returns the size of the largest message that can be sent to the Queue.
+func (self *Queue) MaxMessageSize() int {
+	max := self.capacity - QueueRecordOverhead
+
+	// message lengths are stored in 32 bits, and an int may be no wider than that
+	if max > math.MaxUint32 {
+		max = math.MaxUint32
+	}
+
+	if max > math.MaxInt {
+		max = math.MaxInt
+	}
+
+	return int(max)
+}
+
+// Returns the number of messages waiting in the Queue.
+func (self *Queue) Len() int {
+	return int(atomic.LoadUint64(&self.header.Count))
+}
+
+// Returns statistics describing the current state of the Queue.
+//
+func (self *Queue) Stat() (*QueueInfo, error) {
+	if err := self.lock(context.Background()); err != nil {
+		return nil, err
+	}
+
+	defer self.mutex.Unlock()
+
+	return &QueueInfo{
+		Version:   int(self.header.Version),
+		Capacity:  int64(self.capacity),
+		Used:      int64(self.header.Head - self.header.Tail),
+		Count:     int64(self.header.Count),
+		NextSeq:   self.header.NextSeq,
+		Senders:   int(atomic.LoadUint32(&self.header.SendWaiters)),
+		Receivers: int(atomic.LoadUint32(&self.header.ReceiveWaiters)),
+	}, nil
+}
+
+// Sends a message, waiting until there is room for it in the Queue.  Returns the sequence number
+// assigned to the message.
+//
+func (self *Queue) Send(data []byte) (uint64, error) {
+	return self.SendContext(context.Background(), data)
+}
+
+// Sends a message if there is room for it in the Queue, or returns ErrWouldBlock if there is not.
+//
+func (self *Queue) TrySend(data []byte) (uint64, error) {
+	return self.send(nil, data)
+}
+
+// Sends a message, waiting until there is room for it in the Queue or the context is done.
+//
+func (self *Queue) SendContext(ctx context.Context, data []byte) (uint64, error) {
+	return self.send(ctx, data)
+}
+
+// Receives the oldest message in the Queue, waiting until one is available.
+//
+func (self *Queue) Receive() (*Message, error) {
+	return self.ReceiveContext(context.Background())
+}
+
+// Receives the oldest message in the Queue, or returns ErrWouldBlock if the Queue is empty.
+//
+func (self *Queue) TryReceive() (*Message, error) {
+	return self.receive(nil)
+}
+
+// Receives the oldest message in the Queue, waiting until one is available or the context is done.
+//
+func (self *Queue) ReceiveContext(ctx context.Context) (*Message, error) {
+	return self.receive(ctx)
+}
+
+// a nil context means don't wait
+func (self *Queue) send(ctx context.Context, data []byte) (uint64, error) {
+	size := uint64(len(data)) + QueueRecordOverhead
+
+	if len(data) > self.MaxMessageSize() {
+		return 0, fmt.Errorf("Message of %d bytes exceeds the queue's maximum of %d: %w", len(data), self.MaxMessageSize(), ErrInvalidSize)
+	}
+
+	var seq uint64
+
+	err := self.waitFor(ctx, &self.header.NotFull, &self.header.SendWaiters, func() bool {
+		if self.capacity-(self.header.Head-self.header.Tail) < size {
+			return false
+		}
+
+		var record [QueueRecordOverhead]byte
+
+		seq = self.header.NextSeq
+		binary.LittleEndian.PutUint32(record[0:4], uint32(len(data)))
+		binary.LittleEndian.PutUint64(record[4:12], seq)
+
+		self.copyIn(record[:], self.header.Head)
+		self.copyIn(data, self.header.Head+QueueRecordOverhead)
+
+		// advancing the head publishes the message; the rest can be rebuilt from the records (see lock)
+		atomic.StoreUint64(&self.header.Head, self.header.Head+size)
+		self.header.NextSeq++
+		atomic.AddUint64(&self.header.Count, 1)
+
+		return true
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	self.signal(&self.header.NotEmpty, &self.header.ReceiveWaiters)
+
+	return seq, nil
+}
+
+func (self *Queue) receive(ctx context.Context) (*Message, error) {
+	var message *Message
+
+	err := self.waitFor(ctx, &self.header.NotEmpty, &self.header.ReceiveWaiters, func() bool {
+		if self.header.Count == 0 {
+			return false
+		}
+
+		var record [QueueRecordOverhead]byte
+
+		self.copyOut(record[:], self.header.Tail)
+
+		message = &Message{
+			Seq:  binary.LittleEndian.Uint64(record[4:12]),
+			Data: make([]byte, binary.LittleEndian.Uint32(record[0:4])),
+		}
+
+		self.copyOut(message.Data, self.header.Tail+QueueRecordOverhead)
+
+		// advancing the tail consumes the message; the count can be rebuilt from the records (see lock)
+		atomic.StoreUint64(&self.header.Tail, self.header.Tail+uint64(len(message.Data))+QueueRecordOverhead)
+		atomic.AddUint64(&self.header.Count, ^uint64(0))
+
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	self.signal(&self.header.NotFull, &self.header.SendWaiters)
+
+	return message, nil
+}
+
+// repeatedly lock the queue and call ready() until it returns true, sleeping on the given futex word
+// in between; a nil context means fail with ErrWouldBlock rather than sleeping
+func (self *Queue) waitFor(ctx context.Context, seq *uint32, waiters *uint32, ready func() bool) error {
+	lockCtx := ctx
+
+	if lockCtx == nil {
+		lockCtx = context.Background()
+	}
+
+	for {
+		if err := self.lock(lockCtx); err != nil {
+			return err
+		}
+
+		if ready() {
+			self.mutex.Unlock()
+			return nil
+		}
+
+		// read while holding the lock, so that any change made after we release it also changes
+		// the word and prevents us from sleeping through it
+		observed := atomic.LoadUint32(seq)
+		self.mutex.Unlock()
+
+		if ctx == nil {
+			return ErrWouldBlock
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		wait := MutexPollInterval
+
+		if deadline, ok := ctx.Deadline(); ok {
+			if remaining := time.Until(deadline); remaining <= 0 {
+				return context.DeadlineExceeded
+			} else if remaining < wait {
+				wait = remaining
+			}
+		}
+
+		atomic.AddUint32(waiters, 1)
+		err := futexWait(seq, observed, wait)
+		atomic.AddUint32(waiters, ^uint32(0))
+
+		switch err {
+		case nil, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT:
+		default:
+			return fmt.Errorf("Failed to wait on message queue: %v", err)
+		}
+	}
+}
+
+// a message is only published once the head moves past it, and only consumed once the tail does, so
+// a process that died while holding the lock can have left at most the count and next sequence number
+// behind; those are rebuilt from the records between the tail and the head before carrying on
+func (self *Queue) lock(ctx context.Context) error {
+	if err := self.mutex.LockContext(ctx); err == ErrOwnerDead {
+		self.recover()
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// recount the messages between the tail and the head, and make sure the next sequence number follows
+// the last of them
+func (self *Queue) recover() {
+	var record [QueueRecordOverhead]byte
+	var count uint64
+
+	pos := self.header.Tail
+
+	for pos+QueueRecordOverhead <= self.header.Head {
+		self.copyOut(record[:], pos)
+
+		size := uint64(binary.LittleEndian.Uint32(record[0:4])) + QueueRecordOverhead
+
+		if pos+size > self.header.Head {
+			break
+		}
+
+		if next := binary.LittleEndian.Uint64(record[4:12]) + 1; next > self.header.NextSeq {
+			self.header.NextSeq = next
+		}
+
+		pos += size
+		count++
+	}
+
+	// anything left over can't be a complete message
+	atomic.StoreUint64(&self.header.Head, pos)
+	atomic.StoreUint64(&self.header.Count, count)
+}
+
+// advance the given futex word, waking everyone waiting on it; waking just one waiter could strand a
+// message if that waiter gives up before taking it
+func (self *Queue) signal(seq *uint32, waiters *uint32) {
+	atomic.AddUint32(seq, 1)
+
+	if atomic.LoadUint32(waiters) > 0 {
+		futexWake(seq, math.MaxInt32)
+	}
+}
+
+// copy p into the data area at the given (unwrapped) position
+func (self *Queue) copyIn(p []byte, pos uint64) {
+	start := pos % self.capacity
+	n := copy(self.data[start:], p)
+	copy(self.data, p[n:])
+}
+
+// copy from the data area at the given (unwrapped) position into p
+func (self *Queue) copyOut(p []byte, pos uint64) {
+	start := pos % self.capacity
+	n := copy(p, self.data[start:])
+	copy(p[n:], self.data)
+}
@@ -0,0 +1,300 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// creates a segment with a Queue in it, handing the callback the given number of handles to the
+// Queue, each using its own attachment
+func makeQueue(t *testing.T, handles int, callback func(queues []*Queue) error) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		queues := make([]*Queue, handles)
+
+		for i := range queues {
+			mapping, err := segment.Map(AttachOptions{})
+
+			if err != nil {
+				return err
+			}
+
+			defer mapping.Close()
+
+			if i == 0 {
+				if _, err := OpenQueue(mapping, 0); err != ErrNotQueue {
+					return fmt.Errorf("Expected ErrNotQueue opening an uninitialized queue; got: %v", err)
+				}
+
+				queues[i], err = InitQueue(mapping, 0)
+			} else {
+				queues[i], err = OpenQueue(mapping, 0)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return callback(queues)
+	})
+}
+
+func TestQueueSendReceive(t *testing.T) {
+	makeQueue(t, 2, func(queues []*Queue) error {
+		sender, receiver := queues[0], queues[1]
+
+		if receiver.Cap() != 1024-QueueHeaderSize {
+			return fmt.Errorf("Wrong capacity; expected: %d, got: %d", 1024-QueueHeaderSize, receiver.Cap())
+		}
+
+		for i, text := range []string{`first`, ``, `third`} {
+			if seq, err := sender.Send([]byte(text)); err != nil {
+				return err
+			} else if seq != uint64(i+1) {
+				return fmt.Errorf("Wrong sequence number; expected: %d, got: %d", i+1, seq)
+			}
+		}
+
+		if receiver.Len() != 3 {
+			return fmt.Errorf("Wrong length; expected: 3, got: %d", receiver.Len())
+		}
+
+		for i, text := range []string{`first`, ``, `third`} {
+			if message, err := receiver.Receive(); err != nil {
+				return err
+			} else if message.Seq != uint64(i+1) || string(message.Data) != text {
+				return fmt.Errorf("Wrong message; expected: %d %q, got: %d %q", i+1, text, message.Seq, message.Data)
+			}
+		}
+
+		if _, err := receiver.TryReceive(); err != ErrWouldBlock {
+			return fmt.Errorf("Expected ErrWouldBlock receiving from an empty queue; got: %v", err)
+		}
+
+		if info, err := receiver.Stat(); err != nil {
+			return err
+		} else if info.Count != 0 || info.Used != 0 || info.NextSeq != 4 || info.Version != QueueVersion {
+			return fmt.Errorf("Wrong stats: %+v", info)
+		}
+
+		return nil
+	})
+}
+
+func TestQueueFull(t *testing.T) {
+	makeQueue(t, 1, func(queues []*Queue) error {
+		queue := queues[0]
+		message := make([]byte, 100)
+
+		if _, err := queue.TrySend(make([]byte, queue.MaxMessageSize()+1)); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize sending an oversized message; got: %v", err)
+		}
+
+		// repeatedly fill and drain the queue, so that messages wrap around the end of its data
+		for round := 0; round < 5; round++ {
+			sent := 0
+
+			for {
+				message[0] = byte(sent)
+
+				if _, err := queue.TrySend(message); err == ErrWouldBlock {
+					break
+				} else if err != nil {
+					return err
+				}
+
+				sent++
+			}
+
+			if expected := queue.Cap() / (len(message) + QueueRecordOverhead); sent != expected {
+				return fmt.Errorf("Wrong number of messages fit; expected: %d, got: %d", expected, sent)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			_, err := queue.SendContext(ctx, message)
+			cancel()
+
+			if err != context.DeadlineExceeded {
+				return fmt.Errorf("Expected a timeout sending to a full queue; got: %v", err)
+			}
+
+			for i := 0; i < sent; i++ {
+				if received, err := queue.TryReceive(); err != nil {
+					return err
+				} else if len(received.Data) != len(message) || received.Data[0] != byte(i) {
+					return fmt.Errorf("Wrong message %d in round %d", i, round)
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func TestQueueOwnerDead(t *testing.T) {
+	// run a process to completion, so that we have the PID of one that is known to have exited
+	cmd := exec.Command(`true`)
+
+	if err := cmd.Run(); err != nil {
+		t.Skipf("Cannot run a child process: %v", err)
+	}
+
+	makeQueue(t, 1, func(queues []*Queue) error {
+		queue := queues[0]
+
+		for _, message := range []string{`one`, `two`} {
+			if _, err := queue.TrySend([]byte(message)); err != nil {
+				return err
+			}
+		}
+
+		// simulate a sender that died holding the lock, just after publishing a third message
+		var record [QueueRecordOverhead]byte
+
+		binary.LittleEndian.PutUint32(record[0:4], 5)
+		binary.LittleEndian.PutUint64(record[4:12], 3)
+		queue.copyIn(record[:], queue.header.Head)
+		queue.copyIn([]byte(`three`), queue.header.Head+QueueRecordOverhead)
+		queue.header.Head += QueueRecordOverhead + 5
+		atomic.StoreUint32(&queue.header.Lock, uint32(cmd.Process.Pid))
+
+		if seq, err := queue.TrySend([]byte(`four`)); err != nil {
+			return err
+		} else if seq != 4 {
+			return fmt.Errorf("Wrong sequence number after recovery; expected: 4, got: %d", seq)
+		} else if queue.Len() != 4 {
+			return fmt.Errorf("Wrong length after recovery; expected: 4, got: %d", queue.Len())
+		}
+
+		for i, expected := range []string{`one`, `two`, `three`, `four`} {
+			if message, err := queue.TryReceive(); err != nil {
+				return err
+			} else if string(message.Data) != expected || message.Seq != uint64(i+1) {
+				return fmt.Errorf("Wrong message %d: %q (seq %d)", i, message.Data, message.Seq)
+			}
+		}
+
+		return nil
+	})
+}
+
+func TestQueueConcurrent(t *testing.T) {
+	const senders = 4
+	const receivers = 3
+	const perSender = 500
+
+	makeQueue(t, senders+receivers, func(queues []*Queue) error {
+		var wg sync.WaitGroup
+
+		errs := make(chan error, senders+receivers)
+		received := make(chan *Message, senders*perSender)
+
+		for s := 0; s < senders; s++ {
+			wg.Add(1)
+
+			go func(queue *Queue, sender int) {
+				defer wg.Done()
+
+				for i := 0; i < perSender; i++ {
+					message := make([]byte, 8+(i%32))
+					binary.LittleEndian.PutUint32(message[0:4], uint32(sender))
+					binary.LittleEndian.PutUint32(message[4:8], uint32(i))
+
+					if _, err := queue.Send(message); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(queues[s], s)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var rg sync.WaitGroup
+
+		for r := 0; r < receivers; r++ {
+			rg.Add(1)
+
+			go func(queue *Queue) {
+				defer rg.Done()
+
+				for {
+					message, err := queue.ReceiveContext(ctx)
+
+					if err == context.Canceled {
+						return
+					} else if err != nil {
+						errs <- err
+						return
+					}
+
+					received <- message
+				}
+			}(queues[senders+r])
+		}
+
+		wg.Wait()
+
+		counts := make([]uint32, senders)
+		seen := make(map[uint64]bool)
+
+		for n := 0; n < senders*perSender; n++ {
+			select {
+			case message := <-received:
+				sender := binary.LittleEndian.Uint32(message.Data[0:4])
+				index := binary.LittleEndian.Uint32(message.Data[4:8])
+
+				if len(message.Data) != 8+int(index%32) {
+					return fmt.Errorf("Message %d from sender %d has the wrong length: %d", index, sender, len(message.Data))
+				}
+
+				if seen[message.Seq] {
+					return fmt.Errorf("Message %d was received twice", message.Seq)
+				}
+
+				seen[message.Seq] = true
+				counts[sender]++
+			case err := <-errs:
+				return err
+			case <-ctx.Done():
+				return fmt.Errorf("Timed out after receiving %d of %d messages", n, senders*perSender)
+			}
+		}
+
+		cancel()
+		rg.Wait()
+
+		for sender, count := range counts {
+			if count != perSender {
+				return fmt.Errorf("Received %d messages from sender %d; expected %d", count, sender, perSender)
+			}
+		}
+
+		if queues[0].Len() != 0 {
+			return fmt.Errorf("Expected the queue to be empty; %d messages remain", queues[0].Len())
+		}
+
+		return nil
+	})
+}
+
+func TestQueueHeaderLayout(t *testing.T) {
+	makeHeaderSegment(t, func(segment *Segment, mapping *Mapping, header *Header) error {
+		if _, err := InitQueue(mapping, header.PayloadOffset); err != nil {
+			return err
+		}
+
+		return checkHeaderLayout(segment, LayoutQueue)
+	})
+}
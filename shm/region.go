@@ -0,0 +1,122 @@
+package shm
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A backend-agnostic view of a region of shared memory.  Every kind of shared memory in this package
+// implements it, and code written against it can work with any of them (or with a test double).
+type Region interface {
+	io.ReaderAt
+	io.WriterAt
+
+	// Returns the size of the region (in bytes).
+	Len() int64
+
+	// Attaches the region and returns a Mapping for direct access to its memory.
+	Map(opts AttachOptions) (*Mapping, error)
+
+	// Releases any resources held by this process for the region.  The region itself continues to
+	// exist.
+	Close() error
+
+	// Removes the region from the system.
+	Destroy() error
+
+	// Retrieves metadata about the region.  Fields that do not apply to a given kind of region are
+	// left at their zero values.
+	Info() (*SegmentInfo, error)
+}
+
+// A function that opens a region given the part of a URI following the scheme and colon.
+type Opener func(address string) (Region, error)
+
+var openers = make(map[string]Opener)
+var openersMu sync.RWMutex
+
+// Registers an Opener for URIs of the form SCHEME:ADDRESS, replacing any existing opener for that
+// scheme.  The following schemes are registered by this package:
+//
+//	sysv:ID        - a SysV segment by its ID (e.g.: sysv:12345)
+//	sysv-key:KEY   - a SysV segment by its IPC key (e.g.: sysv-key:0x42)
+//	posix:/NAME    - a POSIX shared memory object (e.g.: posix:/frames)
+//	memfd:FD       - a memfd inherited from a parent process (e.g.: memfd:3)
+//
+func RegisterScheme(scheme string, opener Opener) {
+	openersMu.Lock()
+	defer openersMu.Unlock()
+
+	if opener == nil {
+		delete(openers, scheme)
+	} else {
+		openers[scheme] = opener
+	}
+}
+
+// Returns the names of all registered schemes.
+//
+func Schemes() []string {
+	openersMu.RLock()
+	defer openersMu.RUnlock()
+
+	schemes := make([]string, 0, len(openers))
+
+	for scheme := range openers {
+		schemes = append(schemes, scheme)
+	}
+
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Opens the region identified by the given URI, using the Opener registered for its scheme.  A bare
+// number is treated as a SysV segment ID.
+//
+func OpenURI(uri string) (Region, error) {
+	scheme, address := `sysv`, uri
+
+	if i := strings.Index(uri, `:`); i >= 0 {
+		scheme, address = uri[:i], uri[i+1:]
+	}
+
+	openersMu.RLock()
+	opener, ok := openers[scheme]
+	openersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unsupported shared memory scheme %q", scheme)
+	}
+
+	if region, err := opener(address); err == nil {
+		return region, nil
+	} else {
+		return nil, fmt.Errorf("Failed to open %s: %w", uri, err)
+	}
+}
+
+func init() {
+	RegisterScheme(`sysv`, func(address string) (Region, error) {
+		if id, err := strconv.ParseUint(address, 10, 31); err == nil {
+			return Open(int(id))
+		} else {
+			return nil, fmt.Errorf("Invalid segment ID %q", address)
+		}
+	})
+
+	RegisterScheme(`sysv-key`, func(address string) (Region, error) {
+		if key, err := ParseKey(address); err == nil {
+			return OpenByKey(key)
+		} else {
+			return nil, err
+		}
+	})
+
+	RegisterScheme(`posix`, func(address string) (Region, error) {
+		return OpenPosix(address)
+	})
+}
@@ -0,0 +1,156 @@
+package shm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+// a trivial in-process Region, standing in for a test double
+type testRegion struct {
+	data      []byte
+	destroyed bool
+}
+
+func (self *testRegion) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(self.data).ReadAt(p, off)
+}
+
+func (self *testRegion) WriteAt(p []byte, off int64) (int, error) {
+	return copy(self.data[off:], p), nil
+}
+
+func (self *testRegion) Len() int64 { return int64(len(self.data)) }
+func (self *testRegion) Map(opts AttachOptions) (*Mapping, error) {
+	return nil, fmt.Errorf("unsupported")
+}
+func (self *testRegion) Close() error   { return nil }
+func (self *testRegion) Destroy() error { self.destroyed = true; return nil }
+func (self *testRegion) Info() (*SegmentInfo, error) {
+	return &SegmentInfo{URI: `test:`, Id: -1, Size: self.Len()}, nil
+}
+
+func TestOpenURISysv(t *testing.T) {
+	key, err := KeyFromPath(`region_test.go`, DefaultProjectId)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segment, err := CreateWithKey(key, 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer segment.Destroy()
+
+	for _, uri := range []string{
+		fmt.Sprintf("%d", segment.Id),
+		fmt.Sprintf("sysv:%d", segment.Id),
+		fmt.Sprintf("sysv-key:%v", key),
+	} {
+		if region, err := OpenURI(uri); err != nil {
+			t.Errorf("Failed to open %s: %v", uri, err)
+		} else if s, ok := region.(*Segment); !ok || s.Id != segment.Id {
+			t.Errorf("Wrong region opened for %s: %#v", uri, region)
+		} else if info, err := region.Info(); err != nil {
+			t.Error(err)
+		} else if info.URI != fmt.Sprintf("sysv:%d", segment.Id) {
+			t.Errorf("Wrong URI for %s: %s", uri, info.URI)
+		}
+	}
+
+	for _, uri := range []string{`sysv:`, `sysv:abc`, `sysv-key:zzz`, `nope:1`} {
+		if _, err := OpenURI(uri); err == nil {
+			t.Errorf("Expected %s to fail", uri)
+		}
+	}
+}
+
+func TestOpenURIPosix(t *testing.T) {
+	makePosixSegment(t, 64, func(segment *PosixSegment) error {
+		region, err := OpenURI(`posix:` + segment.Name)
+
+		if err != nil {
+			return err
+		}
+
+		defer region.Close()
+
+		if region.Len() != 64 {
+			return fmt.Errorf("Wrong size; expected: 64, got: %d", region.Len())
+		}
+
+		if info, err := region.Info(); err != nil {
+			return err
+		} else if info.URI != `posix:`+segment.Name || info.Id != -1 || info.Mode != 0600 {
+			return fmt.Errorf("Wrong info: %+v", info)
+		} else if info.OwnerUID != os.Getuid() {
+			return fmt.Errorf("Wrong owner; expected: %d, got: %d", os.Getuid(), info.OwnerUID)
+		}
+
+		return nil
+	})
+}
+
+func TestRegisterScheme(t *testing.T) {
+	double := &testRegion{
+		data: make([]byte, 16),
+	}
+
+	RegisterScheme(`test`, func(address string) (Region, error) {
+		return double, nil
+	})
+
+	defer RegisterScheme(`test`, nil)
+
+	found := false
+
+	for _, scheme := range Schemes() {
+		if scheme == `test` {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("Registered scheme not listed in %v", Schemes())
+	}
+
+	if region, err := OpenURI(`test:anything`); err != nil {
+		t.Fatal(err)
+	} else if err := region.Destroy(); err != nil || !double.destroyed {
+		t.Errorf("Expected test double to be used")
+	}
+}
+
+func TestSegmentReadWriteAt(t *testing.T) {
+	writeFullSegment(t, 1024, func(segment *Segment, input []byte) error {
+		var region Region = segment
+
+		segment.Seek(100, 0)
+		output := make([]byte, 8)
+
+		if n, err := region.ReadAt(output, 512); err != nil || n != 8 {
+			return fmt.Errorf("ReadAt failed: n=%d err=%v", n, err)
+		} else if !bytes.Equal(output, input[512:520]) {
+			return fmt.Errorf("Wrong data from ReadAt: %v", output)
+		} else if segment.Position() != 100 {
+			return fmt.Errorf("ReadAt should not move the position; got: %d", segment.Position())
+		}
+
+		if n, err := region.WriteAt([]byte{9, 9}, region.Len()-1); err != io.EOF || n != 1 {
+			return fmt.Errorf("Expected short write at end; got n=%d err=%v", n, err)
+		}
+
+		if n, err := region.ReadAt(output, region.Len()-4); err != io.EOF || n != 4 {
+			return fmt.Errorf("Expected short read at end; got n=%d err=%v", n, err)
+		} else if output[3] != 9 {
+			return fmt.Errorf("WriteAt data not read back: %v", output[:4])
+		}
+
+		return nil
+	})
+}
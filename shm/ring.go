@@ -0,0 +1,360 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// Identifies shared memory that has been initialized as a Ring ("SHMR").
+	RingMagic = 0x524d4853
+
+	// The version of the Ring layout implemented by this package.
+	RingVersion = 1
+
+	// The number of bytes at the start of a Ring occupied by its header; the rest holds data.
+	RingHeaderSize = 192
+)
+
+// flag bits in the ring header
+const (
+	ringWriteClosed = 1 << 0
+	ringReadClosed  = 1 << 1
+	ringReaderWaits = 1 << 2
+	ringWriterWaits = 1 << 3
+	ringAlignment   = 8
+)
+
+// Returned by OpenRing when the memory does not contain a Ring.
+var ErrNotRing = errors.New(`shared memory does not contain a ring buffer`)
+
+// The header at the start of a Ring.  The head and tail counters are kept on separate cache lines
+// from each other and from the rest of the header, so the producer and consumer don't contend.
+type ringHeader struct {
+	Magic    uint32
+	Version  uint32
+	Capacity uint64
+	Flags    uint32
+	DataSeq  uint32
+	SpaceSeq uint32
+	_        uint32
+	Dropped  uint64
+	_        [24]byte
+	Head     uint64
+	_        [56]byte
+	Tail     uint64
+	_        [56]byte
+}
+
+// What a Ring's producer does when there isn't room for a write.
+type RingFullPolicy int
+
+const (
+	// Wait for the consumer to make room.
+	RingBlock RingFullPolicy = iota
+
+	// Discard the entire write, counting the discarded bytes in Dropped().
+	RingDrop
+)
+
+// A single-producer, single-consumer ring buffer in shared memory, for streaming bytes between two
+// processes.  The producer writes to the Ring with Write() and the consumer reads from it with
+// Read(), so the two ends can be used with io.Copy.  Neither end takes a lock: the producer only
+// ever advances the head and the consumer the tail.  When the Ring is empty the consumer sleeps on a
+// futex until the producer writes; when it is full, the producer either sleeps until the consumer
+// reads or discards the write, depending on its RingFullPolicy.
+//
+// The Ring is self-describing: a header holding a magic number, version and capacity is stored in
+// front of the data, so the consumer only needs to know where the Ring is (see OpenRing).  At most
+// one process (or goroutine) may write to a Ring, and at most one may read from it, at a time.  If
+// one end exits without closing the Ring, the other may wait for it indefinitely.
+type Ring struct {
+	// What Write() does when there is not enough room for the data being written.
+	Policy RingFullPolicy
+
+	mapping  *Mapping
+	header   *ringHeader
+	data     []byte
+	capacity uint64
+}
+
+// Initializes a new, empty Ring occupying the mapping from the given offset to its end, discarding
+// whatever was previously stored there.  The offset must be aligned to an 8 byte boundary.  The
+// mapping must remain open for as long as the Ring is used.  If the mapping begins with a segment
+// header whose payload starts at the offset, the header's layout is set to LayoutRing.
+//
+func InitRing(mapping *Mapping, offset int64) (*Ring, error) {
+	header, data, err := ringAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range data {
+		data[i] = 0
+	}
+
+	*header = ringHeader{
+		Version:  RingVersion,
+		Capacity: uint64(len(data)),
+	}
+
+	// the magic number is written last, so that a concurrent OpenRing never sees a partial header
+	atomic.StoreUint32(&header.Magic, RingMagic)
+	setHeaderLayout(mapping, offset, LayoutRing)
+
+	return newRing(mapping, header, data), nil
+}
+
+// Opens the Ring stored in the mapping at the given offset, which must have been initialized with
+// InitRing.  Returns ErrNotRing if no Ring is found there.
+//
+func OpenRing(mapping *Mapping, offset int64) (*Ring, error) {
+	header, data, err := ringAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if atomic.LoadUint32(&header.Magic) != RingMagic {
+		return nil, ErrNotRing
+	} else if header.Version != RingVersion {
+		return nil, fmt.Errorf("Unsupported ring buffer version %d", header.Version)
+	} else if header.Capacity > uint64(len(data)) {
+		return nil, fmt.Errorf("Ring buffer capacity %d exceeds the %d bytes available", header.Capacity, len(data))
+	}
+
+	return newRing(mapping, header, data[:header.Capacity]), nil
+}
+
+func ringAt(mapping *Mapping, offset int64) (*ringHeader, []byte, error) {
+	data := mapping.Bytes()
+
+	if data == nil {
+		return nil, nil, fmt.Errorf("Cannot use a closed mapping")
+	} else if mapping.ReadOnly() {
+		return nil, nil, fmt.Errorf("Cannot use a ring buffer in a read-only mapping")
+	} else if offset < 0 || offset%ringAlignment != 0 {
+		return nil, nil, fmt.Errorf("Offset %d is not aligned to a %d byte boundary", offset, ringAlignment)
+	} else if int64(len(data))-offset <= RingHeaderSize {
+		return nil, nil, fmt.Errorf("Not enough room for a ring buffer at offset %d of a %d byte mapping", offset, len(data))
+	}
+
+	return (*ringHeader)(unsafe.Pointer(&data[offset])), data[offset+RingHeaderSize:], nil
+}
+
+func newRing(mapping *Mapping, header *ringHeader, data []byte) *Ring {
+	return &Ring{
+		mapping:  mapping,
+		header:   header,
+		data:     data,
+		capacity: uint64(len(data)),
+	}
+}
+
+// Returns the number of bytes the Ring can hold.
+func (self *Ring) Cap() int {
+	return int(self.capacity)
+}
+
+// Returns the number of bytes written to the Ring that have not yet been read.
+func (self *Ring) Len() int {
+	return int(atomic.LoadUint64(&self.header.Head) - atomic.LoadUint64(&self.header.Tail))
+}
+
+// Returns the total number of bytes the producer has discarded because the Ring was full.
+func (self *Ring) Dropped() uint64 {
+	return atomic.LoadUint64(&self.header.Dropped)
+}
+
+// Implements the io.Writer interface for the producer.  Unless the Ring's policy is RingDrop, this
+// blocks until all of p has been written, which may take several rounds if p is larger than the
+// Ring.  Returns io.ErrClosedPipe if the consumer has closed the Ring.
+//
+func (self *Ring) Write(p []byte) (int, error) {
+	written := 0
+
+	if self.flags()&ringWriteClosed != 0 {
+		return 0, fmt.Errorf("Cannot write to a ring buffer after CloseWrite")
+	}
+
+	if self.Policy == RingDrop {
+		if uint64(len(p)) > self.capacity-uint64(self.Len()) {
+			atomic.AddUint64(&self.header.Dropped, uint64(len(p)))
+			return len(p), nil
+		}
+	}
+
+	for written < len(p) {
+		if self.flags()&ringReadClosed != 0 {
+			return written, io.ErrClosedPipe
+		}
+
+		head := atomic.LoadUint64(&self.header.Head)
+		free := self.capacity - (head - atomic.LoadUint64(&self.header.Tail))
+
+		if free == 0 {
+			if err := self.wait(&self.header.SpaceSeq, ringWriterWaits, func() bool {
+				return self.Len() < int(self.capacity) || self.flags()&ringReadClosed != 0
+			}); err != nil {
+				return written, err
+			}
+
+			continue
+		}
+
+		n := self.copyIn(p[written:], head, free)
+		written += n
+
+		atomic.StoreUint64(&self.header.Head, head+uint64(n))
+		self.signal(&self.header.DataSeq, ringReaderWaits)
+	}
+
+	return written, nil
+}
+
+// Implements the io.Reader interface for the consumer.  This blocks until at least one byte is
+// available, and returns io.EOF once the producer has called CloseWrite() and every byte written
+// before then has been read.
+//
+func (self *Ring) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		tail := atomic.LoadUint64(&self.header.Tail)
+		available := atomic.LoadUint64(&self.header.Head) - tail
+
+		if available == 0 {
+			if self.flags()&ringWriteClosed != 0 {
+				// the producer may have written more just before closing
+				if atomic.LoadUint64(&self.header.Head) == tail {
+					return 0, io.EOF
+				}
+
+				continue
+			}
+
+			if err := self.wait(&self.header.DataSeq, ringReaderWaits, func() bool {
+				return self.Len() > 0 || self.flags()&ringWriteClosed != 0
+			}); err != nil {
+				return 0, err
+			}
+
+			continue
+		}
+
+		n := self.copyOut(p, tail, available)
+
+		atomic.StoreUint64(&self.header.Tail, tail+uint64(n))
+		self.signal(&self.header.SpaceSeq, ringWriterWaits)
+
+		return n, nil
+	}
+}
+
+// Called by the producer to indicate that it will not write any more data.  Once the consumer has
+// read everything already written, its reads return io.EOF.
+//
+func (self *Ring) CloseWrite() error {
+	self.setFlag(ringWriteClosed)
+	self.signal(&self.header.DataSeq, ringReaderWaits)
+	return nil
+}
+
+// Called by the consumer to indicate that it will not read any more data.  Subsequent (and blocked)
+// writes by the producer return io.ErrClosedPipe.
+//
+func (self *Ring) CloseRead() error {
+	self.setFlag(ringReadClosed)
+	self.signal(&self.header.SpaceSeq, ringWriterWaits)
+	return nil
+}
+
+// copy as much of p as fits into the free space following head, wrapping around the end of the data
+func (self *Ring) copyIn(p []byte, head uint64, free uint64) int {
+	if uint64(len(p)) > free {
+		p = p[:free]
+	}
+
+	start := head % self.capacity
+	n := copy(self.data[start:], p)
+	n += copy(self.data, p[n:])
+
+	return n
+}
+
+// copy up to len(p) of the available bytes following tail into p, wrapping around the end of the data
+func (self *Ring) copyOut(p []byte, tail uint64, available uint64) int {
+	if uint64(len(p)) > available {
+		p = p[:available]
+	}
+
+	start := tail % self.capacity
+	n := copy(p, self.data[start:])
+	n += copy(p[n:], self.data)
+
+	return n
+}
+
+func (self *Ring) flags() uint32 {
+	return atomic.LoadUint32(&self.header.Flags)
+}
+
+func (self *Ring) setFlag(flag uint32) {
+	for {
+		current := atomic.LoadUint32(&self.header.Flags)
+
+		if atomic.CompareAndSwapUint32(&self.header.Flags, current, current|flag) {
+			return
+		}
+	}
+}
+
+func (self *Ring) clearFlag(flag uint32) {
+	for {
+		current := atomic.LoadUint32(&self.header.Flags)
+
+		if atomic.CompareAndSwapUint32(&self.header.Flags, current, current&^flag) {
+			return
+		}
+	}
+}
+
+// sleep on the given sequence word until ready() returns true; the waiting flag tells the other end
+// that it needs to wake us, so that it can skip the system call when nobody is waiting
+func (self *Ring) wait(seq *uint32, waiting uint32, ready func() bool) error {
+	observed := atomic.LoadUint32(seq)
+
+	self.setFlag(waiting)
+	defer self.clearFlag(waiting)
+
+	// the other end may have acted between our last check and setting the flag
+	if ready() {
+		return nil
+	}
+
+	switch err := futexWait(seq, observed, 0); err {
+	case nil, syscall.EAGAIN, syscall.EINTR:
+		return nil
+	default:
+		return fmt.Errorf("Failed to wait on ring buffer: %v", err)
+	}
+}
+
+// advance the given sequence word, waking the other end if it is waiting on it
+func (self *Ring) signal(seq *uint32, waiting uint32) {
+	atomic.AddUint32(seq, 1)
+
+	if self.flags()&waiting != 0 {
+		futexWake(seq, 1)
+	}
+}
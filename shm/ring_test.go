@@ -0,0 +1,180 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// creates a segment with a Ring in it, giving the producer and consumer separate attachments
+func makeRing(t *testing.T, callback func(producer *Ring, consumer *Ring) error) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		pmap, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer pmap.Close()
+
+		cmap, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer cmap.Close()
+
+		if _, err := OpenRing(cmap, 0); err != ErrNotRing {
+			return fmt.Errorf("Expected ErrNotRing opening an uninitialized ring; got: %v", err)
+		}
+
+		producer, err := InitRing(pmap, 0)
+
+		if err != nil {
+			return err
+		}
+
+		consumer, err := OpenRing(cmap, 0)
+
+		if err != nil {
+			return err
+		}
+
+		return callback(producer, consumer)
+	})
+}
+
+func TestRingStream(t *testing.T) {
+	makeRing(t, func(producer *Ring, consumer *Ring) error {
+		if consumer.Cap() != 1024-RingHeaderSize {
+			return fmt.Errorf("Wrong capacity; expected: %d, got: %d", 1024-RingHeaderSize, consumer.Cap())
+		}
+
+		// much larger than the ring, so that both ends have to wait for each other many times
+		input := make([]byte, 1<<20)
+		rand.New(rand.NewSource(1)).Read(input)
+
+		errs := make(chan error, 1)
+
+		go func() {
+			_, err := io.Copy(producer, bytes.NewReader(input))
+			producer.CloseWrite()
+			errs <- err
+		}()
+
+		output, err := ioutil.ReadAll(consumer)
+
+		if err != nil {
+			return err
+		} else if err := <-errs; err != nil {
+			return fmt.Errorf("Producer failed: %v", err)
+		}
+
+		if !bytes.Equal(input, output) {
+			return fmt.Errorf("Output does not match input (%d bytes in, %d out)", len(input), len(output))
+		}
+
+		if n, err := consumer.Read(make([]byte, 16)); n != 0 || err != io.EOF {
+			return fmt.Errorf("Expected EOF after the producer closed; got n=%d err=%v", n, err)
+		}
+
+		return nil
+	})
+}
+
+func TestRingDrop(t *testing.T) {
+	makeRing(t, func(producer *Ring, consumer *Ring) error {
+		producer.Policy = RingDrop
+		chunk := bytes.Repeat([]byte{0x42}, 500)
+
+		for i := 0; i < 3; i++ {
+			if n, err := producer.Write(chunk); err != nil || n != len(chunk) {
+				return fmt.Errorf("Write %d failed: n=%d err=%v", i, n, err)
+			}
+		}
+
+		// only one 500 byte write fits in the 832 byte ring; the others are dropped whole
+		if consumer.Len() != 500 {
+			return fmt.Errorf("Wrong buffered length; expected: 500, got: %d", consumer.Len())
+		} else if consumer.Dropped() != 1000 {
+			return fmt.Errorf("Wrong dropped count; expected: 1000, got: %d", consumer.Dropped())
+		}
+
+		output := make([]byte, 1024)
+
+		if n, err := consumer.Read(output); err != nil || n != 500 {
+			return fmt.Errorf("Read failed: n=%d err=%v", n, err)
+		}
+
+		if n, err := producer.Write(chunk); err != nil || n != len(chunk) || consumer.Len() != 500 {
+			return fmt.Errorf("Write after draining failed: n=%d err=%v len=%d", n, err, consumer.Len())
+		}
+
+		return nil
+	})
+}
+
+func TestRingCloseRead(t *testing.T) {
+	makeRing(t, func(producer *Ring, consumer *Ring) error {
+		errs := make(chan error, 1)
+
+		go func() {
+			_, err := producer.Write(make([]byte, 4096))
+			errs <- err
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+		consumer.CloseRead()
+
+		select {
+		case err := <-errs:
+			if err != io.ErrClosedPipe {
+				return fmt.Errorf("Expected io.ErrClosedPipe; got: %v", err)
+			}
+		case <-time.After(time.Second):
+			return fmt.Errorf("Blocked producer was not woken when the consumer closed")
+		}
+
+		return nil
+	})
+}
+
+func TestRingInvalid(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		mapping, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer mapping.Close()
+
+		if _, err := InitRing(mapping, 4); err == nil {
+			return fmt.Errorf("Expected a misaligned ring to fail")
+		}
+
+		if _, err := InitRing(mapping, 1024-RingHeaderSize); err == nil {
+			return fmt.Errorf("Expected a ring with no room for data to fail")
+		}
+
+		return nil
+	})
+}
+
+func TestRingHeaderLayout(t *testing.T) {
+	makeHeaderSegment(t, func(segment *Segment, mapping *Mapping, header *Header) error {
+		if _, err := InitRing(mapping, header.PayloadOffset); err != nil {
+			return err
+		}
+
+		return checkHeaderLayout(segment, LayoutRing)
+	})
+}
@@ -0,0 +1,419 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package shm
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// semctl(2) commands from <linux/sem.h>
+const (
+	semGetPid  = 11
+	semGetVal  = 12
+	semGetAll  = 13
+	semGetNcnt = 14
+	semGetZcnt = 15
+	semSetVal  = 16
+	semSetAll  = 17
+)
+
+// The procfs file listing every SysV semaphore set on the system (Linux only).
+var ProcSysvSemPath = `/proc/sysvipc/sem`
+
+// Flags that modify a SemOp.
+type SemFlags int16
+
+const (
+	// Fail with ErrWouldBlock instead of waiting if the operation cannot be performed immediately.
+	SemNoWait SemFlags = 04000
+
+	// Undo the operation automatically when the calling process exits, so that a process that dies
+	// while holding a semaphore does not leave it held.
+	SemUndo SemFlags = 0x1000
+)
+
+// An operation on a single semaphore of a set, as with struct sembuf.  A positive Op adds to the
+// semaphore's value; a negative Op waits until the value is at least -Op and then subtracts from it;
+// and an Op of zero waits until the value is zero.
+type SemOp struct {
+	Num   uint16
+	Op    int16
+	Flags SemFlags
+}
+
+// A SysV semaphore set, as created by semget(2).  Semaphores are how classic SysV programs guard
+// access to shared memory segments, conventionally using a set with the same key as the segment (see
+// Segment.Semaphores).
+type SemaphoreSet struct {
+	Id    int
+	Key   Key
+	count int
+}
+
+// Metadata describing a semaphore set, as reported by the kernel.
+type SemaphoreSetInfo struct {
+	Id          int             `json:"id"`
+	Key         Key             `json:"key"`
+	Count       int             `json:"count"`
+	Mode        os.FileMode     `json:"mode"`
+	OwnerUID    int             `json:"owner_uid"`
+	OwnerGID    int             `json:"owner_gid"`
+	CreatorUID  int             `json:"creator_uid"`
+	CreatorGID  int             `json:"creator_gid"`
+	LastOp      time.Time       `json:"last_op"`
+	LastChanged time.Time       `json:"last_changed"`
+	Semaphores  []SemaphoreInfo `json:"semaphores,omitempty"`
+}
+
+// The state of a single semaphore within a set.
+type SemaphoreInfo struct {
+	Value          int `json:"value"`
+	LastPID        int `json:"last_pid"`
+	Waiting        int `json:"waiting"`
+	WaitingForZero int `json:"waiting_for_zero"`
+}
+
+// Create a new semaphore set with the given number of semaphores, identified by the given key.  All
+// semaphores start with a value of zero.  This will fail if a set already exists for this key.
+//
+func CreateSemaphoreSet(key Key, count int) (*SemaphoreSet, error) {
+	return OpenSemaphoreSetWithKey(key, count, (IpcCreate | IpcExclusive), 0600)
+}
+
+// Open an existing semaphore set by its ID.
+//
+func OpenSemaphoreSet(id int) (*SemaphoreSet, error) {
+	var ds semidDs
+
+	if err := semctl(id, 0, ipcStat, uintptr(unsafe.Pointer(&ds))); err == nil {
+		return &SemaphoreSet{
+			Id:    id,
+			Key:   Key(ds.Perm.Key),
+			count: int(ds.Nsems),
+		}, nil
+	} else {
+		return nil, semError(`stat`, id, IpcPrivate, err)
+	}
+}
+
+// Open an existing semaphore set by its key.
+//
+func OpenSemaphoreSetByKey(key Key) (*SemaphoreSet, error) {
+	if key == IpcPrivate {
+		return nil, semError(`open`, -1, key, syscall.ENOENT)
+	}
+
+	return OpenSemaphoreSetWithKey(key, 0, IpcNone, 0)
+}
+
+// Creates or opens the semaphore set identified by the given key, in the manner of
+// OpenSegmentWithKey.  If count is zero, an existing set is opened and flags and perms are ignored.
+//
+func OpenSemaphoreSetWithKey(key Key, count int, flags SharedMemoryFlags, perms os.FileMode) (*SemaphoreSet, error) {
+	op := `open`
+
+	if count > 0 && perms == 0 {
+		perms = 0600
+	} else if count == 0 {
+		flags = IpcNone
+		perms = 0
+	}
+
+	if flags&IpcCreate != 0 {
+		op = `create`
+	}
+
+	if count < 0 {
+		return nil, semError(op, -1, key, syscall.EINVAL)
+	}
+
+	id, _, errno := syscall.Syscall(syscall.SYS_SEMGET, uintptr(int32(key)), uintptr(count), uintptr(int(flags)|int(perms.Perm())))
+
+	if errno != 0 {
+		return nil, semError(op, -1, key, errno)
+	}
+
+	return OpenSemaphoreSet(int(id))
+}
+
+// Retrieve the metadata for the semaphore set with the given ID, including the state of each of its
+// semaphores.
+//
+func StatSemaphoreSet(id int) (*SemaphoreSetInfo, error) {
+	var ds semidDs
+
+	if err := semctl(id, 0, ipcStat, uintptr(unsafe.Pointer(&ds))); err != nil {
+		return nil, semError(`stat`, id, IpcPrivate, err)
+	}
+
+	info := &SemaphoreSetInfo{
+		Id:          id,
+		Key:         Key(ds.Perm.Key),
+		Count:       int(ds.Nsems),
+		Mode:        os.FileMode(ds.Perm.Mode & 0777),
+		OwnerUID:    int(ds.Perm.Uid),
+		OwnerGID:    int(ds.Perm.Gid),
+		CreatorUID:  int(ds.Perm.Cuid),
+		CreatorGID:  int(ds.Perm.Cgid),
+		LastOp:      unixTime(ds.Otime),
+		LastChanged: unixTime(ds.Ctime),
+		Semaphores:  make([]SemaphoreInfo, ds.Nsems),
+	}
+
+	for i := range info.Semaphores {
+		sem := &info.Semaphores[i]
+
+		for _, field := range []struct {
+			cmd   int
+			value *int
+		}{
+			{semGetVal, &sem.Value},
+			{semGetPid, &sem.LastPID},
+			{semGetNcnt, &sem.Waiting},
+			{semGetZcnt, &sem.WaitingForZero},
+		} {
+			if v, _, errno := syscall.Syscall6(syscall.SYS_SEMCTL, uintptr(id), uintptr(i), uintptr(field.cmd), 0, 0, 0); errno == 0 {
+				*field.value = int(v)
+			} else {
+				return nil, semError(`stat`, id, info.Key, errno)
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// Retrieve the metadata for every semaphore set on the system, regardless of whether the caller has
+// permission to access them.  The state of individual semaphores is not included.
+//
+func ListSemaphoreSets() ([]*SemaphoreSetInfo, error) {
+	file, err := os.Open(ProcSysvSemPath)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list semaphore sets: %w", err)
+	}
+
+	defer file.Close()
+
+	var sets []*SemaphoreSetInfo
+
+	err = parseProcSysvipc(file, func(field func(name string) int64) {
+		sets = append(sets, &SemaphoreSetInfo{
+			Id:          int(field(`semid`)),
+			Key:         Key(field(`key`)),
+			Count:       int(field(`nsems`)),
+			Mode:        os.FileMode(field(`perms`) & 0777),
+			OwnerUID:    int(field(`uid`)),
+			OwnerGID:    int(field(`gid`)),
+			CreatorUID:  int(field(`cuid`)),
+			CreatorGID:  int(field(`cgid`)),
+			LastOp:      unixTime(field(`otime`)),
+			LastChanged: unixTime(field(`ctime`)),
+		})
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse semaphore set list: %v", err)
+	}
+
+	return sets, nil
+}
+
+// Returns the semaphore set with the same key as this segment, creating it with the given number of
+// semaphores (and the same permissions as the segment) if it does not already exist.  This follows
+// the convention used by classic SysV programs, which pair each segment with a semaphore set that
+// guards it.  Segments created with the IpcPrivate key have no companion set.
+//
+func (self *Segment) Semaphores(count int) (*SemaphoreSet, error) {
+	if self.Key == IpcPrivate {
+		return nil, fmt.Errorf("Segment %d has no key to share with a semaphore set", self.Id)
+	}
+
+	info, err := self.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return OpenSemaphoreSetWithKey(self.Key, count, IpcCreate, info.Mode)
+}
+
+// Returns the number of semaphores in the set.
+func (self *SemaphoreSet) Len() int {
+	return self.count
+}
+
+// Performs the given operations atomically: either all of them are performed, or (if any of them
+// would block) the process waits until they all can be.  Operations with the SemNoWait flag fail
+// with ErrWouldBlock instead of waiting.
+//
+func (self *SemaphoreSet) Op(ops ...SemOp) error {
+	return self.OpTimeout(0, ops...)
+}
+
+// Performs the given operations atomically as with Op(), but gives up with ErrWouldBlock if they
+// cannot be performed within the given timeout.  A timeout of zero or less waits indefinitely.
+//
+func (self *SemaphoreSet) OpTimeout(timeout time.Duration, ops ...SemOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	var deadline time.Time
+
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for {
+		var ts *syscall.Timespec
+
+		if timeout > 0 {
+			remaining := time.Until(deadline)
+
+			if remaining <= 0 {
+				return semError(`semop`, self.Id, self.Key, syscall.EAGAIN)
+			}
+
+			t := syscall.NsecToTimespec(int64(remaining))
+			ts = &t
+		}
+
+		_, _, errno := syscall.Syscall6(
+			syscall.SYS_SEMTIMEDOP,
+			uintptr(self.Id),
+			uintptr(unsafe.Pointer(&ops[0])),
+			uintptr(len(ops)),
+			uintptr(unsafe.Pointer(ts)),
+			0,
+			0,
+		)
+
+		switch errno {
+		case 0:
+			return nil
+		case syscall.EINTR:
+			// interrupted by a signal (which the Go runtime sends routinely); try again
+			continue
+		default:
+			return semError(`semop`, self.Id, self.Key, errno)
+		}
+	}
+}
+
+// Decrements the given semaphore, waiting until its value is greater than zero.
+//
+func (self *SemaphoreSet) Wait(num int) error {
+	return self.Op(SemOp{
+		Num: uint16(num),
+		Op:  -1,
+	})
+}
+
+// Increments the given semaphore, waking any process waiting for it.
+//
+func (self *SemaphoreSet) Post(num int) error {
+	return self.Op(SemOp{
+		Num: uint16(num),
+		Op:  1,
+	})
+}
+
+// Returns the current value of the given semaphore.
+//
+func (self *SemaphoreSet) Value(num int) (int, error) {
+	if v, _, errno := syscall.Syscall6(syscall.SYS_SEMCTL, uintptr(self.Id), uintptr(num), semGetVal, 0, 0, 0); errno == 0 {
+		return int(v), nil
+	} else {
+		return 0, semError(`getval`, self.Id, self.Key, errno)
+	}
+}
+
+// Returns the current values of every semaphore in the set.
+//
+func (self *SemaphoreSet) Values() ([]int, error) {
+	raw := make([]uint16, self.count)
+	values := make([]int, self.count)
+
+	if self.count == 0 {
+		return values, nil
+	}
+
+	if err := semctl(self.Id, 0, semGetAll, uintptr(unsafe.Pointer(&raw[0]))); err != nil {
+		return nil, semError(`getall`, self.Id, self.Key, err)
+	}
+
+	for i, v := range raw {
+		values[i] = int(v)
+	}
+
+	return values, nil
+}
+
+// Sets the value of the given semaphore, waking any processes that can proceed as a result.
+//
+func (self *SemaphoreSet) SetValue(num int, value int) error {
+	if value < 0 || value > 0xffff {
+		return semError(`setval`, self.Id, self.Key, syscall.ERANGE)
+	}
+
+	return semError(`setval`, self.Id, self.Key, semctl(self.Id, num, semSetVal, uintptr(value)))
+}
+
+// Sets the values of every semaphore in the set at once.
+//
+func (self *SemaphoreSet) SetValues(values []int) error {
+	if len(values) != self.count {
+		return fmt.Errorf("Expected %d values for semaphore set %d, got %d", self.count, self.Id, len(values))
+	} else if len(values) == 0 {
+		return nil
+	}
+
+	raw := make([]uint16, len(values))
+
+	for i, v := range values {
+		if v < 0 || v > 0xffff {
+			return semError(`setall`, self.Id, self.Key, syscall.ERANGE)
+		}
+
+		raw[i] = uint16(v)
+	}
+
+	return semError(`setall`, self.Id, self.Key, semctl(self.Id, 0, semSetAll, uintptr(unsafe.Pointer(&raw[0]))))
+}
+
+// Retrieves the current metadata for this set, including the state of each semaphore.
+//
+func (self *SemaphoreSet) Stat() (*SemaphoreSetInfo, error) {
+	return StatSemaphoreSet(self.Id)
+}
+
+// Removes the semaphore set from the system.  Any processes waiting on it are woken, and their
+// operations fail with ErrNotExist.
+//
+func (self *SemaphoreSet) Destroy() error {
+	return semError(`destroy`, self.Id, self.Key, semctl(self.Id, 0, ipcRmid, 0))
+}
+
+func semctl(id int, num int, cmd int, arg uintptr) error {
+	if _, _, errno := syscall.Syscall6(syscall.SYS_SEMCTL, uintptr(id), uintptr(num), uintptr(cmd), arg, 0, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// wraps an error from a semaphore operation in an *Error
+func semError(op string, id int, key Key, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return newObjectError(`semaphore set`, op, id, key, err)
+}
@@ -0,0 +1,14 @@
+package shm
+
+// The kernel's struct semid64_ds, as laid out on x86-64 (which, unlike the generic layout, pads
+// each timestamp).
+type semidDs struct {
+	Perm  ipcPerm
+	Otime int64
+	_     uint64
+	Ctime int64
+	_     uint64
+	Nsems uint64
+	_     uint64
+	_     uint64
+}
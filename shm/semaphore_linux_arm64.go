@@ -0,0 +1,11 @@
+package shm
+
+// The kernel's struct semid64_ds, as laid out on arm64.
+type semidDs struct {
+	Perm  ipcPerm
+	Otime int64
+	Ctime int64
+	Nsems uint64
+	_     uint64
+	_     uint64
+}
@@ -0,0 +1,244 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package shm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func makeSemaphoreSet(t *testing.T, count int, callback func(set *SemaphoreSet) error) {
+	key, err := KeyFromPath(`semaphore_test.go`, DefaultProjectId)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := CreateSemaphoreSet(key, count)
+
+	if err != nil {
+		t.Fatalf("Failed to create semaphore set: %v", err)
+	}
+
+	defer set.Destroy()
+
+	if err := callback(set); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSemaphoreSetValues(t *testing.T) {
+	makeSemaphoreSet(t, 3, func(set *SemaphoreSet) error {
+		if set.Len() != 3 {
+			return fmt.Errorf("Wrong semaphore count; expected: 3, got: %d", set.Len())
+		}
+
+		if err := set.SetValue(1, 5); err != nil {
+			return err
+		}
+
+		if v, err := set.Value(1); err != nil {
+			return err
+		} else if v != 5 {
+			return fmt.Errorf("Wrong value; expected: 5, got: %d", v)
+		}
+
+		if err := set.SetValues([]int{1, 2, 3}); err != nil {
+			return err
+		}
+
+		if values, err := set.Values(); err != nil {
+			return err
+		} else if fmt.Sprint(values) != `[1 2 3]` {
+			return fmt.Errorf("Wrong values; got: %v", values)
+		}
+
+		if err := set.SetValue(0, -1); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize setting a negative value; got: %v", err)
+		}
+
+		if err := set.SetValues([]int{1}); err == nil {
+			return fmt.Errorf("Expected setting the wrong number of values to fail")
+		}
+
+		return nil
+	})
+}
+
+func TestSemaphoreSetInvalidArgument(t *testing.T) {
+	makeSemaphoreSet(t, 1, func(set *SemaphoreSet) error {
+		if err := set.SetValue(99, 1); !errors.Is(err, ErrInvalidArgument) {
+			return fmt.Errorf("Expected ErrInvalidArgument setting a semaphore outside of the set; got: %v", err)
+		} else if errors.Is(err, ErrNotExist) {
+			return fmt.Errorf("A bad semaphore number should not match ErrNotExist")
+		}
+
+		return nil
+	})
+}
+
+func TestSemaphoreSetOp(t *testing.T) {
+	makeSemaphoreSet(t, 2, func(set *SemaphoreSet) error {
+		if err := set.Post(0); err != nil {
+			return err
+		}
+
+		if err := set.Wait(0); err != nil {
+			return err
+		}
+
+		if err := set.Op(SemOp{Num: 0, Op: -1, Flags: SemNoWait}); !errors.Is(err, ErrWouldBlock) {
+			return fmt.Errorf("Expected ErrWouldBlock from a non-blocking wait; got: %v", err)
+		}
+
+		start := time.Now()
+
+		if err := set.OpTimeout(50*time.Millisecond, SemOp{Num: 1, Op: -1}); !errors.Is(err, ErrWouldBlock) {
+			return fmt.Errorf("Expected ErrWouldBlock from a timed out wait; got: %v", err)
+		} else if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			return fmt.Errorf("Timed out wait returned too early, after %v", elapsed)
+		}
+
+		woken := make(chan error)
+
+		go func() {
+			woken <- set.OpTimeout(5*time.Second, SemOp{Num: 1, Op: -2})
+		}()
+
+		time.Sleep(10 * time.Millisecond)
+
+		if info, err := set.Stat(); err != nil {
+			return err
+		} else if info.Semaphores[1].Waiting != 1 {
+			return fmt.Errorf("Expected one process waiting on semaphore 1; got: %d", info.Semaphores[1].Waiting)
+		}
+
+		// both increments are needed to satisfy the waiter, and must happen atomically
+		if err := set.Op(SemOp{Num: 1, Op: 1}, SemOp{Num: 1, Op: 1}); err != nil {
+			return err
+		}
+
+		if err := <-woken; err != nil {
+			return fmt.Errorf("Waiter failed: %v", err)
+		}
+
+		if info, err := set.Stat(); err != nil {
+			return err
+		} else if info.Semaphores[1].Value != 0 || info.Semaphores[1].LastPID != os.Getpid() {
+			return fmt.Errorf("Wrong semaphore state after wait: %+v", info.Semaphores[1])
+		} else if info.LastOp.IsZero() {
+			return fmt.Errorf("Expected the last operation time to be set")
+		}
+
+		return nil
+	})
+}
+
+func TestSemaphoreSetOpenAndList(t *testing.T) {
+	makeSemaphoreSet(t, 1, func(set *SemaphoreSet) error {
+		if _, err := CreateSemaphoreSet(set.Key, 1); !errors.Is(err, ErrExists) {
+			return fmt.Errorf("Expected ErrExists creating an existing set; got: %v", err)
+		}
+
+		if other, err := OpenSemaphoreSetByKey(set.Key); err != nil {
+			return err
+		} else if other.Id != set.Id || other.Len() != 1 {
+			return fmt.Errorf("Opened the wrong set: %d (%d semaphores)", other.Id, other.Len())
+		}
+
+		if other, err := OpenSemaphoreSet(set.Id); err != nil {
+			return err
+		} else if other.Key != set.Key {
+			return fmt.Errorf("Wrong key; expected: %v, got: %v", set.Key, other.Key)
+		}
+
+		if sets, err := ListSemaphoreSets(); err != nil {
+			return err
+		} else {
+			for _, info := range sets {
+				if info.Id == set.Id {
+					if info.Key != set.Key || info.Count != 1 || info.Mode != 0600 {
+						return fmt.Errorf("Wrong listing: %+v", info)
+					}
+
+					return nil
+				}
+			}
+
+			return fmt.Errorf("Semaphore set %d was not listed", set.Id)
+		}
+	})
+}
+
+func TestSemaphoreSetDestroy(t *testing.T) {
+	set, err := CreateSemaphoreSet(IpcPrivate, 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := set.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenSemaphoreSet(set.Id); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist opening a destroyed set; got: %v", err)
+	}
+
+	if _, err := OpenSemaphoreSetByKey(Key(0x7ffffffd)); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist opening an unused key; got: %v", err)
+	}
+}
+
+func TestSegmentSemaphores(t *testing.T) {
+	key, err := KeyFromPath(`semaphore.go`, DefaultProjectId)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segment, err := OpenSegmentWithKey(key, 1024, (IpcCreate | IpcExclusive), 0640)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer segment.Destroy()
+
+	set, err := segment.Semaphores(2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer set.Destroy()
+
+	if set.Key != segment.Key || set.Len() != 2 {
+		t.Errorf("Wrong companion set: key %v, %d semaphores", set.Key, set.Len())
+	}
+
+	if info, err := set.Stat(); err != nil {
+		t.Fatal(err)
+	} else if info.Mode != 0640 {
+		t.Errorf("Companion set should share the segment's permissions; got: %v", info.Mode)
+	}
+
+	if again, err := segment.Semaphores(2); err != nil {
+		t.Fatal(err)
+	} else if again.Id != set.Id {
+		t.Errorf("Expected the existing companion set to be reused")
+	}
+
+	makeSegment(t, 1024, func(private *Segment) error {
+		if _, err := private.Semaphores(1); err == nil {
+			return fmt.Errorf("Expected a private segment to have no companion set")
+		}
+
+		return nil
+	})
+}
@@ -1,21 +1,49 @@
+//go:build cgo
+// +build cgo
+
+#include <errno.h>
 #include "shm.h"
 
-int sysv_shm_open(int size, int flags, int perm) {
-    int shm_id;
+// glibc (and uClibc, which defines __GLIBC__ too) names the key member of struct ipc_perm __key.
+// musl declares it as __ipc_perm_key, and only aliases that to __key or key depending on which
+// feature macros are in effect, so use the name it is declared with.  macOS calls it _key, and the
+// BSDs call it key.
+#if defined(__GLIBC__)
+#define IPC_PERM_KEY(perm) ((perm).__key)
+#elif defined(__linux__)
+#define IPC_PERM_KEY(perm) ((perm).__ipc_perm_key)
+#elif defined(__APPLE__)
+#define IPC_PERM_KEY(perm) ((perm)._key)
+#else
+#define IPC_PERM_KEY(perm) ((perm).key)
+#endif
+
+int sysv_shm_open(size_t size, int flags, int perm) {
+    return sysv_shm_open_key(IPC_PRIVATE, size, flags, perm);
+}
 
+int sysv_shm_open_key(int key, size_t size, int flags, int perm) {
     if(size) {
         // unless otherwise specified, segment is owner-read/write (no exec)
         if(!perm){
             perm = 0600;
         }
 
-        return shmget(IPC_PRIVATE, size, flags|perm);
+        return shmget((key_t)key, size, flags|perm);
     } else {
-        return shmget(IPC_PRIVATE, size, 0);
+        return shmget((key_t)key, size, 0);
     }
 }
 
-int sysv_shm_write(int shm_id, void* input, int len, int offset) {
+int sysv_shm_ftok(const char *path, int proj_id) {
+    return (int)ftok(path, proj_id);
+}
+
+int sysv_shm_write(int shm_id, void* input, size_t len, off_t offset) {
+    if(sysv_shm_check_range(shm_id, len, offset) < 0) {
+        return -1;
+    }
+
     // attach to the given segment to get its memory address
     char* addr = sysv_shm_attach(shm_id);
 
@@ -33,14 +61,22 @@ int sysv_shm_write(int shm_id, void* input, int len, int offset) {
 }
 
 void *sysv_shm_attach(int shm_id) {
-    return shmat(shm_id, NULL, 0);
+    return sysv_shm_attach_at(shm_id, 0, 0);
+}
+
+void *sysv_shm_attach_at(int shm_id, uintptr_t addr, int flags) {
+    return shmat(shm_id, (const void *)addr, flags);
 }
 
 int sysv_shm_detach(void *addr) {
     return shmdt(addr);
 }
 
-int sysv_shm_read(int shm_id, void* output, int len, int offset) {
+int sysv_shm_read(int shm_id, void* output, size_t len, off_t offset) {
+    if(sysv_shm_check_range(shm_id, len, offset) < 0) {
+        return -1;
+    }
+
     // attach to the given segment to get its memory address
     char* addr = sysv_shm_attach(shm_id);
 
@@ -57,12 +93,40 @@ int sysv_shm_read(int shm_id, void* output, int len, int offset) {
     return 0;
 }
 
+// fails with ERANGE unless [offset, offset+len) lies entirely within the segment
+int sysv_shm_check_range(int shm_id, size_t len, off_t offset) {
+    size_t size = sysv_shm_get_size(shm_id);
+
+    if(size == (size_t)(-1)) {
+        return -1;
+    }
+
+    // written so that neither side can overflow, even for multi-gigabyte segments
+    if(offset < 0 || (size_t)offset > size || len > size - (size_t)offset) {
+        errno = ERANGE;
+        return -1;
+    }
+
+    return 0;
+}
+
+// SHM_LOCK and SHM_UNLOCK are extensions that not every system provides (macOS doesn't)
 int sysv_shm_lock(int shm_id) {
+#ifdef SHM_LOCK
     return shmctl(shm_id, SHM_LOCK, NULL);
+#else
+    errno = ENOSYS;
+    return -1;
+#endif
 }
 
 int sysv_shm_unlock(int shm_id) {
+#ifdef SHM_UNLOCK
     return shmctl(shm_id, SHM_UNLOCK, NULL);
+#else
+    errno = ENOSYS;
+    return -1;
+#endif
 }
 
 int sysv_shm_close(int shm_id) {
@@ -78,3 +142,50 @@ size_t sysv_shm_get_size(int shm_id) {
         return -1;
     }
 }
+
+int sysv_shm_stat(int shm_id, sysv_shm_info_t *info) {
+    struct shmid_ds shm;
+
+    if(shmctl(shm_id, IPC_STAT, &shm) < 0) {
+        return -1;
+    }
+
+    info->key    = IPC_PERM_KEY(shm.shm_perm);
+    info->uid    = shm.shm_perm.uid;
+    info->gid    = shm.shm_perm.gid;
+    info->cuid   = shm.shm_perm.cuid;
+    info->cgid   = shm.shm_perm.cgid;
+    info->mode   = shm.shm_perm.mode;
+    info->size   = shm.shm_segsz;
+    info->atime  = shm.shm_atime;
+    info->dtime  = shm.shm_dtime;
+    info->ctime  = shm.shm_ctime;
+    info->cpid   = shm.shm_cpid;
+    info->lpid   = shm.shm_lpid;
+    info->nattch = shm.shm_nattch;
+
+    return 0;
+}
+
+int sysv_shm_set(int shm_id, int uid, int gid, int mode) {
+    struct shmid_ds shm;
+
+    if(shmctl(shm_id, IPC_STAT, &shm) < 0) {
+        return -1;
+    }
+
+    // negative values leave the corresponding field unchanged
+    if(uid >= 0) {
+        shm.shm_perm.uid = uid;
+    }
+
+    if(gid >= 0) {
+        shm.shm_perm.gid = gid;
+    }
+
+    if(mode >= 0) {
+        shm.shm_perm.mode = (shm.shm_perm.mode & ~0777) | (mode & 0777);
+    }
+
+    return shmctl(shm_id, IPC_SET, &shm);
+}
@@ -13,13 +13,14 @@
 //
 package shm
 
-// #include "shm.h"
-import "C"
-
 import (
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"strconv"
+	"syscall"
 	"unsafe"
 )
 
@@ -27,17 +28,98 @@ const Version = `0.0.4`
 
 type SharedMemoryFlags int
 
+// Values are those from <sys/ipc.h> and <sys/shm.h> on Linux, and are shared by the cgo and
+// pure-Go implementations of this package.
 const (
 	IpcNone                        = 0
-	IpcCreate    SharedMemoryFlags = C.IPC_CREAT
-	IpcExclusive                   = C.IPC_EXCL
-	HugePages                      = C.SHM_HUGETLB
-	NoReserve                      = C.SHM_NORESERVE
+	IpcCreate    SharedMemoryFlags = 01000
+	IpcExclusive                   = 02000
+	HugePages                      = 04000
+	NoReserve                      = 010000
+)
+
+const (
+	shmReadOnly = 010000
+	shmRound    = 020000
+	shmRemap    = 040000
+	shmExec     = 0100000
+)
+
+// The project identifier used by KeyFromPath when none is given.
+const DefaultProjectId = 0x42
+
+// An IPC key used to locate a shared memory segment without knowing its ID.  Unrelated processes
+// that agree on a key (either a well-known constant or one derived from a file path with
+// KeyFromPath) can all create or open the same segment.
+type Key int
+
+// The special key that always creates a new segment which can only be located by its ID.
+const IpcPrivate Key = 0
+
+// Returns the key in the hexadecimal notation used by ipcs(1).
+func (self Key) String() string {
+	return fmt.Sprintf("0x%08x", uint32(self))
+}
+
+// Encodes the key in the same notation as String(), so that keys look the same in JSON output as they
+// do elsewhere and can be passed back to ParseKey.
+func (self Key) MarshalText() ([]byte, error) {
+	return []byte(self.String()), nil
+}
+
+// Decodes a key in any of the notations accepted by ParseKey.
+func (self *Key) UnmarshalText(text []byte) error {
+	if key, err := ParseKey(string(text)); err == nil {
+		*self = key
+		return nil
+	} else {
+		return err
+	}
+}
+
+// Parses a key from a string, accepting decimal, hexadecimal (0x), and octal (0) notation.
+//
+func ParseKey(value string) (Key, error) {
+	if k, err := strconv.ParseInt(value, 0, 64); err == nil {
+		if k < math.MinInt32 || k > math.MaxUint32 {
+			return IpcPrivate, fmt.Errorf("Key %q is out of range", value)
+		}
+
+		return Key(int32(k)), nil
+	} else {
+		return IpcPrivate, fmt.Errorf("Invalid key %q: %v", value, err)
+	}
+}
+
+// Generates a key from an existing, accessible file and a project identifier, using the same
+// semantics as ftok(3).  Only the lower 8 bits of projId are significant, and they must not all be
+// zero (use DefaultProjectId if there is no other to choose).  The file must not be deleted and
+// recreated between calls if the key is expected to remain stable.
+//
+func KeyFromPath(path string, projId int) (Key, error) {
+	if projId&0xff == 0 {
+		return IpcPrivate, fmt.Errorf("Invalid project ID %d: the lower 8 bits must not be zero: %w", projId, ErrInvalidArgument)
+	} else if key, err := ftok(path, projId); err == nil {
+		return key, nil
+	} else {
+		return IpcPrivate, fmt.Errorf("Failed to generate key from %q: %w", path, err)
+	}
+}
+
+var (
+	// Matches errors returned by Lock() and Unlock() when the caller lacks the CAP_IPC_LOCK capability and is not
+	// permitted to lock the segment without it.
+	ErrLockPermission = errors.New(`not permitted to lock segment (requires CAP_IPC_LOCK or segment ownership)`)
+
+	// Matches errors returned by Lock() when locking the segment would exceed the caller's
+	// RLIMIT_MEMLOCK.
+	ErrLockLimit = errors.New(`locking segment would exceed RLIMIT_MEMLOCK`)
 )
 
 // A native representation of a SysV shared memory segment
 type Segment struct {
 	Id     int
+	Key    Key
 	Size   int64
 	offset int64
 }
@@ -49,73 +131,182 @@ func Create(size int) (*Segment, error) {
 	return OpenSegment(size, (IpcCreate | IpcExclusive), 0600)
 }
 
+// Create a new shared memory segment with the given size (in bytes), identified by the given key.
+// This will fail if a segment already exists for this key.
+//
+func CreateWithKey(key Key, size int) (*Segment, error) {
+	return OpenSegmentWithKey(key, size, (IpcCreate | IpcExclusive), 0600)
+}
+
 // Open an existing shared memory segment located at the given ID.  This ID is returned in the
 // struct that is populated by Create(), or by the shmget() system call.
 //
 func Open(id int) (*Segment, error) {
-	if sz, err := C.sysv_shm_get_size(C.int(id)); err == nil {
+	if info, err := Stat(id); err == nil {
 		return &Segment{
 			Id:   id,
-			Size: int64(sz),
+			Key:  info.Key,
+			Size: info.Size,
 		}, nil
 	} else {
 		return nil, err
 	}
 }
 
+// Open an existing shared memory segment by its key.
+//
+func OpenByKey(key Key) (*Segment, error) {
+	if key == IpcPrivate {
+		return nil, newError(`open`, -1, key, syscall.ENOENT)
+	}
+
+	return OpenSegmentWithKey(key, 0, IpcNone, 0)
+}
+
 // Creates a shared memory segment of a given size, and also allows for the specification of
 // creation flags supported by the shmget() call, as well as specifying permissions.
 //
 func OpenSegment(size int, flags SharedMemoryFlags, perms os.FileMode) (*Segment, error) {
-	if shmid, err := C.sysv_shm_open(C.int(size), C.int(flags), C.int(perms)); err == nil {
-		if actual_size, err := C.sysv_shm_get_size(shmid); err != nil {
-			return nil, fmt.Errorf("Failed to retrieve SHM size: %v", err)
+	return OpenSegmentWithKey(IpcPrivate, size, flags, perms)
+}
+
+// Creates or opens the shared memory segment identified by the given key.  If size is zero, an
+// existing segment is opened and flags and perms are ignored.  If flags includes IpcCreate, a segment
+// is created if one does not already exist for this key (or, with IpcExclusive, fails if it does).
+//
+func OpenSegmentWithKey(key Key, size int, flags SharedMemoryFlags, perms os.FileMode) (*Segment, error) {
+	if size > 0 && perms == 0 {
+		// unless otherwise specified, segment is owner-read/write (no exec)
+		perms = 0600
+	} else if size == 0 {
+		flags = IpcNone
+		perms = 0
+	}
+
+	op := `open`
+
+	if flags&IpcCreate != 0 {
+		op = `create`
+	}
+
+	if shmid, err := shmget(key, size, int(flags)|int(perms.Perm())); err == nil {
+		if info, err := shmstat(shmid); err != nil {
+			return nil, newError(`stat`, shmid, key, err)
 		} else {
 			return &Segment{
-				Id:   int(shmid),
-				Size: int64(actual_size),
+				Id:   shmid,
+				Key:  key,
+				Size: info.Size,
 			}, nil
 		}
 
 	} else {
-		return nil, err
+		return nil, newError(op, -1, key, err)
+	}
+}
+
+// Retrieve the metadata for the shared memory segment with the given ID.
+//
+func Stat(id int) (*SegmentInfo, error) {
+	if info, err := shmstat(id); err == nil {
+		info.Id = id
+		info.URI = segmentURI(id)
+		return info, nil
+	} else {
+		return nil, newError(`stat`, id, IpcPrivate, err)
 	}
 }
 
+func segmentURI(id int) string {
+	return `sysv:` + strconv.Itoa(id)
+}
+
 // Destroy a shared memory segment by its ID
 //
 func DestroySegment(id int) error {
-	_, err := C.sysv_shm_close(C.int(id))
-	return err
+	return newError(`destroy`, id, IpcPrivate, shmrmid(id))
 }
 
-// Read some or all of the shared memory segment and return a byte slice.
+// Read some or all of the shared memory segment and return a byte slice.  If length is negative,
+// everything from start to the end of the segment is read.  The range must lie entirely within the
+// segment.
 //
 func (self *Segment) ReadChunk(length int64, start int64) ([]byte, error) {
+	if start < 0 || start > self.Size {
+		return nil, self.error(`read`, syscall.ERANGE)
+	}
+
 	if length < 0 {
-		length = self.Size
+		length = self.Size - start
+	} else if length > self.Size-start {
+		return nil, self.error(`read`, syscall.ERANGE)
 	}
 
-	buffer := C.malloc(C.size_t(length))
+	buffer := make([]byte, length)
 
-	if buffer == nil {
-		return nil, fmt.Errorf("malloc failed")
+	if err := shmread(self.Id, buffer, start); err != nil {
+		return nil, self.error(`read`, err)
 	}
 
-	defer C.free(buffer)
+	return buffer, nil
+}
 
-	if _, err := C.sysv_shm_read(C.int(self.Id), buffer, C.int(length), C.int(start)); err != nil {
-		return nil, err
+// Implements the io.ReaderAt interface for shared memory.  Unlike Read(), this does not use or
+// modify the current position, so it is safe to call from multiple goroutines.
+//
+func (self *Segment) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Cannot read from negative offset %d: %w", off, ErrInvalidArgument)
+	} else if off >= self.Size {
+		return 0, io.EOF
 	}
 
-	return C.GoBytes(buffer, C.int(length)), nil
+	length := int64(len(p))
+
+	if off+length > self.Size {
+		length = self.Size - off
+	}
+
+	if err := shmread(self.Id, p[:length], off); err != nil {
+		return 0, self.error(`read`, err)
+	} else if length < int64(len(p)) {
+		return int(length), io.EOF
+	}
+
+	return int(length), nil
+}
+
+// Implements the io.WriterAt interface for shared memory.  Unlike Write(), this does not use or
+// modify the current position, so it is safe to call from multiple goroutines.  Writes that would
+// extend beyond the end of the segment are truncated and return io.EOF.
+//
+func (self *Segment) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("Cannot write to negative offset %d: %w", off, ErrInvalidArgument)
+	} else if off >= self.Size {
+		return 0, io.EOF
+	}
+
+	length := int64(len(p))
+
+	if off+length > self.Size {
+		length = self.Size - off
+	}
+
+	if err := shmwrite(self.Id, p[:length], off); err != nil {
+		return 0, self.error(`write`, err)
+	} else if length < int64(len(p)) {
+		return int(length), io.EOF
+	}
+
+	return int(length), nil
 }
 
 // Implements the io.Reader interface for shared memory
 //
 func (self *Segment) Read(p []byte) (n int, err error) {
 	if self.Id < 0 {
-		return 0, fmt.Errorf("Cannot read shared memory segment: SHMID not set")
+		return 0, fmt.Errorf("Cannot read shared memory segment: SHMID not set: %w", ErrInvalidArgument)
 	}
 
 	// if the offset runs past the segment size, we've reached the end
@@ -135,24 +326,16 @@ func (self *Segment) Read(p []byte) (n int, err error) {
 		length = self.Size - self.offset
 	}
 
-	buffer := C.malloc(C.size_t(length))
-
-	if buffer == nil {
-		return 0, fmt.Errorf("malloc failed")
+	if length <= 0 {
+		return 0, io.EOF
 	}
 
-	defer C.free(buffer)
-
-	if _, err := C.sysv_shm_read(C.int(self.Id), buffer, C.int(length), C.int(self.offset)); err != nil {
-		return 0, err
+	if err := shmread(self.Id, p[:length], self.offset); err != nil {
+		return 0, self.error(`read`, err)
 	}
 
-	if v := copy(p, C.GoBytes(buffer, C.int(length))); v > 0 {
-		self.offset += int64(v)
-		return v, nil
-	} else {
-		return v, io.EOF
-	}
+	self.offset += length
+	return int(length), nil
 }
 
 // Implements the io.Writer interface for shared memory
@@ -175,8 +358,12 @@ func (self *Segment) Write(p []byte) (n int, err error) {
 		length = self.Size - self.offset
 	}
 
-	if _, err := C.sysv_shm_write(C.int(self.Id), unsafe.Pointer(&p[0]), C.int(length), C.int(self.offset)); err != nil {
-		return 0, err
+	if length <= 0 {
+		return 0, nil
+	}
+
+	if err := shmwrite(self.Id, p[:length], self.offset); err != nil {
+		return 0, self.error(`write`, err)
 	} else {
 		self.offset += length
 		return int(length), nil
@@ -206,13 +393,19 @@ func (self *Segment) Seek(offset int64, whence int) (int64, error) {
 	}
 
 	if computedOffset < 0 {
-		return 0, fmt.Errorf("Cannot seek to position before start of segment")
+		return 0, fmt.Errorf("Cannot seek to position before start of segment: %w", ErrInvalidArgument)
 	}
 
 	self.offset = computedOffset
 	return self.offset, nil
 }
 
+// Returns the size of the segment (in bytes), which is the same as its Size field.
+//
+func (self *Segment) Len() int64 {
+	return self.Size
+}
+
 // Returns the current position of the Read/Write pointer.
 //
 func (self *Segment) Position() int64 {
@@ -224,18 +417,131 @@ func (self *Segment) Position() int64 {
 // for use with third party libraries that can directly read from memory.
 //
 func (self *Segment) Attach() (unsafe.Pointer, error) {
-	if addr, err := C.sysv_shm_attach(C.int(self.Id)); err == nil {
-		return unsafe.Pointer(addr), nil
+	return self.AttachWith(AttachOptions{})
+}
+
+// Options that control how a segment is attached to the current process.
+type AttachOptions struct {
+	// Attach the segment read-only.  Any attempt to write to the attached memory will cause a
+	// segmentation fault, protecting the segment from accidental modification.
+	ReadOnly bool
+
+	// Attach the segment at this address rather than letting the system choose one.  This allows
+	// cooperating processes to share structures containing pointers into the segment.  The address
+	// must be page-aligned unless Round is set.
+	Address uintptr
+
+	// Round Address down to the nearest multiple of SHMLBA.
+	Round bool
+
+	// Replace any existing mapping at Address instead of failing (Linux only).  Requires Address.
+	Remap bool
+
+	// Allow the contents of the segment to be executed (Linux only).
+	Exec bool
+}
+
+func (self AttachOptions) flags() int {
+	var flags int
+
+	if self.ReadOnly {
+		flags |= shmReadOnly
+	}
+
+	if self.Round {
+		flags |= shmRound
+	}
+
+	if self.Remap {
+		flags |= shmRemap
+	}
+
+	if self.Exec {
+		flags |= shmExec
+	}
+
+	return flags
+}
+
+// Attaches the segment to the current process's memory using the given options, returning the
+// address the segment was attached at.
+//
+func (self *Segment) AttachWith(opts AttachOptions) (unsafe.Pointer, error) {
+	if opts.Remap && opts.Address == 0 {
+		return nil, fmt.Errorf("Cannot remap a segment without specifying an address: %w", ErrInvalidArgument)
+	}
+
+	if addr, err := shmat(self.Id, opts.Address, opts.flags()); err == nil {
+		return addr, nil
 	} else {
-		return nil, err
+		return nil, self.error(`attach`, err)
 	}
 }
 
 // Detaches the segment from the current processes memory space.
 //
 func (self *Segment) Detach(addr unsafe.Pointer) error {
-	_, err := C.sysv_shm_detach(addr)
-	return err
+	return self.error(`detach`, shmdt(addr))
+}
+
+// Retrieves the current metadata for this segment, including its owner, permissions, and the
+// number of processes currently attached to it.
+//
+func (self *Segment) Stat() (*SegmentInfo, error) {
+	return Stat(self.Id)
+}
+
+// Changes the permissions of the segment.  Only the permission bits of mode are used.  The caller
+// must be the segment's owner or creator, or have the CAP_SYS_ADMIN capability.
+//
+func (self *Segment) Chmod(mode os.FileMode) error {
+	return self.error(`chmod`, shmset(self.Id, -1, -1, int(mode.Perm())))
+}
+
+// Changes the owning user and group of the segment.  A uid or gid of -1 leaves that value
+// unchanged.  The creator's UID and GID are not affected.  The caller must be the segment's owner or
+// creator, or have the CAP_SYS_ADMIN capability.
+//
+func (self *Segment) Chown(uid int, gid int) error {
+	return self.error(`chown`, shmset(self.Id, uid, gid, -1))
+}
+
+// Locks the segment's pages into physical memory, preventing them from being swapped out.  This
+// requires the CAP_IPC_LOCK capability, or that the caller owns the segment and the segment fits
+// within their RLIMIT_MEMLOCK.  Locking does not guarantee that every page is resident; pages are
+// only brought into memory as they are first accessed.  Failures can be tested for with
+// errors.Is(err, ErrLockPermission) and errors.Is(err, ErrLockLimit).
+//
+func (self *Segment) Lock() error {
+	return self.error(`lock`, shmlock(self.Id, true))
+}
+
+// Unlocks the segment's pages, allowing them to be swapped out again.
+//
+func (self *Segment) Unlock() error {
+	return self.error(`unlock`, shmlock(self.Id, false))
+}
+
+// wraps an error from a low-level operation on this segment in an *Error
+func (self *Segment) error(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return newError(op, self.Id, self.Key, err)
+}
+
+// Returns metadata about the segment; this is equivalent to Stat().
+//
+func (self *Segment) Info() (*SegmentInfo, error) {
+	return self.Stat()
+}
+
+// Segments hold no resources in the current process (other than attachments, which are released
+// with Detach), so this has no effect.  It exists to satisfy the Region interface.
+//
+func (self *Segment) Close() error {
+	return nil
 }
 
 // Destroys the current shared memory segment.
@@ -1,19 +1,42 @@
 #ifndef SHM_H
 #define SHM_H
+#include <stdint.h>
 #include <string.h>
 #include <stdlib.h>
 #include <sys/shm.h>
 #include <sys/types.h>
 #include <sys/ipc.h>
 
-#define IPC_KEY_PROJID 0x42
+// a flattened copy of the fields of struct shmid_ds, so that the Go side doesn't depend on the
+// libc-specific naming of the members of struct ipc_perm (shm.c deals with that)
+typedef struct {
+    int           key;
+    unsigned int  uid;
+    unsigned int  gid;
+    unsigned int  cuid;
+    unsigned int  cgid;
+    unsigned int  mode;
+    size_t        size;
+    long          atime;
+    long          dtime;
+    long          ctime;
+    int           cpid;
+    int           lpid;
+    unsigned long nattch;
+} sysv_shm_info_t;
 
-int sysv_shm_open(int size, int flags, int perm);
+int sysv_shm_open(size_t size, int flags, int perm);
+int sysv_shm_open_key(int key, size_t size, int flags, int perm);
+int sysv_shm_ftok(const char *path, int proj_id);
 void *sysv_shm_attach(int shm_id);
+void *sysv_shm_attach_at(int shm_id, uintptr_t addr, int flags);
 int sysv_shm_detach(void *addr);
-int sysv_shm_write(int shm_id, void* input, int len, int offset);
-int sysv_shm_read(int shm_id, void* output, int len, int offset);
+int sysv_shm_write(int shm_id, void* input, size_t len, off_t offset);
+int sysv_shm_read(int shm_id, void* output, size_t len, off_t offset);
+int sysv_shm_check_range(int shm_id, size_t len, off_t offset);
 size_t sysv_shm_get_size(int shm_id);
+int sysv_shm_stat(int shm_id, sysv_shm_info_t *info);
+int sysv_shm_set(int shm_id, int uid, int gid, int mode);
 int sysv_shm_lock(int shm_id);
 int sysv_shm_unlock(int shm_id);
 int sysv_shm_close(int shm_id);
@@ -103,3 +103,53 @@ func BenchmarkReadChunk_Buf4KUHD(b *testing.B) { benchmarkReadChunkFull(8294400,
 func BenchmarkReadChunk_10MB(b *testing.B)     { benchmarkReadChunkFull(10485760, b) }
 func BenchmarkReadChunk_100MB(b *testing.B)    { benchmarkReadChunkFull(104857600, b) }
 func BenchmarkReadChunk_1GB(b *testing.B)      { benchmarkReadChunkFull(1073741824, b) }
+
+// Full Read: Persistent Mapping
+func benchmarkReadMapping(size int, b *testing.B) {
+	segment, _ := Create(size)
+	segmentId = segment.Id
+	data = make([]byte, size)
+	mapping, _ := segment.Map(AttachOptions{ReadOnly: true})
+
+	for n := 0; n < b.N; n++ {
+		mapping.ReadAt(data, 0)
+	}
+
+	mapping.Close()
+	segment.Destroy()
+}
+
+func BenchmarkReadMapping_1B(b *testing.B)       { benchmarkReadMapping(1, b) }
+func BenchmarkReadMapping_1KB(b *testing.B)      { benchmarkReadMapping(1024, b) }
+func BenchmarkReadMapping_4KB(b *testing.B)      { benchmarkReadMapping(4096, b) }
+func BenchmarkReadMapping_1MB(b *testing.B)      { benchmarkReadMapping(1048576, b) }
+func BenchmarkReadMapping_Buf1080p(b *testing.B) { benchmarkReadMapping(2073600, b) }
+func BenchmarkReadMapping_Buf4KUHD(b *testing.B) { benchmarkReadMapping(8294400, b) }
+func BenchmarkReadMapping_10MB(b *testing.B)     { benchmarkReadMapping(10485760, b) }
+func BenchmarkReadMapping_100MB(b *testing.B)    { benchmarkReadMapping(104857600, b) }
+func BenchmarkReadMapping_1GB(b *testing.B)      { benchmarkReadMapping(1073741824, b) }
+
+// Full Read: WriterTo
+func benchmarkReadWriteTo(size int, b *testing.B) {
+	segment, _ := Create(size)
+	segmentId = segment.Id
+	buffer := bytes.NewBuffer(make([]byte, 0, size))
+
+	for n := 0; n < b.N; n++ {
+		buffer.Reset()
+		segment.Reset()
+		segment.WriteTo(buffer)
+	}
+
+	segment.Destroy()
+}
+
+func BenchmarkReadWriteTo_1B(b *testing.B)       { benchmarkReadWriteTo(1, b) }
+func BenchmarkReadWriteTo_1KB(b *testing.B)      { benchmarkReadWriteTo(1024, b) }
+func BenchmarkReadWriteTo_4KB(b *testing.B)      { benchmarkReadWriteTo(4096, b) }
+func BenchmarkReadWriteTo_1MB(b *testing.B)      { benchmarkReadWriteTo(1048576, b) }
+func BenchmarkReadWriteTo_Buf1080p(b *testing.B) { benchmarkReadWriteTo(2073600, b) }
+func BenchmarkReadWriteTo_Buf4KUHD(b *testing.B) { benchmarkReadWriteTo(8294400, b) }
+func BenchmarkReadWriteTo_10MB(b *testing.B)     { benchmarkReadWriteTo(10485760, b) }
+func BenchmarkReadWriteTo_100MB(b *testing.B)    { benchmarkReadWriteTo(104857600, b) }
+func BenchmarkReadWriteTo_1GB(b *testing.B)      { benchmarkReadWriteTo(1073741824, b) }
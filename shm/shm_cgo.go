@@ -0,0 +1,139 @@
+//go:build cgo
+// +build cgo
+
+package shm
+
+// #include "shm.h"
+import "C"
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// This file implements the low-level segment operations by calling into the C implementation in
+// shm.c.  It is used whenever cgo is enabled; see shm_nocgo.go for the pure-Go equivalent.
+
+func shmget(key Key, size int, flags int) (int, error) {
+	if size < 0 {
+		return -1, syscall.EINVAL
+	}
+
+	if shmid, err := C.sysv_shm_open_key(C.int(key), C.size_t(size), C.int(flags&^0777), C.int(flags&0777)); shmid >= 0 {
+		return int(shmid), nil
+	} else {
+		return -1, err
+	}
+}
+
+func shmat(id int, at uintptr, flags int) (unsafe.Pointer, error) {
+	if addr, err := C.sysv_shm_attach_at(C.int(id), C.uintptr_t(at), C.int(flags)); uintptr(addr) != ^uintptr(0) {
+		return unsafe.Pointer(addr), nil
+	} else {
+		return nil, err
+	}
+}
+
+func shmdt(addr unsafe.Pointer) error {
+	if rc, err := C.sysv_shm_detach(addr); rc < 0 {
+		return err
+	}
+
+	return nil
+}
+
+func shmread(id int, p []byte, offset int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	if rc, err := C.sysv_shm_read(C.int(id), unsafe.Pointer(&p[0]), C.size_t(len(p)), C.off_t(offset)); rc < 0 {
+		return err
+	}
+
+	return nil
+}
+
+func shmwrite(id int, p []byte, offset int64) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	if rc, err := C.sysv_shm_write(C.int(id), unsafe.Pointer(&p[0]), C.size_t(len(p)), C.off_t(offset)); rc < 0 {
+		return err
+	}
+
+	return nil
+}
+
+func shmstat(id int) (*SegmentInfo, error) {
+	var stat C.sysv_shm_info_t
+
+	if rc, err := C.sysv_shm_stat(C.int(id), &stat); rc == 0 {
+		info := &SegmentInfo{
+			Id:           id,
+			Key:          Key(stat.key),
+			Size:         int64(stat.size),
+			OwnerUID:     int(stat.uid),
+			OwnerGID:     int(stat.gid),
+			CreatorUID:   int(stat.cuid),
+			CreatorGID:   int(stat.cgid),
+			Attached:     int(stat.nattch),
+			CreatorPID:   int(stat.cpid),
+			LastPID:      int(stat.lpid),
+			LastAttached: unixTime(int64(stat.atime)),
+			LastDetached: unixTime(int64(stat.dtime)),
+			LastChanged:  unixTime(int64(stat.ctime)),
+		}
+
+		info.setMode(uint32(stat.mode))
+
+		return info, nil
+	} else {
+		return nil, err
+	}
+}
+
+func shmset(id int, uid int, gid int, mode int) error {
+	if rc, err := C.sysv_shm_set(C.int(id), C.int(uid), C.int(gid), C.int(mode)); rc < 0 {
+		return err
+	}
+
+	return nil
+}
+
+func shmrmid(id int) error {
+	if rc, err := C.sysv_shm_close(C.int(id)); rc < 0 {
+		return err
+	}
+
+	return nil
+}
+
+func shmlock(id int, lock bool) error {
+	var rc C.int
+	var err error
+
+	if lock {
+		rc, err = C.sysv_shm_lock(C.int(id))
+	} else {
+		rc, err = C.sysv_shm_unlock(C.int(id))
+	}
+
+	if rc < 0 {
+		return err
+	}
+
+	return nil
+}
+
+func ftok(path string, projId int) (Key, error) {
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+
+	if key, err := C.sysv_shm_ftok(cpath, C.int(projId)); key != -1 {
+		return Key(key), nil
+	} else {
+		return IpcPrivate, err
+	}
+}
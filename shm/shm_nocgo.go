@@ -0,0 +1,165 @@
+//go:build !cgo && linux
+// +build !cgo
+// +build linux
+
+package shm
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// This file implements the low-level segment operations with golang.org/x/sys/unix, allowing the
+// package to be built without cgo (e.g.: CGO_ENABLED=0) for static binaries and cross-compilation.
+
+// shmctl(2) commands that golang.org/x/sys/unix doesn't define.
+const (
+	shmLock   = 11
+	shmUnlock = 12
+)
+
+func shmget(key Key, size int, flags int) (int, error) {
+	if size < 0 {
+		return -1, syscall.EINVAL
+	}
+
+	return unix.SysvShmGet(int(key), size, flags)
+}
+
+func shmat(id int, at uintptr, flags int) (unsafe.Pointer, error) {
+	if data, err := unix.SysvShmAttach(id, at, flags); err == nil {
+		return unsafe.Pointer(&data[0]), nil
+	} else {
+		return nil, err
+	}
+}
+
+func shmdt(addr unsafe.Pointer) error {
+	// only the address of the first byte is used to detach
+	return unix.SysvShmDetach(unsafe.Slice((*byte)(addr), 1))
+}
+
+func shmread(id int, p []byte, offset int64) error {
+	return shmaccess(id, p, offset, func(data []byte) {
+		copy(p, data)
+	})
+}
+
+func shmwrite(id int, p []byte, offset int64) error {
+	return shmaccess(id, p, offset, func(data []byte) {
+		copy(data, p)
+	})
+}
+
+// attaches the segment for the duration of fn, which is given the part of it that p would be read
+// from or written to; fails with ERANGE (mirroring sysv_shm_check_range() in shm.c) unless that lies
+// entirely within the segment
+func shmaccess(id int, p []byte, offset int64, fn func(data []byte)) error {
+	data, err := unix.SysvShmAttach(id, 0, 0)
+
+	if err != nil {
+		return err
+	}
+
+	size := int64(len(data))
+
+	if offset < 0 || offset > size || int64(len(p)) > size-offset {
+		unix.SysvShmDetach(data)
+		return syscall.ERANGE
+	}
+
+	fn(data[offset : offset+int64(len(p))])
+
+	return unix.SysvShmDetach(data)
+}
+
+func shmctl(id int, cmd int, ds *unix.SysvShmDesc) error {
+	_, err := unix.SysvShmCtl(id, cmd, ds)
+	return err
+}
+
+func shmstat(id int) (*SegmentInfo, error) {
+	var ds unix.SysvShmDesc
+
+	if err := shmctl(id, unix.IPC_STAT, &ds); err != nil {
+		return nil, err
+	}
+
+	info := &SegmentInfo{
+		Id:           id,
+		Key:          Key(ds.Perm.Key),
+		Size:         int64(ds.Segsz),
+		OwnerUID:     int(ds.Perm.Uid),
+		OwnerGID:     int(ds.Perm.Gid),
+		CreatorUID:   int(ds.Perm.Cuid),
+		CreatorGID:   int(ds.Perm.Cgid),
+		Attached:     int(ds.Nattch),
+		CreatorPID:   int(ds.Cpid),
+		LastPID:      int(ds.Lpid),
+		LastAttached: unixTime(int64(ds.Atime)),
+		LastDetached: unixTime(int64(ds.Dtime)),
+		LastChanged:  unixTime(int64(ds.Ctime)),
+	}
+
+	info.setMode(uint32(ds.Perm.Mode))
+
+	return info, nil
+}
+
+// negative values leave the corresponding field unchanged
+func shmset(id int, uid int, gid int, mode int) error {
+	var ds unix.SysvShmDesc
+
+	if err := shmctl(id, unix.IPC_STAT, &ds); err != nil {
+		return err
+	}
+
+	if uid >= 0 {
+		ds.Perm.Uid = uint32(uid)
+	}
+
+	if gid >= 0 {
+		ds.Perm.Gid = uint32(gid)
+	}
+
+	// the mode is 16 bits on some architectures and 32 on others, so set the permission bits one at a
+	// time rather than converting to either
+	if mode >= 0 {
+		ds.Perm.Mode &^= 0777
+
+		for bit := uint(0); bit < 9; bit++ {
+			if mode&(1<<bit) != 0 {
+				ds.Perm.Mode |= 1 << bit
+			}
+		}
+	}
+
+	return shmctl(id, unix.IPC_SET, &ds)
+}
+
+func shmrmid(id int) error {
+	return shmctl(id, unix.IPC_RMID, nil)
+}
+
+func shmlock(id int, lock bool) error {
+	if lock {
+		return shmctl(id, shmLock, nil)
+	} else {
+		return shmctl(id, shmUnlock, nil)
+	}
+}
+
+// implements the same algorithm as glibc's ftok(3)
+func ftok(path string, projId int) (Key, error) {
+	var st syscall.Stat_t
+
+	if err := syscall.Stat(path, &st); err != nil {
+		return IpcPrivate, err
+	}
+
+	return Key(int32(
+		uint32(st.Ino&0xffff) | uint32((st.Dev&0xff)<<16) | uint32((projId&0xff)<<24),
+	)), nil
+}
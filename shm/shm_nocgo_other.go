@@ -0,0 +1,54 @@
+//go:build !cgo && !linux
+// +build !cgo
+// +build !linux
+
+package shm
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Without cgo, SysV segments are only supported on Linux (see shm_nocgo.go).  Elsewhere the package
+// still builds, so that POSIX shared memory remains usable, but every segment operation fails with
+// ENOSYS, which the callers report as an *Error.
+
+func shmget(key Key, size int, flags int) (int, error) {
+	return -1, syscall.ENOSYS
+}
+
+func shmat(id int, at uintptr, flags int) (unsafe.Pointer, error) {
+	return nil, syscall.ENOSYS
+}
+
+func shmdt(addr unsafe.Pointer) error {
+	return syscall.ENOSYS
+}
+
+func shmread(id int, p []byte, offset int64) error {
+	return syscall.ENOSYS
+}
+
+func shmwrite(id int, p []byte, offset int64) error {
+	return syscall.ENOSYS
+}
+
+func shmstat(id int) (*SegmentInfo, error) {
+	return nil, syscall.ENOSYS
+}
+
+func shmset(id int, uid int, gid int, mode int) error {
+	return syscall.ENOSYS
+}
+
+func shmrmid(id int) error {
+	return syscall.ENOSYS
+}
+
+func shmlock(id int, lock bool) error {
+	return syscall.ENOSYS
+}
+
+func ftok(path string, projId int) (Key, error) {
+	return IpcPrivate, syscall.ENOSYS
+}
@@ -2,10 +2,16 @@ package shm
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/adler32"
 	"io"
 	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -262,3 +268,377 @@ func TestSeekFromEnd(t *testing.T) {
 		return nil
 	})
 }
+
+func TestParseKey(t *testing.T) {
+	for input, shouldBe := range map[string]Key{
+		`0`:          IpcPrivate,
+		`4660`:       Key(0x1234),
+		`0x1234`:     Key(0x1234),
+		`0xdeadbeef`: Key(-559038737),
+	} {
+		if key, err := ParseKey(input); err != nil {
+			t.Errorf("Failed to parse key %q: %v", input, err)
+		} else if key != shouldBe {
+			t.Errorf("Wrong key for %q; expected: %v, got: %v", input, shouldBe, key)
+		}
+	}
+
+	if _, err := ParseKey(`0x1ffffffff`); err == nil {
+		t.Errorf("Expected out of range key to fail")
+	}
+}
+
+func TestKeyJSON(t *testing.T) {
+	info := SegmentInfo{Key: Key(-559038737)}
+
+	if data, err := json.Marshal(&info); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(string(data), `"key":"0xdeadbeef"`) {
+		t.Errorf("Wrong encoding of the key: %s", data)
+	} else {
+		var decoded SegmentInfo
+
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		} else if decoded.Key != info.Key {
+			t.Errorf("Wrong key decoded; expected: %v, got: %v", info.Key, decoded.Key)
+		}
+	}
+
+	if data, err := json.Marshal(IpcPrivate); err != nil {
+		t.Fatal(err)
+	} else if string(data) != `"0x00000000"` {
+		t.Errorf("Wrong encoding of IPC_PRIVATE: %s", data)
+	}
+
+	var key Key
+
+	if err := json.Unmarshal([]byte(`"4660"`), &key); err != nil || key != Key(0x1234) {
+		t.Errorf("Failed to decode a decimal key: %v, %v", key, err)
+	} else if err := json.Unmarshal([]byte(`"0x1ffffffff"`), &key); err == nil {
+		t.Errorf("Expected decoding an out of range key to fail")
+	}
+}
+
+func TestKeyFromPath(t *testing.T) {
+	a, err := KeyFromPath(`shm.go`, DefaultProjectId)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := KeyFromPath(`shm.go`, DefaultProjectId); err != nil {
+		t.Fatal(err)
+	} else if a != b {
+		t.Errorf("Keys for the same path differ: %v != %v", a, b)
+	}
+
+	if c, err := KeyFromPath(`shm.go`, DefaultProjectId+1); err != nil {
+		t.Fatal(err)
+	} else if a == c {
+		t.Errorf("Keys for different project IDs should differ")
+	}
+
+	if _, err := KeyFromPath(`/nonexistent/file`, DefaultProjectId); err == nil {
+		t.Errorf("Expected key generation from a nonexistent path to fail")
+	}
+
+	for _, projId := range []int{0, 0x100} {
+		if _, err := KeyFromPath(`shm.go`, projId); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("Expected project ID 0x%x to be rejected with ErrInvalidArgument, got: %v", projId, err)
+		}
+	}
+}
+
+func TestCreateWithKey(t *testing.T) {
+	key, err := KeyFromPath(`shm_test.go`, DefaultProjectId)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segment, err := CreateWithKey(key, 1024)
+
+	if err != nil {
+		t.Fatalf("Failed to create segment with key %v: %v", key, err)
+	}
+
+	defer segment.Destroy()
+
+	if _, err := CreateWithKey(key, 1024); err == nil {
+		t.Errorf("Expected exclusive creation of an existing key to fail")
+	}
+
+	if other, err := OpenByKey(key); err != nil {
+		t.Errorf("Failed to open segment by key %v: %v", key, err)
+	} else if other.Id != segment.Id {
+		t.Errorf("Wrong segment opened by key; expected: %d, got: %d", segment.Id, other.Id)
+	} else if other.Size != segment.Size {
+		t.Errorf("Wrong size for segment opened by key; expected: %d, got: %d", segment.Size, other.Size)
+	}
+
+	if _, err := OpenByKey(IpcPrivate); err == nil {
+		t.Errorf("Expected opening the private key to fail")
+	}
+}
+
+func TestStat(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		info, err := segment.Stat()
+
+		if err != nil {
+			return err
+		}
+
+		if info.Id != segment.Id {
+			return fmt.Errorf("Wrong ID; expected: %d, got: %d", segment.Id, info.Id)
+		} else if info.Size != segment.Size {
+			return fmt.Errorf("Wrong size; expected: %d, got: %d", segment.Size, info.Size)
+		} else if info.Mode != 0600 {
+			return fmt.Errorf("Wrong mode; expected: %v, got: %v", os.FileMode(0600), info.Mode)
+		} else if info.CreatorPID != os.Getpid() {
+			return fmt.Errorf("Wrong creator PID; expected: %d, got: %d", os.Getpid(), info.CreatorPID)
+		} else if info.OwnerUID != os.Getuid() {
+			return fmt.Errorf("Wrong owner UID; expected: %d, got: %d", os.Getuid(), info.OwnerUID)
+		} else if info.Attached != 0 {
+			return fmt.Errorf("Wrong attach count; expected: 0, got: %d", info.Attached)
+		}
+
+		addr, err := segment.Attach()
+
+		if err != nil {
+			return err
+		}
+
+		defer segment.Detach(addr)
+
+		if err := segment.Destroy(); err != nil {
+			return err
+		}
+
+		if info, err := segment.Stat(); err != nil {
+			return err
+		} else if info.Attached != 1 {
+			return fmt.Errorf("Wrong attach count; expected: 1, got: %d", info.Attached)
+		} else if !info.DestroyPending {
+			return fmt.Errorf("Expected segment to be pending destruction")
+		} else if info.LastAttached.IsZero() {
+			return fmt.Errorf("Expected attach time to be set")
+		}
+
+		return nil
+	})
+}
+
+func TestLockUnlock(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if err := segment.Lock(); errors.Is(err, ErrLockPermission) || errors.Is(err, ErrLockLimit) {
+			t.Skipf("Cannot lock segments here: %v", err)
+		} else if err != nil {
+			return err
+		}
+
+		if info, err := segment.Stat(); err != nil {
+			return err
+		} else if !info.Locked {
+			return fmt.Errorf("Expected segment to be locked")
+		}
+
+		if err := segment.Unlock(); err != nil {
+			return err
+		}
+
+		if info, err := segment.Stat(); err != nil {
+			return err
+		} else if info.Locked {
+			return fmt.Errorf("Expected segment to be unlocked")
+		}
+
+		return nil
+	})
+}
+
+func TestAttachWithAddress(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		addr, err := segment.Attach()
+
+		if err != nil {
+			return err
+		}
+
+		if err := segment.Detach(addr); err != nil {
+			return err
+		}
+
+		// the previous address is now free, so we should be able to ask for it explicitly
+		if again, err := segment.AttachWith(AttachOptions{
+			Address: uintptr(addr),
+		}); err != nil {
+			return err
+		} else if again != addr {
+			return fmt.Errorf("Wrong attach address; expected: %p, got: %p", addr, again)
+		} else if err := segment.Detach(again); err != nil {
+			return err
+		}
+
+		if _, err := segment.AttachWith(AttachOptions{
+			Remap: true,
+		}); err == nil {
+			return fmt.Errorf("Expected remap without an address to fail")
+		}
+
+		return nil
+	})
+}
+
+func TestAttachReadOnly(t *testing.T) {
+	writeFullSegment(t, 1024, func(segment *Segment, input []byte) error {
+		addr, err := segment.AttachWith(AttachOptions{
+			ReadOnly: true,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		defer segment.Detach(addr)
+
+		if !bytes.Equal(bytesAt(addr, len(input)), input) {
+			return fmt.Errorf("Read-only attachment does not match segment contents")
+		}
+
+		return nil
+	})
+}
+
+func TestChmodChown(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if err := segment.Chmod(0640); err != nil {
+			return err
+		}
+
+		if info, err := segment.Stat(); err != nil {
+			return err
+		} else if info.Mode != 0640 {
+			return fmt.Errorf("Wrong mode after chmod; expected: %v, got: %v", os.FileMode(0640), info.Mode)
+		}
+
+		// changing to our own group is always permitted for the owner
+		if err := segment.Chown(-1, os.Getgid()); err != nil {
+			return err
+		}
+
+		if info, err := segment.Stat(); err != nil {
+			return err
+		} else if info.OwnerUID != os.Getuid() || info.OwnerGID != os.Getgid() {
+			return fmt.Errorf("Wrong ownership after chown: %d:%d", info.OwnerUID, info.OwnerGID)
+		} else if info.Mode != 0640 {
+			return fmt.Errorf("Chown should not modify the mode; got: %v", info.Mode)
+		}
+
+		if os.Getuid() == 0 {
+			if err := segment.Chown(65534, 65534); err != nil {
+				return err
+			}
+
+			if info, err := segment.Stat(); err != nil {
+				return err
+			} else if info.OwnerUID != 65534 || info.OwnerGID != 65534 {
+				return fmt.Errorf("Wrong ownership after chown: %d:%d", info.OwnerUID, info.OwnerGID)
+			} else if info.CreatorUID != 0 {
+				return fmt.Errorf("Chown should not modify the creator; got: %d", info.CreatorUID)
+			}
+		}
+
+		return nil
+	})
+}
+
+// creates a multi-gigabyte segment with NoReserve, so that only the pages we touch are allocated
+func makeLargeSegment(t *testing.T, size int, callback func(segment *Segment) error) {
+	segment, err := OpenSegment(size, (IpcCreate | IpcExclusive | NoReserve), 0600)
+
+	if err != nil {
+		t.Skipf("Cannot allocate %d byte segment: %v", size, err)
+	}
+
+	defer segment.Destroy()
+
+	if err := callback(segment); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLargeSegment(t *testing.T) {
+	size := int64(5 << 30)
+
+	if size > math.MaxInt {
+		t.Skip("Segments larger than 4GiB need a 64-bit platform")
+	}
+
+	makeLargeSegment(t, int(size), func(segment *Segment) error {
+		if segment.Size != size {
+			return fmt.Errorf("Wrong segment size; expected: %d, got: %d", size, segment.Size)
+		}
+
+		input := []byte(`beyond 4GiB`)
+
+		// offsets on either side of the 2GiB and 4GiB boundaries that used to overflow
+		for _, offset := range []int64{(2 << 30) - 4, (4 << 30) + 12, size - int64(len(input))} {
+			if n, err := segment.WriteAt(input, offset); err != nil || n != len(input) {
+				return fmt.Errorf("Failed to write at offset %d: n=%d err=%v", offset, n, err)
+			}
+
+			output := make([]byte, len(input))
+
+			if n, err := segment.ReadAt(output, offset); err != nil || n != len(output) {
+				return fmt.Errorf("Failed to read at offset %d: n=%d err=%v", offset, n, err)
+			} else if !bytes.Equal(output, input) {
+				return fmt.Errorf("Wrong data at offset %d; expected: %q, got: %q", offset, input, output)
+			}
+
+			if chunk, err := segment.ReadChunk(int64(len(input)), offset); err != nil {
+				return err
+			} else if !bytes.Equal(chunk, input) {
+				return fmt.Errorf("Wrong chunk at offset %d; expected: %q, got: %q", offset, input, chunk)
+			}
+		}
+
+		if chunk, err := segment.ReadChunk(-1, size-4); err != nil {
+			return err
+		} else if string(chunk) != `4GiB` {
+			return fmt.Errorf("Wrong tail chunk; got: %q", chunk)
+		}
+
+		return nil
+	})
+}
+
+func TestOutOfRange(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		if _, err := segment.ReadChunk(16, 1020); err == nil {
+			return fmt.Errorf("Expected an error reading past the end of the segment")
+		}
+
+		if _, err := segment.ReadChunk(1, -1); err == nil {
+			return fmt.Errorf("Expected an error reading from a negative offset")
+		}
+
+		// the low-level operations must validate the range themselves
+		buffer := make([]byte, 16)
+
+		if err := shmread(segment.Id, buffer, 1020); err != syscall.ERANGE {
+			return fmt.Errorf("Expected ERANGE from an overrunning read; got: %v", err)
+		}
+
+		if err := shmwrite(segment.Id, buffer, 1<<40); err != syscall.ERANGE {
+			return fmt.Errorf("Expected ERANGE from an out-of-range write; got: %v", err)
+		}
+
+		if err := shmwrite(segment.Id, buffer, 1008); err != nil {
+			return fmt.Errorf("Write ending exactly at the end of the segment failed: %v", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,241 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+const (
+	// Identifies shared memory that has been initialized as a Snapshot ("SHMS").
+	SnapshotMagic = 0x534d4853
+
+	// The version of the Snapshot layout implemented by this package.
+	SnapshotVersion = 1
+
+	// The number of bytes at the start of a Snapshot occupied by its header; the payload follows it.
+	SnapshotHeaderSize = 64
+)
+
+// How many times a reader retries immediately before it starts sleeping between attempts.
+const snapshotSpins = 100
+
+// How long a reader sleeps between attempts once it has stopped spinning.
+var SnapshotRetryInterval = 50 * time.Microsecond
+
+// Returned by OpenSnapshot when the memory does not contain a Snapshot.
+var ErrNotSnapshot = errors.New(`shared memory does not contain a seqlock snapshot`)
+
+// The header at the start of a Snapshot.  Seq is odd while the writer is updating the payload.
+type snapshotHeader struct {
+	Magic   uint32
+	Version uint32
+	Size    uint64
+	Seq     uint64
+	_       [40]byte
+}
+
+// A fixed-size block of data that one process publishes and any number of processes read, protected
+// by a sequence lock.  The writer increments a sequence counter before and after each update, and
+// readers copy the payload and retry if the counter was odd or changed while they were copying, so
+// they always get a complete copy of a single update without ever blocking the writer.  Because
+// readers never write to the Snapshot, they can use read-only mappings.
+//
+// Only one process (or goroutine) may update a Snapshot at a time.  If the writer exits in the middle
+// of an update, readers retry until their context is done or the next update completes.
+type Snapshot struct {
+	mapping *Mapping
+	header  *snapshotHeader
+	data    []byte
+}
+
+// Initializes a new Snapshot with a zeroed payload of the given size at the given offset of the
+// mapping, discarding whatever was previously stored there.  A size of zero makes the payload
+// occupy the rest of the mapping.  The offset must be aligned to an 8 byte boundary.  If the mapping
+// begins with a segment header whose payload starts at the offset, the header's layout is set to
+// LayoutSnapshot, so that readers such as shmtool know to read it through the sequence lock.
+//
+func InitSnapshot(mapping *Mapping, offset int64, size int64) (*Snapshot, error) {
+	if mapping.ReadOnly() {
+		return nil, fmt.Errorf("Cannot initialize a snapshot in a read-only mapping")
+	}
+
+	header, data, err := snapshotAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if size < 0 || size > int64(len(data)) {
+		return nil, fmt.Errorf("Snapshot payload of %d bytes does not fit in the %d bytes available: %w", size, len(data), ErrInvalidSize)
+	} else if size > 0 {
+		data = data[:size]
+	}
+
+	for i := range data {
+		data[i] = 0
+	}
+
+	*header = snapshotHeader{
+		Version: SnapshotVersion,
+		Size:    uint64(len(data)),
+	}
+
+	atomic.StoreUint32(&header.Magic, SnapshotMagic)
+	setHeaderLayout(mapping, offset, LayoutSnapshot)
+
+	return &Snapshot{
+		mapping: mapping,
+		header:  header,
+		data:    data,
+	}, nil
+}
+
+// Opens the Snapshot stored in the mapping at the given offset, which must have been initialized
+// with InitSnapshot.  Returns ErrNotSnapshot if no Snapshot is found there.
+//
+func OpenSnapshot(mapping *Mapping, offset int64) (*Snapshot, error) {
+	header, data, err := snapshotAt(mapping, offset)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if atomic.LoadUint32(&header.Magic) != SnapshotMagic {
+		return nil, ErrNotSnapshot
+	} else if header.Version != SnapshotVersion {
+		return nil, fmt.Errorf("Unsupported snapshot layout version %d", header.Version)
+	} else if header.Size > uint64(len(data)) {
+		return nil, fmt.Errorf("Snapshot size %d exceeds the %d bytes available", header.Size, len(data))
+	}
+
+	return &Snapshot{
+		mapping: mapping,
+		header:  header,
+		data:    data[:header.Size],
+	}, nil
+}
+
+func snapshotAt(mapping *Mapping, offset int64) (*snapshotHeader, []byte, error) {
+	data := mapping.Bytes()
+
+	if data == nil {
+		return nil, nil, fmt.Errorf("Cannot use a closed mapping")
+	} else if offset < 0 || offset%8 != 0 {
+		return nil, nil, fmt.Errorf("Offset %d is not aligned to an 8 byte boundary", offset)
+	} else if int64(len(data))-offset < SnapshotHeaderSize {
+		return nil, nil, fmt.Errorf("Not enough room for a snapshot at offset %d of a %d byte mapping", offset, len(data))
+	}
+
+	return (*snapshotHeader)(unsafe.Pointer(&data[offset])), data[offset+SnapshotHeaderSize:], nil
+}
+
+// Returns the size of the Snapshot's payload.
+func (self *Snapshot) Size() int {
+	return len(self.data)
+}
+
+// Returns the number of updates that have been published to the Snapshot.
+func (self *Snapshot) Seq() uint64 {
+	return atomic.LoadUint64(&self.header.Seq) / 2
+}
+
+// Calls fn with the Snapshot's payload, which it may modify in place; readers will not see any of
+// the modifications until fn returns, at which point they see all of them.  Only the writer may
+// call this.
+//
+func (self *Snapshot) Update(fn func(data []byte)) error {
+	if self.mapping.ReadOnly() {
+		return fmt.Errorf("Cannot update a snapshot in a read-only mapping")
+	}
+
+	// an odd sequence means a previous writer died part way through an update; keep it odd.  The
+	// sequence is changed with read-modify-write operations rather than stores, since a store only
+	// keeps earlier writes from moving after it, and the writes made by fn must not become visible
+	// before the sequence is odd.
+	if atomic.LoadUint64(&self.header.Seq)&1 == 0 {
+		atomic.AddUint64(&self.header.Seq, 1)
+	} else {
+		atomic.AddUint64(&self.header.Seq, 2)
+	}
+
+	fn(self.data)
+	atomic.AddUint64(&self.header.Seq, 1)
+
+	return nil
+}
+
+// Replaces the start of the Snapshot's payload with p, which may not be longer than the payload.
+// Only the writer may call this.
+//
+func (self *Snapshot) Publish(p []byte) error {
+	if len(p) > len(self.data) {
+		return fmt.Errorf("Cannot publish %d bytes to a %d byte snapshot: %w", len(p), len(self.data), ErrInvalidSize)
+	}
+
+	return self.Update(func(data []byte) {
+		copy(data, p)
+	})
+}
+
+// Returns a consistent copy of the Snapshot's payload, retrying for as long as it takes.
+//
+func (self *Snapshot) Load() ([]byte, error) {
+	data := make([]byte, len(self.data))
+
+	if _, err := self.LoadContext(context.Background(), data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Copies a consistent copy of the start of the Snapshot's payload into p, retrying until it succeeds
+// or the context is done.  Returns the number of the update that was copied (see Seq).
+//
+func (self *Snapshot) LoadContext(ctx context.Context, p []byte) (uint64, error) {
+	if len(p) > len(self.data) {
+		p = p[:len(self.data)]
+	}
+
+	for attempt := 0; ; attempt++ {
+		before := atomic.LoadUint64(&self.header.Seq)
+
+		if before&1 == 0 {
+			self.copyTo(p)
+
+			if atomic.LoadUint64(&self.header.Seq) == before {
+				return before / 2, nil
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		if attempt < snapshotSpins {
+			runtime.Gosched()
+		} else {
+			time.Sleep(SnapshotRetryInterval)
+		}
+	}
+}
+
+// Copies the start of the payload into p using atomic 64-bit loads, which keep the copy from being
+// reordered after the reader's second look at the sequence (as a plain copy could be).  The payload
+// starts on an 8 byte boundary of a page-aligned mapping, so the word holding the last byte lies
+// within the same page even if it extends past the end of the payload.
+//
+func (self *Snapshot) copyTo(p []byte) {
+	for i := 0; i < len(p); i += 8 {
+		word := atomic.LoadUint64((*uint64)(unsafe.Pointer(&self.data[i])))
+		copy(p[i:], (*[8]byte)(unsafe.Pointer(&word))[:])
+	}
+}
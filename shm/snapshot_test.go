@@ -0,0 +1,219 @@
+//go:build linux
+// +build linux
+
+package shm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// creates a segment with a Snapshot in it, giving the writer and reader separate attachments (the
+// reader's being read-only)
+func makeSnapshot(t *testing.T, size int64, callback func(writer *Snapshot, reader *Snapshot) error) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		wmap, err := segment.Map(AttachOptions{})
+
+		if err != nil {
+			return err
+		}
+
+		defer wmap.Close()
+
+		rmap, err := segment.Map(AttachOptions{ReadOnly: true})
+
+		if err != nil {
+			return err
+		}
+
+		defer rmap.Close()
+
+		if _, err := OpenSnapshot(rmap, 0); err != ErrNotSnapshot {
+			return fmt.Errorf("Expected ErrNotSnapshot opening an uninitialized snapshot; got: %v", err)
+		}
+
+		if _, err := InitSnapshot(rmap, 0, size); err == nil {
+			return fmt.Errorf("Expected initializing a snapshot in a read-only mapping to fail")
+		}
+
+		writer, err := InitSnapshot(wmap, 0, size)
+
+		if err != nil {
+			return err
+		}
+
+		reader, err := OpenSnapshot(rmap, 0)
+
+		if err != nil {
+			return err
+		}
+
+		return callback(writer, reader)
+	})
+}
+
+func TestSnapshotPublishLoad(t *testing.T) {
+	makeSnapshot(t, 0, func(writer *Snapshot, reader *Snapshot) error {
+		if reader.Size() != 1024-SnapshotHeaderSize {
+			return fmt.Errorf("Wrong size; expected: %d, got: %d", 1024-SnapshotHeaderSize, reader.Size())
+		}
+
+		if err := writer.Publish([]byte(`hello`)); err != nil {
+			return err
+		} else if err := writer.Publish([]byte(`HE`)); err != nil {
+			return err
+		}
+
+		if data, err := reader.Load(); err != nil {
+			return err
+		} else if !bytes.HasPrefix(data, []byte("HEllo\x00")) || len(data) != reader.Size() {
+			return fmt.Errorf("Wrong payload: %q...", data[:8])
+		}
+
+		if reader.Seq() != 2 {
+			return fmt.Errorf("Wrong sequence; expected: 2, got: %d", reader.Seq())
+		}
+
+		if err := writer.Publish(make([]byte, writer.Size()+1)); !errors.Is(err, ErrInvalidSize) {
+			return fmt.Errorf("Expected ErrInvalidSize publishing an oversized payload; got: %v", err)
+		}
+
+		if err := reader.Publish([]byte(`x`)); err == nil {
+			return fmt.Errorf("Expected publishing through a read-only mapping to fail")
+		}
+
+		return nil
+	})
+
+	makeSnapshot(t, 16, func(writer *Snapshot, reader *Snapshot) error {
+		if reader.Size() != 16 {
+			return fmt.Errorf("Wrong size; expected: 16, got: %d", reader.Size())
+		}
+
+		return nil
+	})
+}
+
+func TestSnapshotConsistent(t *testing.T) {
+	// an odd size, so that the copy has to deal with a partial word at the end
+	makeSnapshot(t, 509, func(writer *Snapshot, reader *Snapshot) error {
+		var stop int32
+
+		done := make(chan struct{})
+
+		// every update fills the whole payload with its own sequence number, so a torn read shows up
+		// as a payload containing any other value
+		go func() {
+			defer close(done)
+
+			for i := 1; atomic.LoadInt32(&stop) == 0; i++ {
+				writer.Update(func(data []byte) {
+					for j := range data {
+						data[j] = byte(i)
+					}
+				})
+			}
+		}()
+
+		defer func() {
+			atomic.StoreInt32(&stop, 1)
+			<-done
+		}()
+
+		data := make([]byte, reader.Size())
+		last := uint64(0)
+
+		for i := 0; i < 2000; i++ {
+			seq, err := reader.LoadContext(context.Background(), data)
+
+			if err != nil {
+				return err
+			} else if seq < last {
+				return fmt.Errorf("Sequence went backwards from %d to %d", last, seq)
+			}
+
+			last = seq
+
+			for j := range data {
+				if data[j] != byte(seq) {
+					return fmt.Errorf("Torn read of update %d: byte %d is %d", seq, j, data[j])
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+func TestSnapshotStalledWriter(t *testing.T) {
+	makeSnapshot(t, 64, func(writer *Snapshot, reader *Snapshot) error {
+		var err error
+
+		writer.Update(func(data []byte) {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+
+			_, err = reader.LoadContext(ctx, make([]byte, 64))
+		})
+
+		if err != context.DeadlineExceeded {
+			return fmt.Errorf("Expected reading during an update to time out; got: %v", err)
+		}
+
+		// simulate a writer that died part way through an update
+		atomic.StoreUint64(&writer.header.Seq, 7)
+
+		if err := writer.Publish([]byte{1}); err != nil {
+			return err
+		}
+
+		if data, err := reader.Load(); err != nil {
+			return err
+		} else if data[0] != 1 {
+			return fmt.Errorf("Wrong payload after recovering: %v", data[:4])
+		}
+
+		return nil
+	})
+}
+
+func TestSnapshotHeaderLayout(t *testing.T) {
+	makeHeaderSegment(t, func(segment *Segment, mapping *Mapping, header *Header) error {
+		// a snapshot anywhere but at the start of the payload isn't what the header describes
+		if _, err := InitSnapshot(mapping, header.PayloadOffset+64, 0); err != nil {
+			return err
+		} else if err := checkHeaderLayout(segment, LayoutRaw); err != nil {
+			return err
+		}
+
+		writer, err := InitSnapshot(mapping, header.PayloadOffset, 0)
+
+		if err != nil {
+			return err
+		} else if writer.Size() != 1024-SnapshotHeaderSize {
+			return fmt.Errorf("Wrong size; expected: %d, got: %d", 1024-SnapshotHeaderSize, writer.Size())
+		} else if err := checkHeaderLayout(segment, LayoutSnapshot); err != nil {
+			return err
+		}
+
+		// a reader going by the header finds the snapshot at the start of the payload
+		if header, err := ReadHeader(segment); err != nil {
+			return err
+		} else if reader, err := OpenSnapshot(mapping, header.PayloadOffset); err != nil {
+			return err
+		} else if err := writer.Publish([]byte(`framed`)); err != nil {
+			return err
+		} else if data, err := reader.Load(); err != nil {
+			return err
+		} else if !bytes.HasPrefix(data, []byte(`framed`)) {
+			return fmt.Errorf("Wrong payload: %q...", data[:8])
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,171 @@
+package shm
+
+import (
+	"io"
+	"unsafe"
+)
+
+// how many times in a row ReadFrom() will accept a read that returns no data and no error
+const maxConsecutiveEmptyReads = 100
+
+// the subset of SharedMemory needed to stream directly to and from attached memory
+type attacher interface {
+	AttachWith(opts AttachOptions) (unsafe.Pointer, error)
+	Detach(addr unsafe.Pointer) error
+}
+
+// Implements the io.WriterTo interface, writing everything from the current position to the end of
+// the segment directly from the attached memory (without an intermediate buffer), and advancing the
+// position accordingly.  This is used automatically by io.Copy.
+//
+func (self *Segment) WriteTo(w io.Writer) (int64, error) {
+	n, err := writeToAttached(self, self.offset, self.Size, w)
+	self.offset += n
+	return n, err
+}
+
+// Implements the io.ReaderFrom interface, reading from r directly into the attached memory starting
+// at the current position until r returns io.EOF, and advancing the position accordingly.  Once the
+// segment is full, one more byte is read from r to tell whether it had more input: if it did,
+// io.ErrShortWrite is returned, that byte is discarded, and the rest of the input is left for the
+// caller.  Input that exactly fills the segment succeeds.  This is used automatically by io.Copy.
+//
+func (self *Segment) ReadFrom(r io.Reader) (int64, error) {
+	n, err := readFromAttached(self, self.offset, self.Size, r)
+	self.offset += n
+	return n, err
+}
+
+// Returns a Section that reads n bytes from the segment starting at offset off, independently of the
+// segment's current position and of any other Section.  If n is negative or extends beyond the end
+// of the segment, the Section ends at the end of the segment.
+//
+func (self *Segment) Section(off int64, n int64) *Section {
+	return newSection(self, self.Size, self, off, n)
+}
+
+// A read-only window onto a region of shared memory, in the style of io.SectionReader.  Each Section
+// has its own position, so multiple Sections can be read concurrently.  Copying a Section with
+// io.Copy (or calling WriteTo directly) writes straight from the attached memory.
+type Section struct {
+	*io.SectionReader
+	source attacher
+	base   int64
+}
+
+func newSection(r io.ReaderAt, size int64, source attacher, off int64, n int64) *Section {
+	if off < 0 {
+		off = 0
+	}
+
+	if off > size {
+		off = size
+	}
+
+	if n < 0 || off+n > size {
+		n = size - off
+	}
+
+	return &Section{
+		SectionReader: io.NewSectionReader(r, off, n),
+		source:        source,
+		base:          off,
+	}
+}
+
+// Implements the io.WriterTo interface, writing the remainder of the section directly from the
+// attached memory.
+//
+func (self *Section) WriteTo(w io.Writer) (int64, error) {
+	pos, err := self.Seek(0, io.SeekCurrent)
+
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := writeToAttached(self.source, self.base+pos, self.base+self.Size(), w)
+	self.Seek(n, io.SeekCurrent)
+
+	return n, err
+}
+
+// attach the memory read-only and write the bytes in [start, end) to w
+func writeToAttached(source attacher, start int64, end int64, w io.Writer) (int64, error) {
+	if start >= end {
+		return 0, nil
+	}
+
+	addr, err := source.AttachWith(AttachOptions{
+		ReadOnly: true,
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer source.Detach(addr)
+
+	n, err := w.Write(bytesAt(addr, int(end))[start:end])
+	return int64(n), err
+}
+
+// attach the memory and read from r into [start, end) until r is exhausted or the memory is full;
+// once it is full, a single byte is read to tell whether r had any input left over, in which case
+// io.ErrShortWrite is returned
+func readFromAttached(source attacher, start int64, end int64, r io.Reader) (int64, error) {
+	if start >= end {
+		return 0, probeEOF(r)
+	}
+
+	addr, err := source.AttachWith(AttachOptions{})
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer source.Detach(addr)
+
+	data := bytesAt(addr, int(end))[start:end]
+	total := 0
+	empty := 0
+
+	for total < len(data) {
+		n, err := r.Read(data[total:])
+		total += n
+
+		if err == io.EOF {
+			return int64(total), nil
+		} else if err != nil {
+			return int64(total), err
+		}
+
+		// give up on readers that keep returning nothing, as bufio does
+		if n == 0 {
+			if empty++; empty >= maxConsecutiveEmptyReads {
+				return int64(total), io.ErrNoProgress
+			}
+		} else {
+			empty = 0
+		}
+	}
+
+	return int64(total), probeEOF(r)
+}
+
+// reads one byte from r, returning nil if r is exhausted and io.ErrShortWrite if it is not (in which
+// case the byte is discarded)
+func probeEOF(r io.Reader) error {
+	var probe [1]byte
+
+	for empty := 0; empty < maxConsecutiveEmptyReads; empty++ {
+		if n, err := r.Read(probe[:]); n > 0 {
+			return io.ErrShortWrite
+		} else if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+
+	return io.ErrNoProgress
+}
@@ -0,0 +1,163 @@
+package shm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestSegmentWriteTo(t *testing.T) {
+	writeFullSegment(t, 1024, func(segment *Segment, input []byte) error {
+		var output bytes.Buffer
+
+		segment.Seek(1000, 0)
+
+		if n, err := io.Copy(&output, segment); err != nil {
+			return err
+		} else if n != segment.Size-1000 || !bytes.Equal(output.Bytes(), input[1000:]) {
+			return fmt.Errorf("Wrong data copied from offset 1000: %d bytes", n)
+		} else if segment.Position() != segment.Size {
+			return fmt.Errorf("Position not advanced; expected: %d, got: %d", segment.Size, segment.Position())
+		}
+
+		return nil
+	})
+}
+
+// a reader that never returns any data, but never fails either
+type emptyReader struct{}
+
+func (emptyReader) Read(p []byte) (int, error) {
+	return 0, nil
+}
+
+func TestSegmentReadFrom(t *testing.T) {
+	makeSegment(t, 1024, func(segment *Segment) error {
+		input := bytes.Repeat([]byte{0x5A}, int(segment.Size))
+
+		if n, err := segment.ReadFrom(bytes.NewReader(input[:10])); err != nil || n != 10 {
+			return fmt.Errorf("Short ReadFrom failed: n=%d err=%v", n, err)
+		}
+
+		// input that exactly fills the rest of the segment isn't reported as a short write
+		if n, err := segment.ReadFrom(bytes.NewReader(input[10:])); err != nil || n != segment.Size-10 {
+			return fmt.Errorf("Filling ReadFrom failed: n=%d err=%v", n, err)
+		}
+
+		segment.Seek(0, 0)
+
+		if n, err := segment.ReadFrom(bytes.NewReader(input)); err != nil || n != segment.Size {
+			return fmt.Errorf("Expected input the size of the segment to fit; got n=%d err=%v", n, err)
+		}
+
+		segment.Seek(1020, 0)
+
+		// only the single byte used to detect it is read from the input that didn't fit
+		overflow := bytes.NewReader(input)
+
+		if n, err := segment.ReadFrom(overflow); err != io.ErrShortWrite || n != 4 {
+			return fmt.Errorf("Expected overflowing ReadFrom to return ErrShortWrite; got n=%d err=%v", n, err)
+		} else if overflow.Len() != len(input)-5 {
+			return fmt.Errorf("Overflowing ReadFrom consumed %d bytes of input", len(input)-overflow.Len())
+		}
+
+		if n, err := segment.ReadFrom(overflow); err != io.ErrShortWrite || n != 0 {
+			return fmt.Errorf("Expected ReadFrom at the end to return ErrShortWrite; got n=%d err=%v", n, err)
+		} else if n, err := segment.ReadFrom(bytes.NewReader(nil)); err != nil || n != 0 {
+			return fmt.Errorf("Expected ReadFrom of nothing at the end to succeed; got n=%d err=%v", n, err)
+		}
+
+		segment.Seek(0, 0)
+
+		if n, err := segment.ReadFrom(emptyReader{}); err != io.ErrNoProgress || n != 0 {
+			return fmt.Errorf("Expected ReadFrom to give up on a reader that returns nothing; got n=%d err=%v", n, err)
+		}
+
+		if chunk, err := segment.ReadChunk(-1, 0); err != nil {
+			return err
+		} else if !bytes.Equal(chunk, input) {
+			return fmt.Errorf("Segment contents do not match input")
+		}
+
+		return nil
+	})
+}
+
+func TestSegmentSections(t *testing.T) {
+	writeFullSegment(t, 1024, func(segment *Segment, input []byte) error {
+		var wg sync.WaitGroup
+
+		errs := make(chan error, 8)
+
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+
+			go func(i int) {
+				defer wg.Done()
+
+				off := int64(i * 128)
+
+				if output, err := ioutil.ReadAll(segment.Section(off, 128)); err != nil {
+					errs <- err
+				} else if !bytes.Equal(output, input[off:off+128]) {
+					errs <- fmt.Errorf("Wrong data in section %d", i)
+				}
+			}(i)
+		}
+
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			return err
+		}
+
+		section := segment.Section(1000, 100)
+
+		if section.Size() != segment.Size-1000 {
+			return fmt.Errorf("Section should be clamped to the segment; got size %d", section.Size())
+		}
+
+		section.Seek(4, io.SeekStart)
+
+		var output bytes.Buffer
+
+		if n, err := section.WriteTo(&output); err != nil {
+			return err
+		} else if !bytes.Equal(output.Bytes(), input[1004:]) {
+			return fmt.Errorf("Wrong data from section WriteTo: %d bytes", n)
+		} else if n, err := section.Read(make([]byte, 1)); err != io.EOF || n != 0 {
+			return fmt.Errorf("Expected section to be exhausted after WriteTo; got n=%d err=%v", n, err)
+		}
+
+		return nil
+	})
+}
+
+func TestPosixReadFromWriteTo(t *testing.T) {
+	makePosixSegment(t, 64, func(segment *PosixSegment) error {
+		if _, err := segment.ReadFrom(bytes.NewReader([]byte(`streamed`))); err != nil {
+			return err
+		}
+
+		// filling the object exactly is not a short write
+		if n, err := segment.ReadFrom(bytes.NewReader(make([]byte, 56))); err != nil || n != 56 {
+			return fmt.Errorf("Expected input filling the object to fit; got n=%d err=%v", n, err)
+		} else if n, err := segment.ReadFrom(bytes.NewReader([]byte{1})); err != io.ErrShortWrite || n != 0 {
+			return fmt.Errorf("Expected ReadFrom past the end to return ErrShortWrite; got n=%d err=%v", n, err)
+		}
+
+		var output bytes.Buffer
+
+		if _, err := segment.Section(0, 8).WriteTo(&output); err != nil {
+			return err
+		} else if output.String() != `streamed` {
+			return fmt.Errorf("Wrong data from section: %q", output.String())
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,6 @@
+package shm
+
+// system calls not defined by the syscall package for this architecture
+const (
+	sysMemfdCreate = 279
+)
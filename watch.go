@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ghetzel/cli"
+	"github.com/ghetzel/go-stockutil/log"
+	"github.com/ghetzel/shmtool/shm"
+)
+
+// The ways watch --interval can report changes.
+var watchFormats = []string{`summary`, `hex`, `json`}
+
+// A contiguous range of bytes that changed between two samples.
+type changedRange struct {
+	Offset int64 `json:"offset"`
+	Length int64 `json:"length"`
+}
+
+// A line of watch --format json output.
+type changeReport struct {
+	Time    time.Time      `json:"time"`
+	Changed int64          `json:"changed"`
+	Ranges  []changedRange `json:"ranges"`
+}
+
+// Repeatedly samples a window of the given memory, reporting the byte ranges that changed since the
+// previous sample.  This works with segments whose writers never call Notify, at the cost of reading
+// the whole window every interval.
+func pollChanges(c *cli.Context, memory shm.SharedMemory) {
+	interval := c.Duration(`interval`)
+	blockSize := int64(c.Int(`block-size`))
+	format := c.String(`format`)
+	start := int64(c.Int(`offset`))
+	length := int64(c.Int(`length`))
+
+	if interval <= 0 {
+		log.Fatalf("The interval must be positive")
+	} else if blockSize <= 0 {
+		log.Fatalf("The block size must be positive")
+	} else if !sliceContains(watchFormats, format) {
+		log.Fatalf("Unknown format %q (must be one of: %s)", format, strings.Join(watchFormats, `, `))
+	}
+
+	if length <= 0 {
+		length = -1
+	}
+
+	// only the hex diff needs the previous contents; everything else just compares block hashes
+	previous, err := memory.ReadChunk(length, start)
+
+	if err != nil {
+		fatalf(err, "Failed to read from shared memory: %v", err)
+	}
+
+	hashes := blockHashes(previous, blockSize)
+
+	if format != `hex` {
+		previous = nil
+	}
+
+	var lastChange = time.Now()
+	var reported int
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		current, err := memory.ReadChunk(length, start)
+
+		if err != nil {
+			fatalf(err, "Failed to read from shared memory: %v", err)
+		}
+
+		currentHashes := blockHashes(current, blockSize)
+		ranges := changedRanges(hashes, currentHashes, blockSize, int64(len(current)))
+
+		if len(ranges) == 0 {
+			if timeout := c.Duration(`timeout`); timeout > 0 && time.Since(lastChange) >= timeout {
+				log.Infof("No change within %v", timeout)
+				return
+			}
+
+			continue
+		}
+
+		now := time.Now()
+
+		switch format {
+		case `json`:
+			printChangeReport(now, ranges, start)
+		case `hex`:
+			printHexDiff(now, ranges, start, previous, current)
+			previous = current
+		default:
+			printChangeSummary(now, ranges, start)
+		}
+
+		hashes = currentHashes
+		lastChange = now
+		reported++
+
+		if count := c.Int(`count`); count > 0 && reported >= count {
+			return
+		}
+	}
+}
+
+// hashes data in fixed-size blocks (the last of which may be shorter)
+func blockHashes(data []byte, blockSize int64) []uint64 {
+	hashes := make([]uint64, 0, (int64(len(data))+blockSize-1)/blockSize)
+
+	for off := int64(0); off < int64(len(data)); off += blockSize {
+		end := off + blockSize
+
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+
+		h := fnv.New64a()
+		h.Write(data[off:end])
+		hashes = append(hashes, h.Sum64())
+	}
+
+	return hashes
+}
+
+// merges runs of adjacent changed blocks into byte ranges, relative to the start of the sample
+func changedRanges(before []uint64, after []uint64, blockSize int64, length int64) []changedRange {
+	var ranges []changedRange
+
+	for i := range after {
+		if i < len(before) && before[i] == after[i] {
+			continue
+		}
+
+		off := int64(i) * blockSize
+		end := off + blockSize
+
+		if end > length {
+			end = length
+		}
+
+		if n := len(ranges); n > 0 && ranges[n-1].Offset+ranges[n-1].Length == off {
+			ranges[n-1].Length += end - off
+		} else {
+			ranges = append(ranges, changedRange{Offset: off, Length: end - off})
+		}
+	}
+
+	return ranges
+}
+
+func totalLength(ranges []changedRange) int64 {
+	var total int64
+
+	for _, r := range ranges {
+		total += r.Length
+	}
+
+	return total
+}
+
+// Prints a line listing the changed ranges as absolute offsets.
+func printChangeSummary(now time.Time, ranges []changedRange, base int64) {
+	spans := make([]string, len(ranges))
+
+	for i, r := range ranges {
+		spans[i] = fmt.Sprintf("0x%x-0x%x", base+r.Offset, base+r.Offset+r.Length-1)
+	}
+
+	fmt.Printf(
+		"%s\t%d bytes changed in %d ranges: %s\n",
+		now.Format(time.RFC3339Nano),
+		totalLength(ranges),
+		len(ranges),
+		strings.Join(spans, ` `),
+	)
+}
+
+// Prints the changed ranges as a line of JSON.
+func printChangeReport(now time.Time, ranges []changedRange, base int64) {
+	report := changeReport{
+		Time:    now,
+		Changed: totalLength(ranges),
+		Ranges:  make([]changedRange, len(ranges)),
+	}
+
+	for i, r := range ranges {
+		report.Ranges[i] = changedRange{Offset: base + r.Offset, Length: r.Length}
+	}
+
+	if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+		log.Fatalf("Failed to encode output: %v", err)
+	}
+}
+
+// Prints the old and new contents of each 16 byte line within the changed ranges that differs.
+func printHexDiff(now time.Time, ranges []changedRange, base int64, before []byte, after []byte) {
+	const width = 16
+
+	fmt.Printf("%s\t%d bytes changed in %d ranges\n", now.Format(time.RFC3339Nano), totalLength(ranges), len(ranges))
+
+	// the samples differ in length if the memory was resized in between, and only the bytes present
+	// in both can be compared
+	limit := int64(len(before))
+
+	if int64(len(after)) < limit {
+		limit = int64(len(after))
+	}
+
+	for _, r := range ranges {
+		fmt.Printf("@@ 0x%x-0x%x (%d bytes) @@\n", base+r.Offset, base+r.Offset+r.Length-1, r.Length)
+
+		for off := r.Offset; off < r.Offset+r.Length && off < limit; off += width {
+			end := off + width
+
+			if end > r.Offset+r.Length {
+				end = r.Offset + r.Length
+			}
+
+			if end > limit {
+				end = limit
+			}
+
+			if bytes.Equal(before[off:end], after[off:end]) {
+				continue
+			}
+
+			fmt.Printf("-%08x  % x\n", base+off, before[off:end])
+			fmt.Printf("+%08x  % x\n", base+off, after[off:end])
+		}
+	}
+}
+
+func sliceContains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
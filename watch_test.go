@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBlockHashes(t *testing.T) {
+	data := bytes.Repeat([]byte(`0123456789abcdef`), 4)
+
+	tests := []struct {
+		length    int
+		blockSize int64
+		count     int
+	}{
+		{0, 16, 0},
+		{1, 16, 1},
+		{16, 16, 1},
+		{17, 16, 2},
+		{64, 16, 4},
+		{63, 16, 4},
+		{64, 1, 64},
+		{64, 100, 1},
+	}
+
+	for _, test := range tests {
+		if hashes := blockHashes(data[:test.length], test.blockSize); len(hashes) != test.count {
+			t.Errorf("%d bytes in blocks of %d: expected %d hashes, got %d", test.length, test.blockSize, test.count, len(hashes))
+		}
+	}
+
+	// identical blocks hash the same, and a trailing partial block is hashed on its own
+	hashes := blockHashes(data[:40], 16)
+
+	if hashes[0] != hashes[1] {
+		t.Errorf("Expected identical blocks to have the same hash")
+	} else if hashes[2] == hashes[0] || hashes[2] != blockHashes(data[:8], 16)[0] {
+		t.Errorf("Expected the trailing partial block to be hashed by its own contents")
+	}
+}
+
+func TestChangedRanges(t *testing.T) {
+	// changes the bytes at the given offsets of a copy of data
+	changed := func(data []byte, offsets ...int) []byte {
+		out := append([]byte(nil), data...)
+
+		for _, off := range offsets {
+			out[off] ^= 0xff
+		}
+
+		return out
+	}
+
+	before := bytes.Repeat([]byte{0x5a}, 16)
+
+	tests := []struct {
+		name      string
+		before    []byte
+		after     []byte
+		blockSize int64
+		expected  []changedRange
+	}{
+		{
+			name:      `unchanged`,
+			before:    before,
+			after:     before,
+			blockSize: 4,
+		}, {
+			name:      `one byte`,
+			before:    before,
+			after:     changed(before, 5),
+			blockSize: 4,
+			expected:  []changedRange{{Offset: 4, Length: 4}},
+		}, {
+			name:      `last byte of a block`,
+			before:    before,
+			after:     changed(before, 3),
+			blockSize: 4,
+			expected:  []changedRange{{Offset: 0, Length: 4}},
+		}, {
+			// a change on either side of a block boundary touches both blocks, which are merged
+			name:      `across a block boundary`,
+			before:    before,
+			after:     changed(before, 7, 8),
+			blockSize: 4,
+			expected:  []changedRange{{Offset: 4, Length: 8}},
+		}, {
+			name:      `adjacent blocks merged`,
+			before:    before,
+			after:     changed(before, 0, 4, 8, 12),
+			blockSize: 4,
+			expected:  []changedRange{{Offset: 0, Length: 16}},
+		}, {
+			name:      `separate blocks`,
+			before:    before,
+			after:     changed(before, 1, 9),
+			blockSize: 4,
+			expected:  []changedRange{{Offset: 0, Length: 4}, {Offset: 8, Length: 4}},
+		}, {
+			name:      `trailing partial block`,
+			before:    before[:10],
+			after:     changed(before[:10], 9),
+			blockSize: 4,
+			expected:  []changedRange{{Offset: 8, Length: 2}},
+		}, {
+			name:      `partial block merged with the one before`,
+			before:    before[:10],
+			after:     changed(before[:10], 7, 9),
+			blockSize: 4,
+			expected:  []changedRange{{Offset: 4, Length: 6}},
+		}, {
+			// blocks that only exist in the new sample count as changed
+			name:      `grown`,
+			before:    before[:8],
+			after:     before[:14],
+			blockSize: 4,
+			expected:  []changedRange{{Offset: 8, Length: 6}},
+		}, {
+			name:      `shrunk`,
+			before:    before,
+			after:     before[:8],
+			blockSize: 4,
+		}, {
+			name:      `block larger than the sample`,
+			before:    before,
+			after:     changed(before, 15),
+			blockSize: 64,
+			expected:  []changedRange{{Offset: 0, Length: 16}},
+		},
+	}
+
+	for _, test := range tests {
+		ranges := changedRanges(
+			blockHashes(test.before, test.blockSize),
+			blockHashes(test.after, test.blockSize),
+			test.blockSize,
+			int64(len(test.after)),
+		)
+
+		if !reflect.DeepEqual(ranges, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, ranges)
+		}
+	}
+}